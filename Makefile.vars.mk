@@ -6,6 +6,10 @@ PROJECT_OWNER ?= bastjan
 
 ## BUILD:go
 BIN_FILENAME ?= $(PROJECT_NAME)
+VERSION ?= $(shell git describe --tags --always --dirty 2>/dev/null)
+GIT_COMMIT ?= $(shell git rev-parse HEAD 2>/dev/null)
+BUILD_DATE ?= $(shell date -u +%Y-%m-%dT%H:%M:%SZ)
+LDFLAGS ?= -X main.version=$(VERSION) -X main.commit=$(GIT_COMMIT) -X main.date=$(BUILD_DATE)
 
 ## BUILD:docker
 DOCKER_CMD ?= docker
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+
+	"go.uber.org/multierr"
+	"sigs.k8s.io/yaml"
+)
+
+// configFlag is the name of the flag selecting the config file. It can't be set in the config file itself.
+const configFlag = "config"
+
+// applyConfigFile sets the flags of fs from the YAML or JSON config file at path.
+// The file is an object mapping flag names, without the leading dash, to values, e.g. batch-size: 100.
+// A list sets a repeatable flag once per element.
+// Flags set on the command line take precedence over the file.
+// Unknown keys and invalid values are returned as errors.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var cfg map[string]any
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %q: %w", path, err)
+	}
+
+	fromCommandLine := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { fromCommandLine[f.Name] = true })
+
+	var unknown []string
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(cfg)) {
+		if name == configFlag || fs.Lookup(name) == nil {
+			unknown = append(unknown, name)
+			continue
+		}
+		if fromCommandLine[name] {
+			continue
+		}
+		values, ok := cfg[name].([]any)
+		if !ok {
+			values = []any{cfg[name]}
+		}
+		for _, v := range values {
+			s, err := configValue(v)
+			if err == nil {
+				err = fs.Set(name, s)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid value for %q: %w", name, err))
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		errs = append([]error{fmt.Errorf("unknown keys %q", unknown)}, errs...)
+	}
+	if err := multierr.Combine(errs...); err != nil {
+		return fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+	return nil
+}
+
+// configValue formats a value of the config file as a flag value.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported value %v, must be a string, number, boolean, or a list of them", v)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newConfigTestFlagSet returns a flag set with a string, an int, a bool, and a repeatable flag, parsed from args.
+func newConfigTestFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *string, *int64, *bool, *repeatableStringFlag) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dir := fs.String("dir", "", "")
+	batchSize := fs.Int64("batch-size", 500, "")
+	gzip := fs.Bool("gzip", false, "")
+	namespaces := new(repeatableStringFlag)
+	fs.Var(namespaces, "include-namespace", "")
+	fs.String(configFlag, "", "")
+	require.NoError(t, fs.Parse(args))
+	return fs, dir, batchSize, gzip, namespaces
+}
+
+// writeConfigFile writes the config file to a temporary directory and returns its path.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func Test_applyConfigFile(t *testing.T) {
+	for name, path := range map[string]string{
+		"yaml": writeConfigFile(t, "config.yaml", "dir: dump\nbatch-size: 100\ngzip: true\ninclude-namespace:\n- a\n- b\n"),
+		"json": writeConfigFile(t, "config.json", `{"dir":"dump","batch-size":100,"gzip":true,"include-namespace":["a","b"]}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			fs, dir, batchSize, gzip, namespaces := newConfigTestFlagSet(t)
+			require.NoError(t, applyConfigFile(fs, path))
+			require.Equal(t, "dump", *dir)
+			require.Equal(t, int64(100), *batchSize)
+			require.True(t, *gzip)
+			require.Equal(t, repeatableStringFlag{"a", "b"}, *namespaces, "a list should set the repeatable flag once per element")
+		})
+	}
+}
+
+func Test_applyConfigFile_CommandLinePrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "dir: dump\nbatch-size: 100\ninclude-namespace:\n- a\n- b\n")
+	fs, dir, batchSize, _, namespaces := newConfigTestFlagSet(t, "-dir=cli", "-include-namespace=c")
+	require.NoError(t, applyConfigFile(fs, path))
+	require.Equal(t, "cli", *dir)
+	require.Equal(t, repeatableStringFlag{"c"}, *namespaces, "the list of the file should not be appended to the command line values")
+	require.Equal(t, int64(100), *batchSize, "flags not on the command line should be set from the file")
+}
+
+func Test_applyConfigFile_Errors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content  string
+		expected []string
+	}{
+		"unknown keys": {
+			content:  "dir: dump\nbogus: true\nconfig: other.yaml\n",
+			expected: []string{`unknown keys ["bogus" "config"]`},
+		},
+		"invalid value": {
+			content:  "batch-size: many\n",
+			expected: []string{`invalid value for "batch-size"`},
+		},
+		"invalid list element": {
+			content:  "include-namespace:\n- a\n- {name: b}\n",
+			expected: []string{`invalid value for "include-namespace"`, "unsupported value"},
+		},
+		"unknown keys and invalid values": {
+			content:  "bogus: true\ngzip: maybe\n",
+			expected: []string{`unknown keys ["bogus"]`, `invalid value for "gzip"`},
+		},
+		"malformed yaml": {
+			content:  "dir: [dump\n",
+			expected: []string{"failed to parse config file"},
+		},
+		"malformed json": {
+			content:  `{"dir": "dump",`,
+			expected: []string{"failed to parse config file"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfigFile(t, "config.yaml", tc.content)
+			fs, _, _, _, _ := newConfigTestFlagSet(t)
+			err := applyConfigFile(fs, path)
+			require.ErrorContains(t, err, path)
+			for _, e := range tc.expected {
+				require.ErrorContains(t, err, e)
+			}
+		})
+	}
+
+	fs, _, _, _, _ := newConfigTestFlagSet(t)
+	require.ErrorContains(t, applyConfigFile(fs, filepath.Join(t.TempDir(), "missing.yaml")), "failed to read config file")
+}
@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// checkpoint tracks the progress of a dump and persists it to a file.
+// All methods are safe for concurrent use and no-ops on a nil checkpoint.
+type checkpoint struct {
+	path string
+
+	mu         sync.Mutex
+	completed  map[checkpointKey]bool
+	inProgress map[checkpointKey]string
+}
+
+// checkpointKey identifies a listed resource.
+// Namespace is only set if the resource is listed per namespace.
+type checkpointKey struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+func newCheckpointKey(res schema.GroupVersionResource, ns string) checkpointKey {
+	return checkpointKey{Group: res.Group, Version: res.Version, Resource: res.Resource, Namespace: ns}
+}
+
+func (k checkpointKey) String() string {
+	res := schema.GroupVersionResource{Group: k.Group, Version: k.Version, Resource: k.Resource}.String()
+	if k.Namespace == "" {
+		return res
+	}
+	return fmt.Sprintf("%s in namespace %s", res, k.Namespace)
+}
+
+// checkpointFile is the persisted form of a checkpoint.
+type checkpointFile struct {
+	Completed  []checkpointKey        `json:"completed"`
+	InProgress []checkpointInProgress `json:"inProgress"`
+}
+
+type checkpointInProgress struct {
+	checkpointKey
+	Continue string `json:"continue"`
+}
+
+// loadCheckpoint loads the checkpoint from the given path.
+// If the file does not exist, an empty checkpoint is returned.
+// If the path is empty, nil is returned.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	if path == "" {
+		return nil, nil
+	}
+	cp := &checkpoint{
+		path:       path,
+		completed:  map[checkpointKey]bool{},
+		inProgress: map[checkpointKey]string{},
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %q: %w", path, err)
+	}
+	var f checkpointFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %q: %w", path, err)
+	}
+	for _, k := range f.Completed {
+		cp.completed[k] = true
+	}
+	for _, ip := range f.InProgress {
+		cp.inProgress[ip.checkpointKey] = ip.Continue
+	}
+	return cp, nil
+}
+
+func (cp *checkpoint) isCompleted(k checkpointKey) bool {
+	if cp == nil {
+		return false
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.completed[k]
+}
+
+// continueToken returns the continue token of the in-progress resource or an empty string.
+func (cp *checkpoint) continueToken(k checkpointKey) string {
+	if cp == nil {
+		return ""
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.inProgress[k]
+}
+
+// saveProgress records the continue token of the in-progress resource and persists the checkpoint.
+func (cp *checkpoint) saveProgress(k checkpointKey, cont string) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.inProgress[k] = cont
+	return cp.write()
+}
+
+// complete marks the resource as completed and persists the checkpoint.
+func (cp *checkpoint) complete(k checkpointKey) error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	delete(cp.inProgress, k)
+	cp.completed[k] = true
+	return cp.write()
+}
+
+// remove deletes the checkpoint file.
+func (cp *checkpoint) remove() error {
+	if cp == nil {
+		return nil
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// write atomically persists the checkpoint by writing to a temporary file and renaming it.
+// Must be called with the lock held.
+func (cp *checkpoint) write() error {
+	var f checkpointFile
+	for k := range cp.completed {
+		f.Completed = append(f.Completed, k)
+	}
+	for k, cont := range cp.inProgress {
+		f.InProgress = append(f.InProgress, checkpointInProgress{checkpointKey: k, Continue: cont})
+	}
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cp.path), filepath.Base(cp.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cp.path)
+}
@@ -2,25 +2,52 @@ package discovery
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
+	"path"
 	"regexp"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/multierr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/version"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/utils/clock"
 )
 
 type DiscoveryOptions struct {
+	// BatchSize is the maximum number of objects listed per request.
+	// Zero uses DefaultBatchSize, NoBatchLimit lists every resource with a single request without a limit.
+	// Other negative values are rejected.
 	BatchSize int64
+	// OverrideBatchSize sets the batch size of specific resources, for example to list resources with huge objects in smaller batches.
+	// Resources not in the map or with a batch size less than one use BatchSize.
+	OverrideBatchSize map[schema.GroupResource]int64
+	// LogWriter receives the log messages, one per line, if Logger is not set.
+	// The structured attributes of the messages are not written.
 	LogWriter io.Writer
+	// Logger receives the log messages with structured attributes like gvr, namespace, count, and skipped_reason.
+	// Takes precedence over LogWriter.
+	Logger *slog.Logger
+	// Quiet suppresses the listing of the discovered resources in the log.
+	// Skipped resources, warnings, and errors are still logged.
+	Quiet bool
 
 	// MustExistResources is a list of resources that must exist in the cluster.
 	// This can be used as a sanity check to ensure that the discovery process is working as expected.
@@ -28,18 +55,348 @@ type DiscoveryOptions struct {
 	// If the list is empty, no resources are required to exist.
 	MustExistResources []string
 
+	// Resource dumps only the given resource instead of discovering all resources, e.g. deployments.apps or pods.
+	// The format is resource.group or resource.version.group, the preferred version is used if no version is given.
+	// Only the group of the resource is discovered, which is much faster than discovering all groups.
+	// The resource filters, like IncludeKinds or IgnoreResources, are not applied, the object filters are.
+	Resource string
+
 	// IgnoreResources is a list of resources to ignore during discovery.
 	IgnoreResources []*regexp.Regexp
+
+	// LabelSelector restricts the listed objects to those matching the selector.
+	// The selector is validated before any objects are listed.
+	// If empty, all objects are listed.
+	LabelSelector string
+
+	// AnnotationSelector restricts the listed objects to those having the annotation.
+	// The selector is either a key, matching objects with the annotation set to any value, or key=value.
+	// Annotations cannot be selected by the API server, the objects are filtered after listing.
+	// If empty, all objects are listed.
+	AnnotationSelector string
+
+	// FieldSelector restricts the listed objects to those matching the selector.
+	// Resources that do not support the fields used in the selector are skipped.
+	// If empty, all objects are listed.
+	FieldSelector string
+
+	// IncludeNamespaces is a list of namespaces to list namespaced resources in.
+	// If set, namespaced resources are listed per namespace instead of cluster-wide.
+	// If empty, namespaced resources are listed in all namespaces.
+	IncludeNamespaces []string
+	// ExcludeNamespaces is a list of namespaces to skip.
+	// Exclusions are applied on top of IncludeNamespaces.
+	// The Namespace objects of excluded namespaces are not dumped either.
+	ExcludeNamespaces []string
+	// ExcludeSystemNamespaces skips the namespaces in SystemNamespaces like ExcludeNamespaces.
+	// Namespaces in IncludeNamespaces are not skipped.
+	ExcludeSystemNamespaces bool
+	// ExcludeKubeNamespaces skips all namespaces with the prefix kube-, including SystemNamespaces, like ExcludeNamespaces.
+	// Namespaces in IncludeNamespaces are not skipped.
+	ExcludeKubeNamespaces bool
+
+	// IncludeKinds is a list of kinds to dump. Matched case-insensitively.
+	// Entries containing *, ?, or [ are glob patterns in the syntax of path.Match, e.g. *Policy.
+	// In glob patterns \ escapes the following character, all other entries are matched literally.
+	// If empty, all kinds are dumped.
+	IncludeKinds []string
+	// ExcludeKinds is a list of kinds or kind patterns to skip, see IncludeKinds.
+	// Exclusions are applied on top of IncludeKinds.
+	ExcludeKinds []string
+
+	// ExcludeGroupKinds is a list of group kinds to skip. Kinds are matched case-insensitively, groups exactly.
+	// See LoadGroupKindsFile to load the list from a file.
+	ExcludeGroupKinds []schema.GroupKind
+
+	// ExcludeOwnedBy is a list of kinds whose owned objects are skipped, for example ReplicaSets owned by Deployments.
+	// Matched case-insensitively against the kinds of the objects' owner references.
+	ExcludeOwnedBy []string
+
+	// ExcludeNameRegex skips objects whose name matches the regular expression, for example temporary objects.
+	// The expression is not anchored. The expression is validated before any objects are listed.
+	// If empty, no objects are skipped.
+	ExcludeNameRegex string
+
+	// MaxObjectBytes skips objects whose JSON serialization is larger than the given number of bytes.
+	// The size is measured after all transforms. Every skipped object is logged.
+	// Zero or less disables the check.
+	MaxObjectBytes int64
+
+	// MaxObjects stops the dump once the given number of objects was dumped across all resources, for example to sample a large cluster.
+	// The batch reaching the limit is truncated and dumped, no further batches are listed.
+	// The returned error then contains ErrMaxObjectsReached.
+	// It is ignored with DryRun. Zero or less disables the limit.
+	MaxObjects int64
+
+	// CreatedAfter skips objects created before the given time if not zero.
+	CreatedAfter time.Time
+	// CreatedBefore skips objects created at or after the given time if not zero.
+	// Objects with a missing or unparseable creation timestamp are never skipped by CreatedAfter or CreatedBefore.
+	CreatedBefore time.Time
+
+	// IncludeGroups is a list of API groups to dump. The empty string represents the core group.
+	// If empty, all groups are dumped.
+	IncludeGroups []string
+	// ExcludeGroups is a list of API groups to skip. The empty string represents the core group.
+	// Exclusions are applied on top of IncludeGroups.
+	ExcludeGroups []string
+	// IncludeGroupVersions is a list of group versions to dump, see ParseGroupVersion.
+	// Unlike IncludeGroups it selects exact versions, including versions that are not the preferred version of their group.
+	// If empty, all group versions are dumped.
+	IncludeGroupVersions []schema.GroupVersion
+
+	// Concurrency is the number of resources listed in parallel.
+	// If greater than one, the callback passed to DiscoverObjects is called from multiple goroutines.
+	// Defaults to 1.
+	Concurrency int
+	// NamespaceConcurrency is the number of namespaces a namespaced resource is listed from in parallel if IncludeNamespaces is set.
+	// The bound applies per resource, up to Concurrency * NamespaceConcurrency list calls run at the same time.
+	// If greater than one, the callback passed to DiscoverObjects is called from multiple goroutines.
+	// Defaults to 1.
+	NamespaceConcurrency int
+
+	// MaxRetries is the number of times a failed list call is retried if the error is transient.
+	// Transient errors are rate limiting, server timeouts, internal server errors, and connection resets.
+	// Defaults to 0, no retries.
+	MaxRetries int
+	// RetryBackoff is the initial wait time before retrying a failed list call or callback.
+	// The wait time doubles with each retry and is jittered.
+	// Defaults to 1s.
+	RetryBackoff time.Duration
+	// DumpRetries is the number of times the callback is called again with the same list if it returns an error,
+	// for example if the dump target is flaky network storage.
+	// The callback must not leave partially written objects on error or the retried objects are written twice.
+	// The DirDumper guarantees this only for files written with a single object, see dumper.DirDumper.
+	// Defaults to 0, no retries.
+	DumpRetries int
+
+	// QPS is the maximum queries per second to the API server.
+	// If zero, the value of the passed rest.Config is used.
+	QPS float32
+	// Burst is the maximum burst of queries to the API server.
+	// If zero, the value of the passed rest.Config is used.
+	Burst int
+
+	// Impersonate makes all requests, including the discovery, as the given user, groups, and UID.
+	// The user of the passed rest.Config must be allowed to impersonate them.
+	// If empty, the impersonation of the passed rest.Config is used.
+	Impersonate rest.ImpersonationConfig
+
+	// UserAgent is the User-Agent header of all requests, for example k8s-object-dumper/v1.2.3.
+	// If empty, the user agent of the passed rest.Config is used.
+	UserAgent string
+	// Headers are extra HTTP headers set on all requests, including the discovery.
+	// They are added by wrapping the transport of the passed rest.Config and replace headers of the same name set by client-go.
+	Headers http.Header
+
+	// DiscoveryCacheDir caches the discovered resources in a directory per API server host, like the discovery cache of kubectl.
+	// Dumps within DiscoveryCacheTTL of the cached discovery skip the discovery requests.
+	// Discoveries with errors, for example of groups failing discovery, are not cached.
+	// Resources created after caching, for example by installing a CRD, are only dumped once the cache expired.
+	// If empty, the resources are discovered on every dump.
+	DiscoveryCacheDir string
+	// DiscoveryCacheTTL is the time the cached discovery is used for.
+	// Defaults to DefaultDiscoveryCacheTTL.
+	DiscoveryCacheTTL time.Duration
+	// RefreshDiscovery ignores the cached discovery and replaces it with a new discovery.
+	RefreshDiscovery bool
+
+	// IncludeEvents enables dumping of events.
+	// Events are skipped by default since they are numerous, short-lived, and rarely useful in a dump.
+	IncludeEvents bool
+
+	// StripManagedFields removes metadata.managedFields from every object before calling the callback.
+	StripManagedFields bool
+
+	// StripStatus removes the top-level status field from every object before calling the callback.
+	StripStatus bool
+
+	// StableOutput removes metadata fields changing on every write, for diffing consecutive dumps.
+	// The removed fields are resourceVersion, uid, generation, creationTimestamp, and managedFields.
+	// The output is meant for diffing, not for restoring: the objects lose their identity.
+	StableOutput bool
+
+	// SortItems sorts the objects of every batch by namespace, then name, before calling the callback.
+	// Only the objects within a batch are sorted, the batches are passed to the callback in listing order.
+	// Sorting across batches would require buffering all objects of a resource and is not supported.
+	SortItems bool
+
+	// RestoreOrder lists Namespaces and CustomResourceDefinitions before all other resources.
+	// All other resources are only listed after both completed, also with Concurrency greater than one.
+	// Restoring a stream dump in order then creates the namespaces and custom resource types before the objects needing them.
+	RestoreOrder bool
+
+	// CheckpointFile is the path to a file the progress of the dump is persisted to after every batch.
+	// If the file exists when starting, the dump resumes from the persisted progress:
+	// completed resources are skipped and the in-progress resources continue from the last continue token.
+	// The file is removed after a dump without errors.
+	// If empty, no checkpoint is written.
+	CheckpointFile string
+
+	// WatermarkFile is the path to a file with the highest resourceVersion of every resource seen by the previous dump.
+	// If the file exists, only objects with a resourceVersion greater than the watermark of their resource are dumped.
+	// After the dump the highest resourceVersion of every resource is written to the file.
+	// Resources failing to list, or cut short by MaxObjects, keep their previous watermark.
+	// The resourceVersions are compared as numbers, which the Kubernetes API does not guarantee but holds for etcd-backed resources.
+	// Objects with non-numeric resourceVersions are always dumped.
+	// Deleted objects are not captured by watermark dumps.
+	// If empty, all objects are dumped.
+	WatermarkFile string
+
+	// SinceFile is the path to a file with the newest creation timestamp of the objects dumped by the previous dump, in RFC 3339 format.
+	// If the file exists, objects created before the timestamp are skipped like with CreatedAfter. The later of both times is used.
+	// After a dump without errors the newest creation timestamp of the dumped objects is written to the file.
+	// Creation timestamps have a resolution of one second, objects created in the second of the timestamp are dumped again by the next dump.
+	// Modified objects are not captured, see WatermarkFile.
+	// If empty, no file is read or written.
+	SinceFile string
+
+	// ExcludeUIDs are the UIDs of objects not to dump, for example the objects of a previous dump read from its manifest.
+	// Together with the manifest of the previous dump a delta dump only writes the objects created since.
+	ExcludeUIDs []types.UID
+
+	// Scope restricts the listed resources to namespaced or cluster-scoped resources.
+	// Defaults to ScopeAll.
+	Scope Scope
+
+	// SkipForbidden skips resources the user is not allowed to list instead of returning an error.
+	SkipForbidden bool
+
+	// AllVersions dumps every served version of every resource instead of only the preferred version.
+	// Objects are then dumped once per version. Use DirDumperOptions.IncludeVersion to keep the versions apart.
+	AllVersions bool
+
+	// DryRun only estimates the number of objects of every resource without calling the callback.
+	// A single object is listed per resource and the count is taken from the remaining item count reported by the API server.
+	// The estimates are logged and returned as ResourceStat.Count.
+	// The checkpoint is neither read nor written.
+	DryRun bool
+
+	// WatchDuration watches every resource for the duration instead of listing it.
+	// The watches start at the current resourceVersion and only objects added or modified while watching are passed to the callback,
+	// deleted objects are ignored.
+	// Watches with an expired resourceVersion are restarted from the current resourceVersion, changes in between are missed and a warning is logged.
+	// All resources, and with IncludeNamespaces all namespaces, are watched at the same time, Concurrency and NamespaceConcurrency are ignored.
+	// Cannot be combined with DryRun, CheckpointFile, or RestoreOrder.
+	// If zero, the resources are listed.
+	WatchDuration time.Duration
+
+	// Progress is called after every listed batch and after every resource is completely listed.
+	// It is called from multiple goroutines if Concurrency or NamespaceConcurrency is greater than one.
+	// If nil, no progress is reported.
+	Progress func(ProgressEvent)
+
+	// ServerVersion is called with the version of the API server before any objects are listed, for example to record it in a manifest.
+	// It is not called if the version can't be fetched, the dump then continues with a warning.
+	// If nil, the version is only logged.
+	ServerVersion func(*version.Info)
+
+	// Clock is the time source of the resource durations, the timeout error, and the discovery cache, for example a fake clock in tests.
+	// Waits, like retry backoffs and the watch duration, always use the real time.
+	// Defaults to the real clock.
+	Clock clock.PassiveClock
+
+	// Metrics are updated while discovering and listing objects.
+	// If nil, no metrics are recorded.
+	Metrics *Metrics
+
+	// Transform is called with every object before it is passed to the callback and may modify it in place,
+	// for example to scrub sensitive fields of custom resources.
+	// It runs after the built-in transformations, e.g. StripStatus or the Secret redaction, in the goroutine listing the resource.
+	// If it returns an error, the object is skipped and the error is returned by DiscoverObjects.
+	// If nil, objects are passed unchanged.
+	Transform TransformFunc
+
+	// IncludeSecretData disables the redaction of Secret data.
+	// By default the values of the data and stringData fields of Secrets are replaced with a placeholder.
+	// The keys are preserved.
+	IncludeSecretData bool
+
+	// RedactPaths are JSONPath expressions, e.g. {.spec.credentials.password}, selecting values replaced with a placeholder in every object.
+	// If a selected value is a map or an array, all values it contains are replaced. Objects without a selected value are unchanged.
+	// The expressions are validated before any objects are listed.
+	RedactPaths []string
+}
+
+// Scope is the scope of the resources to list.
+type Scope string
+
+const (
+	ScopeAll        Scope = "all"
+	ScopeNamespaced Scope = "namespaced"
+	ScopeCluster    Scope = "cluster"
+)
+
+// ParseScope parses the given string into a Scope.
+// An error is returned if the scope is unknown.
+func ParseScope(s string) (Scope, error) {
+	switch sc := Scope(s); sc {
+	case ScopeAll, ScopeNamespaced, ScopeCluster:
+		return sc, nil
+	}
+	return "", fmt.Errorf("unknown scope %q, must be one of %q, %q, %q", s, ScopeAll, ScopeNamespaced, ScopeCluster)
+}
+
+// ProgressEvent reports the progress of listing a resource.
+type ProgressEvent struct {
+	// Resource is the listed resource.
+	Resource schema.GroupVersionResource
+	// Namespace is the namespace the batch is listed from if namespaced resources are listed per namespace.
+	Namespace string
+	// BatchCount is the number of objects in the current batch. Zero if Complete is true.
+	BatchCount int
+	// Count is the cumulative number of objects listed for the resource.
+	Count int
+	// Complete is true if the resource is completely listed.
+	Complete bool
+}
+
+var namespacesGR = schema.GroupResource{Resource: "namespaces"}
+
+// SystemNamespaces are the namespaces created by Kubernetes, skipped with DiscoveryOptions.ExcludeSystemNamespaces.
+var SystemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// restoreOrderGRs are the group resources listed first with DiscoveryOptions.RestoreOrder.
+var restoreOrderGRs = []schema.GroupResource{
+	namespacesGR,
+	{Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
 }
 
+// eventsGRs are the group resources of events.
+var eventsGRs = []schema.GroupResource{
+	{Resource: "events"},
+	{Group: "events.k8s.io", Resource: "events"},
+}
+
+const (
+	// DefaultBatchSize is the batch size used if DiscoveryOptions.BatchSize is zero.
+	DefaultBatchSize int64 = 500
+	// NoBatchLimit as DiscoveryOptions.BatchSize lists objects without a limit.
+	// The API server then returns all objects of a resource in a single response, which can be huge.
+	NoBatchLimit int64 = -1
+)
+
 // GetBatchSize returns the set batch size for listing objects or the default.
+// It returns zero, the list limit for no limit, if BatchSize is NoBatchLimit.
 func (opts DiscoveryOptions) GetBatchSize() int64 {
-	if opts.BatchSize == 0 {
-		return 500
+	switch opts.BatchSize {
+	case 0:
+		return DefaultBatchSize
+	case NoBatchLimit:
+		return 0
 	}
 	return opts.BatchSize
 }
 
+// GetBatchSizeFor returns the batch size for listing objects of the resource.
+// It returns the override of the resource if set or GetBatchSize otherwise.
+func (opts DiscoveryOptions) GetBatchSizeFor(gr schema.GroupResource) int64 {
+	if s := opts.OverrideBatchSize[gr]; s > 0 {
+		return s
+	}
+	return opts.GetBatchSize()
+}
+
 // GetLogWriter returns the set batch size for listing objects or io.Discard as default.
 func (opts DiscoveryOptions) GetLogWriter() io.Writer {
 	if opts.LogWriter == nil {
@@ -48,31 +405,577 @@ func (opts DiscoveryOptions) GetLogWriter() io.Writer {
 	return opts.LogWriter
 }
 
+// GetLogger returns the set logger or a logger writing the messages line by line to the LogWriter as default.
+func (opts DiscoveryOptions) GetLogger() *slog.Logger {
+	if opts.Logger == nil {
+		return slog.New(newLineHandler(opts.GetLogWriter()))
+	}
+	return opts.Logger
+}
+
+// GetRetryBackoff returns the set initial wait time between retries or the default.
+func (opts DiscoveryOptions) GetRetryBackoff() time.Duration {
+	if opts.RetryBackoff <= 0 {
+		return time.Second
+	}
+	return opts.RetryBackoff
+}
+
+// GetClock returns the set clock or the real clock.
+func (opts DiscoveryOptions) GetClock() clock.PassiveClock {
+	if opts.Clock == nil {
+		return clock.RealClock{}
+	}
+	return opts.Clock
+}
+
+// GetDiscoveryCacheTTL returns the set time the cached discovery is used for or the default.
+func (opts DiscoveryOptions) GetDiscoveryCacheTTL() time.Duration {
+	if opts.DiscoveryCacheTTL <= 0 {
+		return DefaultDiscoveryCacheTTL
+	}
+	return opts.DiscoveryCacheTTL
+}
+
+// GetConcurrency returns the set number of resources listed in parallel or the default.
+func (opts DiscoveryOptions) GetConcurrency() int {
+	if opts.Concurrency < 1 {
+		return 1
+	}
+	return opts.Concurrency
+}
+
+// GetNamespaceConcurrency returns the set number of namespaces listed in parallel per resource or the default.
+func (opts DiscoveryOptions) GetNamespaceConcurrency() int {
+	if opts.NamespaceConcurrency < 1 {
+		return 1
+	}
+	return opts.NamespaceConcurrency
+}
+
 // DiscoverObjects discovers all objects in the cluster and calls the provided callback for each list of objects.
-// The callback can be called multiple times with the same
+// The callback can be called multiple times with the same resource.
+// The callback is called from multiple goroutines if opts.Concurrency or opts.NamespaceConcurrency is greater than one and must then be safe for concurrent use.
+// If ctx is cancelled, no further batches are listed and the returned error wraps the context's error.
+// OpenTelemetry spans are started for the discovery, every resource, and every list call using the global tracer provider.
+// If objects could not be dumped, the returned error is a *DumpError with the errors of every failed resource.
 func DiscoverObjects(ctx context.Context, conf *rest.Config, cb func(*unstructured.UnstructuredList) error, opts DiscoveryOptions) error {
-	batchSize := opts.GetBatchSize()
-	logWriter := opts.GetLogWriter()
+	_, err := DiscoverObjectsWithStats(ctx, conf, cb, opts)
+	return err
+}
+
+// SkipReasonForbidden is the ResourceStat.SkipReason of resources skipped because of DiscoveryOptions.SkipForbidden.
+const SkipReasonForbidden = "forbidden"
 
+// ResourceStat contains statistics about a discovered resource.
+type ResourceStat struct {
+	Resource schema.GroupVersionResource
+	// Count is the number of objects passed to the callback.
+	Count int
+	// Batches is the number of listed batches.
+	Batches int
+	// Duration is the time it took to list and dump the resource.
+	Duration time.Duration
+	// Skipped is true if the resource was not listed.
+	Skipped bool
+	// SkipReason is the reason the resource was skipped.
+	SkipReason string
+	// Failed is true if there was an error listing or dumping the resource.
+	Failed bool
+	// ExcludedByOwner is the number of objects dropped because of DiscoveryOptions.ExcludeOwnedBy.
+	ExcludedByOwner int
+	// ExcludedByName is the number of objects dropped because of DiscoveryOptions.ExcludeNameRegex.
+	ExcludedByName int
+	// ExcludedBySize is the number of objects dropped because of DiscoveryOptions.MaxObjectBytes.
+	ExcludedBySize int
+	// ExcludedByWatermark is the number of objects dropped because they did not change since the DiscoveryOptions.WatermarkFile was written.
+	ExcludedByWatermark int
+	// ExcludedByUID is the number of objects dropped because their UID is in DiscoveryOptions.ExcludeUIDs.
+	ExcludedByUID int
+	// ExcludedByCreationTime is the number of objects dropped because of DiscoveryOptions.CreatedAfter or DiscoveryOptions.CreatedBefore.
+	ExcludedByCreationTime int
+	// ExcludedByAnnotation is the number of objects dropped because of DiscoveryOptions.AnnotationSelector.
+	ExcludedByAnnotation int
+	// UnknownCreationTime is the number of objects kept despite CreatedAfter or CreatedBefore because their creation timestamp is missing or unparseable.
+	UnknownCreationTime int
+}
+
+// add adds the counts of o, listed from another namespace of the same resource, to s.
+func (s *ResourceStat) add(o ResourceStat) {
+	s.Count += o.Count
+	s.Batches += o.Batches
+	s.ExcludedByOwner += o.ExcludedByOwner
+	s.ExcludedByName += o.ExcludedByName
+	s.ExcludedBySize += o.ExcludedBySize
+	s.ExcludedByWatermark += o.ExcludedByWatermark
+	s.ExcludedByUID += o.ExcludedByUID
+	s.ExcludedByCreationTime += o.ExcludedByCreationTime
+	s.ExcludedByAnnotation += o.ExcludedByAnnotation
+	s.UnknownCreationTime += o.UnknownCreationTime
+	if o.Skipped {
+		s.Skipped = true
+		s.SkipReason = o.SkipReason
+	}
+}
+
+// DiscoverObjectsWithStats works like DiscoverObjects but additionally returns statistics for every discovered resource.
+// The statistics are sorted by resource.
+// The statistics might be incomplete or nil if an error is returned.
+// An error is returned before connecting if the config uses an exec credential plugin that is not installed, see rest.ExecConfig.
+func DiscoverObjectsWithStats(ctx context.Context, conf *rest.Config, cb func(*unstructured.UnstructuredList) error, opts DiscoveryOptions) ([]ResourceStat, error) {
+	if err := checkExecProvider(conf); err != nil {
+		return nil, err
+	}
+	conf = opts.restConfig(conf)
 	dc, err := discovery.NewDiscoveryClientForConfig(conf)
 	if err != nil {
-		return fmt.Errorf("failed to create discovery client: %w", err)
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
 	}
 	dynClient, err := dynamic.NewForConfig(conf)
 	if err != nil {
-		return fmt.Errorf("failed to create dynamic client: %w", err)
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
+	return DiscoverObjectsWithClients(ctx, opts.cachedDiscovery(dc, conf.Host), dynClient, cb, opts)
+}
 
-	sprl, err := dc.ServerPreferredResources()
+// DiscoverObjectsWithClients works like DiscoverObjectsWithStats but uses the given clients instead of creating them from a rest.Config.
+// The clients can be shared across runs or be fakes for testing.
+// QPS, Burst, Impersonate, UserAgent, Headers, and the discovery cache of opts are ignored since they configure the creation of the clients.
+func DiscoverObjectsWithClients(ctx context.Context, dc discovery.DiscoveryInterface, dynClient dynamic.Interface, cb func(*unstructured.UnstructuredList) error, opts DiscoveryOptions) ([]ResourceStat, error) {
+	start := opts.GetClock().Now()
+	ctx, span := tracer().Start(ctx, "DiscoverObjects")
+	defer span.End()
+	batchSize := opts.GetBatchSize()
+	log := opts.GetLogger()
+
+	if opts.BatchSize < NoBatchLimit {
+		return nil, fmt.Errorf("invalid batch size %d, must be positive, 0 for the default of %d, or %d for no limit", opts.BatchSize, DefaultBatchSize, NoBatchLimit)
+	}
+	if _, err := labels.Parse(opts.LabelSelector); err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", opts.LabelSelector, err)
+	}
+	if _, err := fields.ParseSelector(opts.FieldSelector); err != nil {
+		return nil, fmt.Errorf("invalid field selector %q: %w", opts.FieldSelector, err)
+	}
+	annotationMatches, err := parseAnnotationSelector(opts.AnnotationSelector)
 	if err != nil {
-		return fmt.Errorf("failed to get server preferred resources: %w", err)
+		return nil, fmt.Errorf("invalid annotation selector %q: %w", opts.AnnotationSelector, err)
+	}
+	var excludeName *regexp.Regexp
+	if opts.ExcludeNameRegex != "" {
+		if excludeName, err = regexp.Compile(opts.ExcludeNameRegex); err != nil {
+			return nil, fmt.Errorf("invalid exclude name regex %q: %w", opts.ExcludeNameRegex, err)
+		}
+	}
+	if opts.Scope != "" {
+		if _, err := ParseScope(string(opts.Scope)); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.validateKindPatterns(); err != nil {
+		return nil, err
+	}
+	transforms, err := opts.transforms()
+	if err != nil {
+		return nil, err
+	}
+	if opts.WatchDuration > 0 && (opts.DryRun || opts.CheckpointFile != "" || opts.RestoreOrder) {
+		return nil, fmt.Errorf("WatchDuration cannot be combined with DryRun, CheckpointFile, or RestoreOrder")
 	}
 
-	fmt.Fprintln(logWriter, "Discovered resources:")
-	for _, re := range sprl {
-		fmt.Fprintln(logWriter, re.GroupVersion)
-		for _, r := range re.APIResources {
-			fmt.Fprintln(logWriter, "  ", r.Kind)
+	opts.logServerVersion(dc, log)
+	discovered, err := opts.discoverResources(dc, log)
+	if err != nil {
+		return nil, err
+	}
+
+	excludedNamespaces := sets.New(opts.ExcludeNamespaces...)
+	namespaces := slices.DeleteFunc(slices.Clone(opts.IncludeNamespaces), excludedNamespaces.Has)
+	includedNamespaces := sets.New(opts.IncludeNamespaces...)
+	excluded := func(ns string) bool {
+		// Explicitly included namespaces win over the system namespace exclusion, explicit exclusions win over both.
+		return excludedNamespaces.Has(ns) || (!includedNamespaces.Has(ns) && opts.systemNamespace(ns))
+	}
+	keep := func(res schema.GroupVersionResource, o unstructured.Unstructured) bool {
+		if res.GroupResource() == namespacesGR {
+			return !excluded(o.GetName())
+		}
+		return !excluded(o.GetNamespace())
+	}
+	var cp *checkpoint
+	var wm *watermarks
+	var sf *sinceFile
+	if !opts.DryRun {
+		cp, err = loadCheckpoint(opts.CheckpointFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+		}
+		wm, err = loadWatermarks(opts.WatermarkFile)
+		if err != nil {
+			return nil, err
+		}
+		sf, err = loadSinceFile(opts.SinceFile)
+		if err != nil {
+			return nil, err
+		}
+		if sf != nil && sf.since.After(opts.CreatedAfter) {
+			log.Info(fmt.Sprintf("dumping objects created since %s according to %s", sf.since.Format(time.RFC3339), opts.SinceFile), "since", sf.since)
+			opts.CreatedAfter = sf.since
+		}
+	}
+	rl := &lister{
+		opts:   opts,
+		client: dynClient,
+		listOpts: metav1.ListOptions{
+			Limit:         batchSize,
+			LabelSelector: opts.LabelSelector,
+			FieldSelector: opts.FieldSelector,
+		},
+		namespaces:        namespaces,
+		keep:              keep,
+		annotationMatches: annotationMatches,
+		excludeName:       excludeName,
+		transforms:        transforms,
+		cb:                cb,
+		log:               log,
+		checkpoint:        cp,
+		watermarks:        wm,
+		since:             sf,
+	}
+	if len(opts.ExcludeUIDs) > 0 {
+		rl.excludeUIDs = sets.New(opts.ExcludeUIDs...)
+	}
+	if opts.WatchDuration > 0 {
+		// All watches share the deadline, so the dump ends after the duration even if some watches start late.
+		rl.watchUntil = time.Now().Add(opts.WatchDuration)
+	}
+	if opts.MaxObjects > 0 {
+		// Running jobs stop at their next batch, like on an interrupt.
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		rl.stop = cancel
+	}
+
+	jobs := discovered.jobs
+	stats := discovered.skipped
+	opts.Metrics.addSkipped(len(discovered.skipped))
+	opts.Metrics.addErrors(len(discovered.errors))
+
+	var mu sync.Mutex
+	var jobErrors []listJobErrors
+	runJobs := func(jobs []listJob) {
+		jobCh := make(chan listJob)
+		var wg sync.WaitGroup
+		workers := opts.GetConcurrency()
+		if opts.WatchDuration > 0 {
+			// A watch blocks its goroutine for the whole duration, all resources must be watched at the same time.
+			workers = len(jobs)
+		}
+		for range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range jobCh {
+					stat, errs := rl.run(ctx, j)
+					mu.Lock()
+					stats = append(stats, stat)
+					if len(errs) > 0 {
+						jobErrors = append(jobErrors, listJobErrors{res: j.res, errs: errs, time: opts.GetClock().Now()})
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	dispatch:
+		for _, j := range jobs {
+			select {
+			case jobCh <- j:
+			case <-ctx.Done():
+				// Stop handing out jobs, running jobs return at their next batch.
+				break dispatch
+			}
+		}
+		close(jobCh)
+		wg.Wait()
+	}
+	if opts.RestoreOrder {
+		// The first pass must complete before the second pass starts.
+		first, rest := partitionJobs(jobs, restoreOrderGRs)
+		runJobs(first)
+		if ctx.Err() == nil {
+			runJobs(rest)
+		}
+	} else {
+		runJobs(jobs)
+	}
+
+	slices.SortStableFunc(jobErrors, func(a, b listJobErrors) int {
+		return strings.Compare(a.res.String(), b.res.String())
+	})
+	slices.SortStableFunc(stats, func(a, b ResourceStat) int {
+		return strings.Compare(a.Resource.String(), b.Resource.String())
+	})
+	errs := make([]ResourceError, 0, len(discovered.errors))
+	for _, err := range discovered.errors {
+		errs = append(errs, ResourceError{Err: err})
+	}
+	for _, je := range jobErrors {
+		for _, err := range je.errs {
+			errs = append(errs, ResourceError{Resource: je.res, Err: err, Time: je.time})
+		}
+	}
+	if err := wm.write(); err != nil {
+		errs = append(errs, ResourceError{Err: fmt.Errorf("failed to write watermarks: %w", err)})
+	}
+	errs = withContextError(ctx, opts.GetClock().Since(start), errs)
+	if len(errs) == 0 {
+		if err := cp.remove(); err != nil {
+			errs = append(errs, ResourceError{Err: fmt.Errorf("failed to remove checkpoint: %w", err)})
+		}
+		if err := sf.write(); err != nil {
+			errs = append(errs, ResourceError{Err: fmt.Errorf("failed to write since file: %w", err)})
+		}
+	}
+	if len(errs) == 0 {
+		return stats, nil
+	}
+	now := opts.GetClock().Now()
+	for i := range errs {
+		if errs[i].Time.IsZero() {
+			errs[i].Time = now
+		}
+	}
+	dumpErr := newDumpError(errs)
+	recordErrors(span, dumpErr.Unwrap()...)
+	return stats, dumpErr
+}
+
+// systemNamespace returns true if the namespace is skipped by ExcludeSystemNamespaces or ExcludeKubeNamespaces.
+// Cluster-scoped objects, with an empty namespace, are never in a system namespace.
+func (opts DiscoveryOptions) systemNamespace(ns string) bool {
+	switch {
+	case ns == "":
+		return false
+	case opts.ExcludeKubeNamespaces:
+		return strings.HasPrefix(ns, "kube-")
+	case opts.ExcludeSystemNamespaces:
+		return slices.Contains(SystemNamespaces, ns)
+	}
+	return false
+}
+
+// partitionJobs splits the jobs into the jobs of the group resources and the remaining jobs, keeping their order.
+func partitionJobs(jobs []listJob, grs []schema.GroupResource) (matching, rest []listJob) {
+	for _, j := range jobs {
+		if slices.Contains(grs, j.res.GroupResource()) {
+			matching = append(matching, j)
+		} else {
+			rest = append(rest, j)
+		}
+	}
+	return matching, rest
+}
+
+// ListDumpableResources discovers the resources of the cluster and returns the resources DiscoverObjects would list with the given options.
+// The resources are filtered by group, kind, scope, ignore patterns, and the list verb, and are returned in discovery order.
+// Groups failing discovery are skipped. Their errors are returned together with the resources of the other groups.
+func ListDumpableResources(ctx context.Context, conf *rest.Config, opts DiscoveryOptions) ([]schema.GroupVersionResource, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts.Scope != "" {
+		if _, err := ParseScope(string(opts.Scope)); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.validateKindPatterns(); err != nil {
+		return nil, err
+	}
+	if err := checkExecProvider(conf); err != nil {
+		return nil, err
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(opts.restConfig(conf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	discovered, err := opts.discoverResources(opts.cachedDiscovery(dc, conf.Host), opts.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+	return discovered.resources(), multierr.Combine(discovered.errors...)
+}
+
+// FilterDumpableResources returns the resources DiscoverObjects would list with the given options from pre-fetched resource lists,
+// for example decoded from a saved discovery document. No connection to a cluster is made.
+// The lists are expected in the order returned by ServerPreferredResources of a discovery client,
+// or ServerGroupsAndResources if opts.AllVersions or opts.IncludeGroupVersions is set.
+// The resources are returned in the order of the lists.
+func FilterDumpableResources(lists []*metav1.APIResourceList, opts DiscoveryOptions) ([]schema.GroupVersionResource, error) {
+	if opts.Scope != "" {
+		if _, err := ParseScope(string(opts.Scope)); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.validateKindPatterns(); err != nil {
+		return nil, err
+	}
+	filtered, err := opts.filterResources(lists, opts.GetLogger())
+	if err != nil {
+		return nil, err
+	}
+	return filtered.resources(), nil
+}
+
+// restConfig returns a copy of conf with the QPS, burst, impersonation, user agent, and header overrides of opts applied.
+// All clients must be created from the returned config.
+func (opts DiscoveryOptions) restConfig(conf *rest.Config) *rest.Config {
+	conf = rest.CopyConfig(conf)
+	if opts.Impersonate.UserName != "" || opts.Impersonate.UID != "" || len(opts.Impersonate.Groups) > 0 || len(opts.Impersonate.Extra) > 0 {
+		conf.Impersonate = opts.Impersonate
+	}
+	if opts.QPS != 0 {
+		conf.QPS = opts.QPS
+	}
+	if opts.Burst != 0 {
+		conf.Burst = opts.Burst
+	}
+	if opts.UserAgent != "" {
+		conf.UserAgent = opts.UserAgent
+	}
+	if len(opts.Headers) > 0 {
+		headers := opts.Headers
+		conf.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return newHeaderRoundTripper(headers, rt)
+		})
+	}
+	return conf
+}
+
+// discoveredResources is the result of discoverResources.
+type discoveredResources struct {
+	// jobs are the resources to list.
+	jobs []listJob
+	// skipped are the stats of the filtered resources.
+	skipped []ResourceStat
+	// errors are the errors of the groups failing discovery.
+	errors []error
+}
+
+// resources returns the resources to list.
+func (d discoveredResources) resources() []schema.GroupVersionResource {
+	resources := make([]schema.GroupVersionResource, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		resources = append(resources, j.res)
+	}
+	return resources
+}
+
+// logServerVersion logs the version of the API server and passes it to opts.ServerVersion.
+// Failing to fetch the version is logged as a warning.
+func (opts DiscoveryOptions) logServerVersion(dc discovery.DiscoveryInterface, log *slog.Logger) {
+	v, err := dc.ServerVersion()
+	if err != nil {
+		log.Warn(fmt.Sprintf("warning: failed to get the server version: %v", err), "error", err)
+		return
+	}
+	log.Info(fmt.Sprintf("Kubernetes server version %s", v.GitVersion), "server_version", v.GitVersion, "platform", v.Platform)
+	if opts.ServerVersion != nil {
+		opts.ServerVersion(v)
+	}
+}
+
+// discoverResources discovers the resources of the cluster and filters them by opts.
+// An error is returned if discovery fails completely or a resource of opts.MustExistResources is missing.
+func (opts DiscoveryOptions) discoverResources(dc discovery.DiscoveryInterface, log *slog.Logger) (discoveredResources, error) {
+	if opts.Resource != "" {
+		j, err := resolveResource(dc, opts.Resource)
+		if err != nil {
+			return discoveredResources{}, err
+		}
+		log.Info(fmt.Sprintf("Resolved %s to %s", opts.Resource, j.res), gvrAttr(j.res))
+		return discoveredResources{jobs: []listJob{j}}, nil
+	}
+
+	var sprl []*metav1.APIResourceList
+	var err error
+	if opts.AllVersions || len(opts.IncludeGroupVersions) > 0 {
+		// Selected group versions are not necessarily preferred.
+		_, sprl, err = dc.ServerGroupsAndResources()
+	} else {
+		sprl, err = dc.ServerPreferredResources()
+	}
+	// Groups failing discovery, e.g. broken aggregated APIs, are reported but do not stop the dump of the other groups.
+	discoveryErrors, err := groupDiscoveryErrors(err)
+	if err != nil {
+		return discoveredResources{}, fmt.Errorf("failed to discover resources: %w", err)
+	}
+	for _, err := range discoveryErrors {
+		log.Error(err.Error(), "error", err)
+	}
+	d, err := opts.filterResources(sprl, log)
+	if err != nil {
+		return discoveredResources{}, err
+	}
+	d.errors = discoveryErrors
+	return d, nil
+}
+
+// resolveResource resolves a resource in the format resource.group or resource.version.group to a listJob.
+// Only the group of the resource is discovered.
+func resolveResource(dc discovery.DiscoveryInterface, resource string) (listJob, error) {
+	fullySpecified, gr := schema.ParseResourceArg(resource)
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return listJob{}, fmt.Errorf("failed to discover groups: %w", err)
+	}
+	var gv string
+	for _, g := range groups.Groups {
+		if fullySpecified != nil && g.Name == fullySpecified.Group && slices.ContainsFunc(g.Versions, func(v metav1.GroupVersionForDiscovery) bool {
+			return v.Version == fullySpecified.Version
+		}) {
+			gr = fullySpecified.GroupResource()
+			gv = fullySpecified.GroupVersion().String()
+			break
+		}
+		if g.Name == gr.Group {
+			gv = g.PreferredVersion.GroupVersion
+		}
+	}
+	if gv == "" {
+		return listJob{}, fmt.Errorf("resource %q not found: no such group or version", resource)
+	}
+	rl, err := dc.ServerResourcesForGroupVersion(gv)
+	if err != nil {
+		return listJob{}, fmt.Errorf("failed to discover resources of %s: %w", gv, err)
+	}
+	for _, r := range rl.APIResources {
+		if r.Name != gr.Resource {
+			continue
+		}
+		res := groupVersionFromString(gv).WithResource(r.Name)
+		if !slices.Contains(r.Verbs, "list") {
+			return listJob{}, fmt.Errorf("resource %s can't be listed: no list verb", res)
+		}
+		return listJob{res: res, namespaced: r.Namespaced}, nil
+	}
+	return listJob{}, fmt.Errorf("resource %q not found in %s", resource, gv)
+}
+
+// filterResources filters the discovered resource lists by opts.
+// An error is returned if a resource of opts.MustExistResources is missing.
+func (opts DiscoveryOptions) filterResources(sprl []*metav1.APIResourceList, log *slog.Logger) (discoveredResources, error) {
+	if !opts.Quiet {
+		log.Info("Discovered resources:")
+		for _, re := range sprl {
+			var msg strings.Builder
+			msg.WriteString(re.GroupVersion)
+			kinds := make([]string, 0, len(re.APIResources))
+			for _, r := range re.APIResources {
+				msg.WriteString("\n   " + r.Kind)
+				kinds = append(kinds, r.Kind)
+			}
+			log.Info(msg.String(), "group_version", re.GroupVersion, "kinds", kinds)
 		}
 	}
 
@@ -87,48 +990,237 @@ func DiscoverObjects(ctx context.Context, conf *rest.Config, cb func(*unstructur
 		}
 		missing := want.Difference(have)
 		if missing.Len() > 0 {
-			return fmt.Errorf("missing resources: %s", sets.List(missing))
+			return discoveredResources{}, fmt.Errorf("missing resources: %s", sets.List(missing))
 		}
 	}
 
-	var errors []error
+	var d discoveredResources
+	// chosenVersions deduplicates resources served in multiple versions. The first discovered version is the preferred one.
+	chosenVersions := map[schema.GroupResource]string{}
 	for _, re := range sprl {
 		for _, r := range re.APIResources {
 			res := groupVersionFromString(re.GroupVersion).WithResource(r.Name)
-			if !slices.Contains(r.Verbs, "list") {
-				fmt.Fprintf(logWriter, "skipping %s: no list verb\n", res)
+			// Checked before deduplicating, so a selected version is dumped even if another version of the resource is preferred.
+			if len(opts.IncludeGroupVersions) > 0 && !slices.Contains(opts.IncludeGroupVersions, res.GroupVersion()) {
+				reason := "excluded by group version filter"
+				log.Info(fmt.Sprintf("skipping %s: %s", res, reason), gvrAttr(res), "skipped_reason", reason)
+				d.skipped = append(d.skipped, ResourceStat{Resource: res, Skipped: true, SkipReason: reason})
 				continue
 			}
-
-			if i := slices.IndexFunc(opts.IgnoreResources, func(re *regexp.Regexp) bool {
-				return re.MatchString(formatGVRForComparison(res))
-			}); i > -1 {
-				fmt.Fprintf(logWriter, "skipping %s: ignored by regex %q\n", res, opts.IgnoreResources[i].String())
+			if v, ok := chosenVersions[res.GroupResource()]; ok && !opts.AllVersions {
+				reason := fmt.Sprintf("duplicate of version %s", v)
+				log.Info(fmt.Sprintf("skipping %s: %s", res, reason), gvrAttr(res), "skipped_reason", reason)
+				d.skipped = append(d.skipped, ResourceStat{Resource: res, Skipped: true, SkipReason: reason})
 				continue
 			}
-
-			continueKey := ""
-			for {
-				l, err := dynClient.Resource(res).List(ctx, metav1.ListOptions{
-					Limit:    batchSize,
-					Continue: continueKey,
-				})
-				if err != nil {
-					errors = append(errors, fmt.Errorf("failed to list %s: %w", res, err))
-					break
-				}
-				if err := cb(l); err != nil {
-					errors = append(errors, fmt.Errorf("failed to dump %s: %w", res, err))
-				}
-				if l.GetContinue() == "" {
-					break
-				}
-				continueKey = l.GetContinue()
+			chosenVersions[res.GroupResource()] = res.Version
+			if reason := opts.skipReason(res, r); reason != "" {
+				log.Info(fmt.Sprintf("skipping %s: %s", res, reason), gvrAttr(res), "skipped_reason", reason)
+				d.skipped = append(d.skipped, ResourceStat{Resource: res, Skipped: true, SkipReason: reason})
+				continue
 			}
+
+			d.jobs = append(d.jobs, listJob{res: res, namespaced: r.Namespaced})
 		}
 	}
+	if len(opts.IncludeGroupVersions) > 0 {
+		logSelectedGroupVersions(sprl, opts.IncludeGroupVersions, log)
+	}
+	return d, nil
+}
+
+// logSelectedGroupVersions logs the selected group versions served by the cluster and warns about the ones not served.
+func logSelectedGroupVersions(sprl []*metav1.APIResourceList, selected []schema.GroupVersion, log *slog.Logger) {
+	served := sets.New[schema.GroupVersion]()
+	for _, re := range sprl {
+		served.Insert(groupVersionFromString(re.GroupVersion))
+	}
+	var found []string
+	for _, gv := range selected {
+		if !served.Has(gv) {
+			log.Warn(fmt.Sprintf("warning: group version %s is not served", gv), "group_version", gv.String())
+			continue
+		}
+		found = append(found, gv.String())
+	}
+	log.Info(fmt.Sprintf("Selected group versions: %s", strings.Join(found, ", ")), "group_versions", found)
+}
+
+// ParseGroupVersion parses a group version in the format group/version, or version for the core group.
+func ParseGroupVersion(s string) (schema.GroupVersion, error) {
+	gv, err := schema.ParseGroupVersion(s)
+	if err != nil || gv.Version == "" || (strings.Contains(s, "/") && gv.Group == "") {
+		return schema.GroupVersion{}, fmt.Errorf("invalid group version %q, must be group/version or version for the core group", s)
+	}
+	return gv, nil
+}
+
+// skipReason returns the reason why the resource is skipped or an empty string if it is listed.
+func (opts DiscoveryOptions) skipReason(res schema.GroupVersionResource, r metav1.APIResource) string {
+	if !passesFilter(opts.IncludeGroups, opts.ExcludeGroups, func(g string) bool { return g == res.Group }) {
+		return "excluded by group filter"
+	}
+	if !slices.Contains(r.Verbs, "list") {
+		return "no list verb"
+	}
+	if opts.Scope == ScopeNamespaced && !r.Namespaced {
+		return "not namespaced"
+	}
+	if opts.Scope == ScopeCluster && r.Namespaced {
+		return "not cluster-scoped"
+	}
+	if !opts.IncludeEvents && slices.Contains(eventsGRs, res.GroupResource()) {
+		return "events are skipped by default"
+	}
+	if i := slices.IndexFunc(opts.IgnoreResources, func(re *regexp.Regexp) bool {
+		return re.MatchString(formatGVRForComparison(res))
+	}); i > -1 {
+		return fmt.Sprintf("ignored by regex %q", opts.IgnoreResources[i].String())
+	}
+	if !passesFilter(opts.IncludeKinds, opts.ExcludeKinds, func(k string) bool { return kindMatches(k, r.Kind) }) {
+		return "excluded by kind filter"
+	}
+	if slices.ContainsFunc(opts.ExcludeGroupKinds, func(gk schema.GroupKind) bool {
+		return gk.Group == res.Group && strings.EqualFold(gk.Kind, r.Kind)
+	}) {
+		return "excluded by group kind filter"
+	}
+	return ""
+}
+
+// groupDiscoveryErrors splits a *discovery.ErrGroupDiscoveryFailed into an error per failed group version, sorted by group version.
+// Other errors are returned as is.
+func groupDiscoveryErrors(err error) ([]error, error) {
+	var gdf *discovery.ErrGroupDiscoveryFailed
+	if !errors.As(err, &gdf) {
+		return nil, err
+	}
+	gvs := make([]schema.GroupVersion, 0, len(gdf.Groups))
+	for gv := range gdf.Groups {
+		gvs = append(gvs, gv)
+	}
+	slices.SortFunc(gvs, func(a, b schema.GroupVersion) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	errs := make([]error, 0, len(gvs))
+	for _, gv := range gvs {
+		errs = append(errs, fmt.Errorf("failed to discover %s: %w", gv, gdf.Groups[gv]))
+	}
+	return errs, nil
+}
+
+// withContextError replaces the errors caused by the cancellation of ctx with a single error describing the cancellation.
+// If the dump was stopped by DiscoveryOptions.MaxObjects, the error contains ErrMaxObjectsReached.
+// Other errors are kept. The errors are returned unchanged if ctx is not done.
+func withContextError(ctx context.Context, elapsed time.Duration, errs []ResourceError) []ResourceError {
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		return errs
+	}
+	errs = slices.DeleteFunc(errs, func(re ResourceError) bool {
+		return errors.Is(re.Err, ctxErr)
+	})
+	if cause := context.Cause(ctx); errors.Is(cause, ErrMaxObjectsReached) {
+		return append(errs, ResourceError{Err: cause})
+	}
+	if errors.Is(ctxErr, context.DeadlineExceeded) {
+		return append(errs, ResourceError{Err: fmt.Errorf("dump timed out after %s: %w", elapsed.Round(time.Millisecond), ctxErr)})
+	}
+	return append(errs, ResourceError{Err: fmt.Errorf("discovery interrupted: %w", ctxErr)})
+}
+
+// parseAnnotationSelector parses a selector of the form key or key=value.
+// The returned function is nil if the selector is empty.
+func parseAnnotationSelector(sel string) (func(unstructured.Unstructured) bool, error) {
+	if sel == "" {
+		return nil, nil
+	}
+	key, value, hasValue := strings.Cut(sel, "=")
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid annotation key %q: %s", key, strings.Join(errs, "; "))
+	}
+	return func(o unstructured.Unstructured) bool {
+		v, ok := o.GetAnnotations()[key]
+		return ok && (!hasValue || v == value)
+	}, nil
+}
+
+// ownedByExcludedKind returns true if the object has an owner reference with one of the given kinds.
+func ownedByExcludedKind(o unstructured.Unstructured, kinds []string) bool {
+	for _, ref := range o.GetOwnerReferences() {
+		if slices.ContainsFunc(kinds, func(k string) bool { return strings.EqualFold(k, ref.Kind) }) {
+			return true
+		}
+	}
+	return false
+}
+
+// createdInWindow returns true if the object was created in the window defined by opts.CreatedAfter and opts.CreatedBefore.
+// known is false if the creation timestamp is missing or unparseable. The object is then always in the window.
+func (opts DiscoveryOptions) createdInWindow(o unstructured.Unstructured) (in, known bool) {
+	raw, _, _ := unstructured.NestedString(o.Object, "metadata", "creationTimestamp")
+	created, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return true, false
+	}
+	if !opts.CreatedAfter.IsZero() && created.Before(opts.CreatedAfter) {
+		return false, true
+	}
+	if !opts.CreatedBefore.IsZero() && !created.Before(opts.CreatedBefore) {
+		return false, true
+	}
+	return true, true
+}
+
+// ValidateKindPattern returns an error if the kind pattern is malformed.
+// Patterns are used by DiscoveryOptions.IncludeKinds and ExcludeKinds.
+func ValidateKindPattern(pattern string) error {
+	if !isKindGlob(pattern) {
+		return nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid kind pattern %q: %w", pattern, err)
+	}
+	return nil
+}
+
+// validateKindPatterns validates the patterns of IncludeKinds and ExcludeKinds.
+func (opts DiscoveryOptions) validateKindPatterns() error {
+	var errs []error
+	for _, p := range slices.Concat(opts.IncludeKinds, opts.ExcludeKinds) {
+		errs = append(errs, ValidateKindPattern(p))
+	}
+	return multierr.Combine(errs...)
+}
+
+// isKindGlob returns true if the kind pattern contains *, ?, or [ and is matched as a glob pattern.
+func isKindGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// kindMatches returns true if the kind matches the pattern case-insensitively.
+// Patterns without glob metacharacters must match exactly.
+func kindMatches(pattern, kind string) bool {
+	if !isKindGlob(pattern) {
+		return strings.EqualFold(pattern, kind)
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(kind))
+	return ok
+}
+
+// passesFilter returns true if match returns true for any entry of include and for no entry of exclude.
+// An empty include list matches everything.
+func passesFilter(include, exclude []string, match func(string) bool) bool {
+	if len(include) > 0 && !slices.ContainsFunc(include, match) {
+		return false
+	}
+	return !slices.ContainsFunc(exclude, match)
+}
 
-	return multierr.Combine(errors...)
+// isFieldSelectorNotSupported returns true if the error is returned by the API server
+// because the resource does not support a field used in the field selector.
+func isFieldSelectorNotSupported(err error) bool {
+	return apierrors.IsBadRequest(err) && strings.Contains(err.Error(), "field label not supported")
 }
 
 func groupVersionFromString(s string) schema.GroupVersion {
@@ -1,17 +1,39 @@
 package discovery_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
@@ -102,15 +124,1409 @@ func Test_DiscoverObjects_MustExistResources_NotSatisfied(t *testing.T) {
 	}), "missing resources: [fluxcapacitors.spaceship.io]")
 }
 
-func setupEnvtestEnv(t *testing.T) (cfg *rest.Config, stop func()) {
+func Test_DiscoverObjects_LabelSelector(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	objs := map[objKey]unstructured.Unstructured{}
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs[objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()}] = o
+		}
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for _, obj := range []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "labeled", Namespace: "default", Labels: map[string]string{"team": "a"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other-team", Namespace: "default", Labels: map[string]string{"team": "b"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled", Namespace: "default"}},
+	} {
+		require.NoError(t, c.Create(context.Background(), obj))
+	}
+
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		LabelSelector: "team=a",
+	}))
+
+	require.Len(t, objs, 1, "only objects matching the label selector are dumped")
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: "labeled", namespace: "default"})
+}
+
+func Test_DiscoverObjects_AnnotationSelector(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for _, obj := range []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "included", Namespace: "default", Annotations: map[string]string{"backup.example.com/include": "true"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "included-false", Namespace: "default", Annotations: map[string]string{"backup.example.com/include": "false"}}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unannotated", Namespace: "default"}},
+	} {
+		require.NoError(t, c.Create(context.Background(), obj))
+	}
+
+	for _, tc := range []struct {
+		selector string
+		expected []string
+	}{
+		{selector: "backup.example.com/include", expected: []string{"included", "included-false"}},
+		{selector: "backup.example.com/include=true", expected: []string{"included"}},
+	} {
+		t.Run(tc.selector, func(t *testing.T) {
+			names := sets.New[string]()
+			var log bytes.Buffer
+			stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					names.Insert(o.GetName())
+				}
+				return nil
+			}, discovery.DiscoveryOptions{
+				LogWriter:          &log,
+				IncludeKinds:       []string{"ConfigMap"},
+				IncludeNamespaces:  []string{"default"},
+				AnnotationSelector: tc.selector,
+			})
+			require.NoError(t, err)
+
+			require.ElementsMatch(t, tc.expected, sets.List(names))
+			i := slices.IndexFunc(stats, func(s discovery.ResourceStat) bool { return s.Resource.Resource == "configmaps" })
+			require.GreaterOrEqual(t, i, 0)
+			require.Equal(t, len(tc.expected), stats[i].Count)
+			require.Positive(t, stats[i].ExcludedByAnnotation)
+			require.Contains(t, log.String(), fmt.Sprintf("/v1, Resource=configmaps: annotation selector %q kept %d objects", tc.selector, len(tc.expected)))
+		})
+	}
+}
+
+func Test_DiscoverObjects_Quiet(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	for _, quiet := range []bool{false, true} {
+		var log bytes.Buffer
+		require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+			return nil
+		}, discovery.DiscoveryOptions{
+			LogWriter:    &log,
+			IncludeKinds: []string{"Namespace"},
+			Quiet:        quiet,
+		}))
+
+		require.Equal(t, !quiet, strings.Contains(log.String(), "Discovered resources:"), "quiet=%t", quiet)
+		require.Contains(t, log.String(), "skipping /v1, Resource=configmaps: excluded by kind filter", "skipped resources should always be logged")
+	}
+}
+
+func Test_DiscoverObjects_Logger(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	var log bytes.Buffer
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}, discovery.DiscoveryOptions{
+		Logger:       slog.New(slog.NewJSONHandler(&log, nil)),
+		IncludeKinds: []string{"Namespace"},
+	}))
+
+	var skipped []map[string]any
+	dec := json.NewDecoder(&log)
+	for dec.More() {
+		var rec map[string]any
+		require.NoError(t, dec.Decode(&rec))
+		delete(rec, "time")
+		if rec["skipped_reason"] != nil {
+			skipped = append(skipped, rec)
+		}
+	}
+	require.Contains(t, skipped, map[string]any{
+		"level":          "INFO",
+		"msg":            "skipping /v1, Resource=configmaps: excluded by kind filter",
+		"gvr":            "/v1, Resource=configmaps",
+		"skipped_reason": "excluded by kind filter",
+	})
+}
+
+func Test_DiscoverObjects_FieldSelector(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	objs := map[objKey]unstructured.Unstructured{}
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs[objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()}] = o
+		}
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for _, nodeName := range []string{"node-1", "node-2"} {
+		require.NoError(t, c.Create(context.Background(), &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-on-" + nodeName, Namespace: "default"},
+			Spec: corev1.PodSpec{
+				NodeName:   nodeName,
+				Containers: []corev1.Container{{Name: "test", Image: "test"}},
+			},
+		}))
+	}
+
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		FieldSelector: "spec.nodeName=node-1",
+	}), "resources not supporting the field selector are skipped")
+
+	require.Len(t, objs, 1)
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "Pod", name: "pod-on-node-1", namespace: "default"})
+}
+
+func Test_DiscoverObjects_Namespaces(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	objs := map[objKey]unstructured.Unstructured{}
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs[objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()}] = o
+		}
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for _, ns := range []string{"ns-a", "ns-b", "ns-c"} {
+		require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}))
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: ns}}))
+	}
+	require.NoError(t, c.Create(context.Background(), &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-role"}}))
+
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		IncludeNamespaces: []string{"ns-a", "ns-b"},
+		ExcludeNamespaces: []string{"ns-b"},
+	}))
+
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: "test-cm", namespace: "ns-a"})
+	require.NotContains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: "test-cm", namespace: "ns-b"}, "exclude is applied on top of include")
+	require.NotContains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: "test-cm", namespace: "ns-c"}, "not included")
+	require.Contains(t, objs, objKey{apiVersion: "rbac.authorization.k8s.io/v1", kind: "ClusterRole", name: "test-cluster-role", namespace: ""}, "cluster-scoped resources are unaffected")
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "Namespace", name: "ns-a", namespace: ""})
+	require.NotContains(t, objs, objKey{apiVersion: "v1", kind: "Namespace", name: "ns-b", namespace: ""}, "excluded namespaces are not dumped")
+	for k := range objs {
+		require.NotContains(t, []string{"ns-b", "ns-c", "default", "kube-system"}, k.namespace)
+	}
+}
+
+func Test_DiscoverObjects_Kinds(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	kinds := sets.New[string]()
+	kindTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			kinds.Insert(o.GetKind())
+		}
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for _, obj := range []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "test-sa", Namespace: "default"}},
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-role"}},
+	} {
+		require.NoError(t, c.Create(context.Background(), obj))
+	}
+
+	var log bytes.Buffer
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, kindTracker, discovery.DiscoveryOptions{
+		LogWriter:    &log,
+		IncludeKinds: []string{"configmap", "ServiceAccount", "CLUSTERROLE"},
+		ExcludeKinds: []string{"serviceaccount"},
+	}))
+
+	require.Equal(t, []string{"ClusterRole", "ConfigMap"}, sets.List(kinds))
+	require.Contains(t, log.String(), "skipping /v1, Resource=serviceaccounts: excluded by kind filter")
+}
+
+func Test_DiscoverObjects_ExcludeOwnedBy(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}}))
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test-cm-owned",
+		Namespace: "default",
+		OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: "apps/v1", Kind: "Deployment", Name: "test-deploy", UID: "f0c2a4a4-6b1b-4c4e-9a3e-0b0e4f0a1d2c"},
+		},
+	}}))
+
+	var names []string
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			names = append(names, o.GetName())
+		}
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds:      []string{"ConfigMap"},
+		IncludeNamespaces: []string{"default"},
+		ExcludeOwnedBy:    []string{"deployment"},
+	})
+	require.NoError(t, err)
+
+	require.Contains(t, names, "test-cm")
+	require.NotContains(t, names, "test-cm-owned")
+	i := slices.IndexFunc(stats, func(s discovery.ResourceStat) bool { return s.Resource.Resource == "configmaps" })
+	require.GreaterOrEqual(t, i, 0)
+	require.Equal(t, 1, stats[i].ExcludedByOwner)
+}
+
+func Test_DiscoverObjects_CreationTimeWindow(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}}))
+
+	now := time.Now()
+	for name, tc := range map[string]struct {
+		after, before time.Time
+		found         bool
+	}{
+		"in window":    {after: now.Add(-time.Hour), before: now.Add(time.Hour), found: true},
+		"before after": {after: now.Add(time.Hour)},
+		"after before": {before: now.Add(-time.Hour)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			found := false
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					found = found || o.GetName() == "test-cm"
+				}
+				return nil
+			}, discovery.DiscoveryOptions{
+				IncludeKinds:  []string{"ConfigMap"},
+				CreatedAfter:  tc.after,
+				CreatedBefore: tc.before,
+			}))
+			require.Equal(t, tc.found, found)
+		})
+	}
+}
+
+func Test_DiscoverObjects_Groups(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	groups := sets.New[string]()
+	groupTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			groups.Insert(o.GroupVersionKind().Group)
+		}
+		return nil
+	}
+
+	var log bytes.Buffer
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, groupTracker, discovery.DiscoveryOptions{
+		LogWriter:     &log,
+		IncludeGroups: []string{"", "rbac.authorization.k8s.io", "apps"},
+		ExcludeGroups: []string{"apps"},
+	}))
+
+	require.Equal(t, []string{"", "rbac.authorization.k8s.io"}, sets.List(groups))
+	require.Contains(t, log.String(), "skipping apps/v1, Resource=deployments: excluded by group filter")
+}
+
+func Test_DiscoverObjects_Concurrency(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	var mu sync.Mutex
+	objs := map[objKey]unstructured.Unstructured{}
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, o := range obj.Items {
+			objs[objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()}] = o
+		}
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	for i := range 10 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "default"}}))
+	}
+
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		BatchSize:   3,
+		Concurrency: 4,
+	}))
+
+	for i := range 10 {
+		require.Contains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: fmt.Sprintf("test-cm-%d", i), namespace: "default"})
+	}
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "Namespace", name: "default", namespace: ""})
+}
+
+func Test_DiscoverObjects_NamespaceConcurrency(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	namespaces := createNamespacesWithConfigMaps(t, cfg, 6, 4)
+
+	var mu sync.Mutex
+	objs := sets.New[objKey]()
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, o := range obj.Items {
+			objs.Insert(objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()})
+		}
+		return nil
+	}
+
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		BatchSize:            3,
+		IncludeKinds:         []string{"ConfigMap"},
+		IncludeNamespaces:    namespaces,
+		NamespaceConcurrency: 4,
+	})
+	require.NoError(t, err)
+
+	for _, ns := range namespaces {
+		for i := range 4 {
+			require.Contains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: fmt.Sprintf("test-cm-%d", i), namespace: ns})
+		}
+	}
+	i := slices.IndexFunc(stats, func(s discovery.ResourceStat) bool { return s.Resource.Resource == "configmaps" })
+	require.GreaterOrEqual(t, i, 0)
+	require.Equal(t, 6*4, stats[i].Count, "the counts of all namespaces should be merged")
+	require.Equal(t, 6*2, stats[i].Batches)
+}
+
+func Test_DiscoverObjects_OverrideBatchSize(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "default"}}))
+		require.NoError(t, c.Create(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-secret-%d", i), Namespace: "default"}}))
+	}
+
+	var logs bytes.Buffer
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(*unstructured.UnstructuredList) error { return nil }, discovery.DiscoveryOptions{
+		BatchSize:         5,
+		OverrideBatchSize: map[schema.GroupResource]int64{{Resource: "configmaps"}: 2},
+		IncludeKinds:      []string{"ConfigMap", "Secret"},
+		Logger:            slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	require.NoError(t, err)
+
+	batches := map[string]int{}
+	for _, s := range stats {
+		batches[s.Resource.Resource] = s.Batches
+	}
+	require.Equal(t, map[string]int{"configmaps": 5, "secrets": 2}, batches)
+	require.Contains(t, logs.String(), `msg="/v1, Resource=configmaps: listing with batch size 2"`)
+	require.Contains(t, logs.String(), `msg="/v1, Resource=secrets: listing with batch size 5"`)
+}
+
+func Benchmark_DiscoverObjects_NamespaceConcurrency(b *testing.B) {
+	cfg, stop := setupEnvtestEnv(b)
+	defer stop()
+
+	namespaces := createNamespacesWithConfigMaps(b, cfg, 50, 20)
+
+	for _, nc := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("NamespaceConcurrency=%d", nc), func(b *testing.B) {
+			for range b.N {
+				require.NoError(b, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+					return nil
+				}, discovery.DiscoveryOptions{
+					BatchSize:            5,
+					IncludeKinds:         []string{"ConfigMap"},
+					IncludeNamespaces:    namespaces,
+					NamespaceConcurrency: nc,
+					// Disable client-side rate limiting, it would dominate the measurement.
+					QPS: -1,
+				}))
+			}
+		})
+	}
+}
+
+// createNamespacesWithConfigMaps creates n namespaces with perNamespace ConfigMaps each and returns the names of the namespaces.
+func createNamespacesWithConfigMaps(tb testing.TB, cfg *rest.Config, n, perNamespace int) []string {
+	tb.Helper()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(tb, err)
+
+	namespaces := make([]string, 0, n)
+	for i := range n {
+		ns := fmt.Sprintf("test-ns-%d", i)
+		require.NoError(tb, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns}}))
+		for j := range perNamespace {
+			require.NoError(tb, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", j), Namespace: ns}}))
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+func Test_DiscoverObjects_QPSAndBurst(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	origQPS, origBurst := cfg.QPS, cfg.Burst
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, discard, discovery.DiscoveryOptions{
+		QPS:   100,
+		Burst: 200,
+	}))
+	require.Equal(t, origQPS, cfg.QPS, "passed config must not be mutated")
+	require.Equal(t, origBurst, cfg.Burst, "passed config must not be mutated")
+}
+
+func Test_DiscoverObjects_Events(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+
+	require.NoError(t, c.Create(context.Background(), &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "test-event", Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Namespace", Name: "default"},
+	}))
+
+	for _, includeEvents := range []bool{false, true} {
+		t.Run(fmt.Sprintf("IncludeEvents=%t", includeEvents), func(t *testing.T) {
+			kinds := sets.New[string]()
+			kindTracker := func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					kinds.Insert(o.GetKind())
+				}
+				return nil
+			}
+
+			var log bytes.Buffer
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, kindTracker, discovery.DiscoveryOptions{
+				LogWriter:     &log,
+				IncludeEvents: includeEvents,
+			}))
+
+			if includeEvents {
+				require.True(t, kinds.Has("Event"))
+			} else {
+				require.False(t, kinds.Has("Event"))
+				require.Contains(t, log.String(), "skipping /v1, Resource=events: events are skipped by default")
+			}
+		})
+	}
+}
+
+func Test_DiscoverObjects_StripManagedFields(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}}))
+
+	for _, strip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("StripManagedFields=%t", strip), func(t *testing.T) {
+			var cm *unstructured.Unstructured
+			cmTracker := func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					if o.GetKind() == "ConfigMap" && o.GetName() == "test-cm" {
+						cm = o.DeepCopy()
+					}
+				}
+				return nil
+			}
+
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, cmTracker, discovery.DiscoveryOptions{
+				IncludeKinds:       []string{"ConfigMap"},
+				StripManagedFields: strip,
+			}))
+
+			require.NotNil(t, cm)
+			_, found, err := unstructured.NestedFieldNoCopy(cm.Object, "metadata", "managedFields")
+			require.NoError(t, err)
+			require.Equal(t, !strip, found)
+		})
+	}
+}
+
+func Test_DiscoverObjects_StripStatus(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}}))
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "test-ns"}}))
+
+	for _, strip := range []bool{false, true} {
+		t.Run(fmt.Sprintf("StripStatus=%t", strip), func(t *testing.T) {
+			objs := map[string]*unstructured.Unstructured{}
+			tracker := func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					if o.GetName() == "test-ns" || o.GetName() == "test-cm" {
+						objs[o.GetKind()] = o.DeepCopy()
+					}
+				}
+				return nil
+			}
+
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, tracker, discovery.DiscoveryOptions{
+				IncludeKinds: []string{"Namespace", "ConfigMap"},
+				StripStatus:  strip,
+			}))
+
+			require.Contains(t, objs, "Namespace")
+			require.Contains(t, objs, "ConfigMap", "objects without a status should be dumped")
+			_, found := objs["Namespace"].Object["status"]
+			require.Equal(t, !strip, found)
+		})
+	}
+}
+
+func Test_DiscoverObjects_Transform(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	for _, name := range []string{"test-cm", "broken-cm"} {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Data:       map[string]string{"password": "hunter2", "user": "admin"},
+		}))
+	}
+
+	objs := map[string]*unstructured.Unstructured{}
+	err = discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs[o.GetName()] = o.DeepCopy()
+		}
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds:      []string{"ConfigMap"},
+		IncludeNamespaces: []string{"default"},
+		StripStatus:       true,
+		Transform: func(o *unstructured.Unstructured) error {
+			if o.GetName() == "broken-cm" {
+				return errors.New("cannot scrub")
+			}
+			_, hasStatus := o.Object["status"]
+			require.False(t, hasStatus, "the transform should run after the built-in transformations")
+			return unstructured.SetNestedField(o.Object, discovery.RedactedPlaceholder, "data", "password")
+		},
+	})
+
+	require.ErrorContains(t, err, "failed to transform /v1, Resource=configmaps default/broken-cm: cannot scrub")
+	require.NotContains(t, objs, "broken-cm", "objects failing the transform should be skipped")
+	require.Contains(t, objs, "test-cm")
+	data, _, _ := unstructured.NestedStringMap(objs["test-cm"].Object, "data")
+	require.Equal(t, map[string]string{"password": discovery.RedactedPlaceholder, "user": "admin"}, data)
+}
+
+func Test_DiscoverObjects_StableOutput(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default", Labels: map[string]string{"app": "test"}}}))
+
+	var cm *unstructured.Unstructured
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			if o.GetName() == "test-cm" {
+				cm = o.DeepCopy()
+			}
+		}
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds: []string{"ConfigMap"},
+		StableOutput: true,
+	}))
+
+	require.NotNil(t, cm)
+	md, _, err := unstructured.NestedMap(cm.Object, "metadata")
+	require.NoError(t, err)
+	for _, f := range []string{"resourceVersion", "uid", "generation", "creationTimestamp", "managedFields"} {
+		require.NotContains(t, md, f)
+	}
+	require.Equal(t, map[string]string{"app": "test"}, cm.GetLabels(), "other metadata should be kept")
+}
+
+func Test_DiscoverObjects_SecretData(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "default"},
+		Data:       map[string][]byte{"password": []byte("hunter2")},
+	}))
+
+	for _, includeSecretData := range []bool{false, true} {
+		t.Run(fmt.Sprintf("IncludeSecretData=%t", includeSecretData), func(t *testing.T) {
+			var secret *unstructured.Unstructured
+			secretTracker := func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					if o.GetKind() == "Secret" && o.GetName() == "test-secret" {
+						secret = o.DeepCopy()
+					}
+				}
+				return nil
+			}
+
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, secretTracker, discovery.DiscoveryOptions{
+				IncludeKinds:      []string{"Secret"},
+				IncludeSecretData: includeSecretData,
+			}))
+
+			require.NotNil(t, secret)
+			data, _, err := unstructured.NestedStringMap(secret.Object, "data")
+			require.NoError(t, err)
+			if includeSecretData {
+				require.Equal(t, map[string]string{"password": base64.StdEncoding.EncodeToString([]byte("hunter2"))}, data)
+			} else {
+				require.Equal(t, map[string]string{"password": discovery.RedactedPlaceholder}, data)
+			}
+		})
+	}
+}
+
+func Test_DiscoverObjects_Checkpoint(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	kinds := sets.New[string]()
+	kindTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			kinds.Insert(o.GetKind())
+		}
+		return nil
+	}
+
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoint.json")
+	require.NoError(t, os.WriteFile(checkpointFile, []byte(`{"completed":[{"version":"v1","resource":"namespaces"}]}`), 0644))
+
+	var log bytes.Buffer
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, kindTracker, discovery.DiscoveryOptions{
+		LogWriter:      &log,
+		CheckpointFile: checkpointFile,
+	}))
+
+	require.False(t, kinds.Has("Namespace"), "completed resources are skipped")
+	require.True(t, kinds.Has("ClusterRole"))
+	require.Contains(t, log.String(), "skipping /v1, Resource=namespaces: completed according to checkpoint")
+	require.NoFileExists(t, checkpointFile, "checkpoint is removed after a successful dump")
+}
+
+func Test_DiscoverObjects_Progress(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}}))
+	for i := range 5 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "test-ns"}}))
+	}
+
+	var events []discovery.ProgressEvent
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, discard, discovery.DiscoveryOptions{
+		BatchSize:         2,
+		IncludeKinds:      []string{"ConfigMap"},
+		IncludeNamespaces: []string{"test-ns"},
+		Progress: func(e discovery.ProgressEvent) {
+			events = append(events, e)
+		},
+	}))
+
+	cms := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	require.Equal(t, []discovery.ProgressEvent{
+		{Resource: cms, Namespace: "test-ns", BatchCount: 2, Count: 2},
+		{Resource: cms, Namespace: "test-ns", BatchCount: 2, Count: 4},
+		{Resource: cms, Namespace: "test-ns", BatchCount: 1, Count: 5},
+		{Resource: cms, Count: 5, Complete: true},
+	}, events)
+}
+
+func Test_DiscoverObjects_DryRun(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}}))
+	for i := range 5 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "test-ns"}}))
+	}
+
+	var log strings.Builder
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		t.Fatal("callback must not be called in dry run mode")
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds:      []string{"ConfigMap"},
+		IncludeNamespaces: []string{"test-ns"},
+		DryRun:            true,
+		LogWriter:         &log,
+	})
+	require.NoError(t, err)
+
+	i := slices.IndexFunc(stats, func(s discovery.ResourceStat) bool { return s.Resource.Resource == "configmaps" })
+	require.GreaterOrEqual(t, i, 0)
+	require.Equal(t, 5, stats[i].Count)
+	require.Contains(t, log.String(), "configmaps in namespace test-ns: ~5 objects")
+}
+
+func Test_DiscoverObjects_Cancelled(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}}))
+	for i := range 5 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "test-ns"}}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := 0
+	err = discovery.DiscoverObjects(ctx, cfg, func(obj *unstructured.UnstructuredList) error {
+		batches++
+		cancel()
+		return nil
+	}, discovery.DiscoveryOptions{
+		BatchSize:         2,
+		IncludeNamespaces: []string{"test-ns"},
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, batches, "no batches should be listed after the context is cancelled")
+}
+
+func Test_DiscoverObjects_Timeout(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	batches := 0
+	err := discovery.DiscoverObjects(ctx, cfg, func(obj *unstructured.UnstructuredList) error {
+		batches++
+		<-ctx.Done()
+		return nil
+	}, discovery.DiscoveryOptions{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "dump timed out after")
+	var dumpErr *discovery.DumpError
+	require.ErrorAs(t, err, &dumpErr)
+	require.Len(t, dumpErr.Errors, 1, "errors caused by the timeout should be replaced with a single error")
+	require.Equal(t, 1, batches)
+}
+
+func Test_DiscoverObjectsWithStats(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}}))
+	for i := range 5 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "test-ns"}}))
+	}
+
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, discard, discovery.DiscoveryOptions{
+		BatchSize:         2,
+		IncludeNamespaces: []string{"test-ns"},
+	})
+	require.NoError(t, err)
+
+	statsByResource := map[schema.GroupVersionResource]discovery.ResourceStat{}
+	for _, s := range stats {
+		statsByResource[s.Resource] = s
+	}
+
+	cms := statsByResource[schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}]
+	require.Equal(t, 5, cms.Count)
+	require.Equal(t, 3, cms.Batches)
+	require.False(t, cms.Skipped)
+	require.False(t, cms.Failed)
+
+	events := statsByResource[schema.GroupVersionResource{Version: "v1", Resource: "events"}]
+	require.True(t, events.Skipped)
+	require.Equal(t, "events are skipped by default", events.SkipReason)
+}
+
+func Test_DiscoverObjects_SkipForbidden(t *testing.T) {
+	testEnv := &envtest.Environment{}
+	cfg, err := testEnv.Start()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, testEnv.Stop())
+	}()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	for _, obj := range []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "list-configmaps"},
+			Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list"}},
+			},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "list-configmaps"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "list-configmaps"},
+			Subjects:   []rbacv1.Subject{{APIGroup: "rbac.authorization.k8s.io", Kind: "User", Name: "restricted"}},
+		},
+	} {
+		require.NoError(t, c.Create(context.Background(), obj))
+	}
+
+	restricted, err := testEnv.AddUser(envtest.User{Name: "restricted"}, cfg)
+	require.NoError(t, err)
+
+	objs := map[objKey]unstructured.Unstructured{}
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs[objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()}] = o
+		}
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), restricted.Config(), objTracker, discovery.DiscoveryOptions{}), "forbidden")
+
+	var log bytes.Buffer
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), restricted.Config(), objTracker, discovery.DiscoveryOptions{
+		LogWriter:     &log,
+		SkipForbidden: true,
+	}))
+	require.Contains(t, objs, objKey{apiVersion: "v1", kind: "ConfigMap", name: "test-cm", namespace: "default"})
+	require.Contains(t, log.String(), "skipping /v1, Resource=namespaces:")
+}
+
+func Test_DiscoverObjects_Impersonate(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	for _, obj := range []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "list-configmaps"},
+			Rules: []rbacv1.PolicyRule{
+				{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"list"}},
+			},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "list-configmaps"},
+			RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: "list-configmaps"},
+			Subjects:   []rbacv1.Subject{{APIGroup: "rbac.authorization.k8s.io", Kind: "Group", Name: "dumpers"}},
+		},
+	} {
+		require.NoError(t, c.Create(context.Background(), obj))
+	}
+
+	objs := sets.New[objKey]()
+	objTracker := func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			objs.Insert(objKey{apiVersion: o.GetAPIVersion(), kind: o.GetKind(), name: o.GetName(), namespace: o.GetNamespace()})
+		}
+		return nil
+	}
+
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, objTracker, discovery.DiscoveryOptions{
+		Impersonate:   rest.ImpersonationConfig{UserName: "auditor", Groups: []string{"dumpers"}},
+		SkipForbidden: true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, sets.New(objKey{apiVersion: "v1", kind: "ConfigMap", name: "test-cm", namespace: "default"}), objs)
+	for _, s := range stats {
+		if s.Resource.Resource == "namespaces" {
+			require.Equal(t, discovery.SkipReasonForbidden, s.SkipReason, "the impersonated user should not be allowed to list namespaces")
+		}
+	}
+}
+
+func Test_DiscoverObjects_Scope(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}}))
+
+	for _, scope := range []discovery.Scope{discovery.ScopeNamespaced, discovery.ScopeCluster} {
+		t.Run(string(scope), func(t *testing.T) {
+			var namespaced, clusterScoped int
+			scopeTracker := func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					if o.GetNamespace() == "" {
+						clusterScoped++
+					} else {
+						namespaced++
+					}
+				}
+				return nil
+			}
+
+			require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, scopeTracker, discovery.DiscoveryOptions{
+				Scope: scope,
+			}))
+
+			if scope == discovery.ScopeNamespaced {
+				require.Positive(t, namespaced)
+				require.Zero(t, clusterScoped)
+			} else {
+				require.Zero(t, namespaced)
+				require.Positive(t, clusterScoped)
+			}
+		})
+	}
+}
+
+func Test_DiscoverObjects_AllVersions(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	for _, allVersions := range []bool{false, true} {
+		t.Run(fmt.Sprintf("AllVersions=%t", allVersions), func(t *testing.T) {
+			stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+				return nil
+			}, discovery.DiscoveryOptions{
+				IncludeKinds: []string{"HorizontalPodAutoscaler"},
+				AllVersions:  allVersions,
+			})
+			require.NoError(t, err)
+
+			listed := sets.New[string]()
+			for _, s := range stats {
+				if !s.Skipped {
+					listed.Insert(s.Resource.Version)
+				}
+			}
+			if allVersions {
+				require.True(t, listed.HasAll("v1", "v2"), "all served versions should be listed, got %v", sets.List(listed))
+			} else {
+				require.Equal(t, 1, listed.Len(), "only the preferred version should be listed, got %v", sets.List(listed))
+			}
+		})
+	}
+}
+
+func Test_DiscoverObjects_PartialDiscoveryFailure(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	// An aggregated API pointing to a missing service fails discovery.
+	apiService := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]any{"name": "v1beta1.broken.example.com"},
+		"spec": map[string]any{
+			"group":                 "broken.example.com",
+			"version":               "v1beta1",
+			"groupPriorityMinimum":  int64(1000),
+			"versionPriority":       int64(15),
+			"insecureSkipTLSVerify": true,
+			"service":               map[string]any{"name": "missing", "namespace": "default"},
+		},
+	}}
+	require.NoError(t, c.Create(context.Background(), apiService))
+	require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"}}))
+
+	found := false
+	err = discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		for _, o := range obj.Items {
+			found = found || o.GetName() == "test-cm"
+		}
+		return nil
+	}, discovery.DiscoveryOptions{})
+	require.ErrorContains(t, err, "failed to discover broken.example.com/v1beta1")
+	require.True(t, found, "resources of working groups should be dumped")
+}
+
+func Test_DiscoverObjects_ExpiredContinueToken(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	c, err := client.New(cfg, client.Options{})
+	require.NoError(t, err)
+	for i := range 3 {
+		require.NoError(t, c.Create(context.Background(), &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("test-cm-%d", i), Namespace: "default"}}))
+	}
+
+	for _, tc := range []struct {
+		name string
+		// expirations is the number of list calls with a continue token failing with an expired error.
+		expirations int
+		expectErr   bool
+	}{
+		{name: "Restarted", expirations: 1},
+		{name: "RestartsExhausted", expirations: 100, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			expirations := tc.expirations
+			conf := rest.CopyConfig(cfg)
+			conf.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+				return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					mu.Lock()
+					defer mu.Unlock()
+					if !strings.HasSuffix(r.URL.Path, "/configmaps") || r.URL.Query().Get("continue") == "" || expirations == 0 {
+						return rt.RoundTrip(r)
+					}
+					expirations--
+					return &http.Response{
+						StatusCode: http.StatusGone,
+						Header:     http.Header{"Content-Type": []string{"application/json"}},
+						Body:       io.NopCloser(strings.NewReader(`{"kind":"Status","apiVersion":"v1","status":"Failure","message":"The provided continue parameter is too old","reason":"Expired","code":410}`)),
+						Request:    r,
+					}, nil
+				})
+			}
+
+			names := sets.New[string]()
+			var log bytes.Buffer
+			err := discovery.DiscoverObjects(context.Background(), conf, func(obj *unstructured.UnstructuredList) error {
+				for _, o := range obj.Items {
+					names.Insert(o.GetName())
+				}
+				return nil
+			}, discovery.DiscoveryOptions{
+				BatchSize:         1,
+				LogWriter:         &log,
+				IncludeKinds:      []string{"ConfigMap"},
+				IncludeNamespaces: []string{"default"},
+			})
+
+			require.Contains(t, log.String(), "continue token of /v1, Resource=configmaps in namespace default expired, restarting the listing")
+			if tc.expectErr {
+				require.Error(t, err)
+				require.True(t, apierrors.IsResourceExpired(err), "expected expired error, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			require.True(t, names.HasAll("test-cm-0", "test-cm-1", "test-cm-2"), "all objects should be dumped, got %v", sets.List(names))
+		})
+	}
+}
+
+// roundTripperFunc implements http.RoundTripper with a function.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func Test_DiscoverObjects_Metrics(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	reg := prometheus.NewRegistry()
+	m, err := discovery.NewMetrics(reg)
+	require.NoError(t, err)
+
+	var count int
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		count += len(obj.Items)
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds: []string{"Namespace", "ConfigMap"},
+		Metrics:      m,
+	}))
+
+	require.Equal(t, float64(count), counterSum(t, reg, "k8s_object_dumper_objects_dumped_total"))
+	require.Positive(t, counterSum(t, reg, "k8s_object_dumper_resources_skipped_total"))
+	require.Zero(t, counterSum(t, reg, "k8s_object_dumper_errors_total"))
+	n, err := testutil.GatherAndCount(reg, "k8s_object_dumper_listing_resource")
+	require.NoError(t, err)
+	require.Zero(t, n, "no resource should be listed after the dump")
+}
+
+func Test_DiscoverObjects_Tracing(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	rec := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	defer otel.SetTracerProvider(prev)
+
+	require.NoError(t, discovery.DiscoverObjects(context.Background(), cfg, func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}, discovery.DiscoveryOptions{
+		IncludeKinds: []string{"Namespace"},
+		BatchSize:    1,
+	}))
+
+	spans := map[string][]sdktrace.ReadOnlySpan{}
+	for _, s := range rec.Ended() {
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	require.Len(t, spans["DiscoverObjects"], 1)
+	require.Len(t, spans["ListResource"], 1)
+	root := spans["DiscoverObjects"][0]
+	res := spans["ListResource"][0]
+	require.Equal(t, root.SpanContext().SpanID(), res.Parent().SpanID())
+	require.Contains(t, res.Attributes(), attribute.String("k8s.resource", "namespaces"))
+
+	lists := spans["List"]
+	require.Greater(t, len(lists), 1, "every batch should have a span")
+	var items int64
+	for i, l := range lists {
+		require.Equal(t, res.SpanContext().SpanID(), l.Parent().SpanID())
+		require.Contains(t, l.Attributes(), attribute.Bool("k8s.continue", i > 0))
+		for _, a := range l.Attributes() {
+			if a.Key == "k8s.items" {
+				items += a.Value.AsInt64()
+			}
+		}
+	}
+	require.Contains(t, res.Attributes(), attribute.Int64("k8s.items", items))
+}
+
+// counterSum returns the sum of the counter with the given name over all labels.
+func counterSum(t *testing.T, reg *prometheus.Registry, name string) float64 {
 	t.Helper()
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	var sum float64
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			sum += m.GetCounter().GetValue()
+		}
+	}
+	return sum
+}
+
+func Test_ListDumpableResources(t *testing.T) {
+	cfg, stop := setupEnvtestEnv(t)
+	defer stop()
+
+	opts := discovery.DiscoveryOptions{
+		IncludeGroups: []string{"", "apps"},
+		ExcludeKinds:  []string{"Secret"},
+		Scope:         discovery.ScopeNamespaced,
+	}
+	resources, err := discovery.ListDumpableResources(context.Background(), cfg, opts)
+	require.NoError(t, err)
+	require.Contains(t, resources, schema.GroupVersionResource{Version: "v1", Resource: "configmaps"})
+	require.Contains(t, resources, schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"})
+	require.NotContains(t, resources, schema.GroupVersionResource{Version: "v1", Resource: "secrets"})
+	require.NotContains(t, resources, schema.GroupVersionResource{Version: "v1", Resource: "namespaces"})
+	require.NotContains(t, resources, schema.GroupVersionResource{Version: "v1", Resource: "events"})
+
+	stats, err := discovery.DiscoverObjectsWithStats(context.Background(), cfg, func(*unstructured.UnstructuredList) error { return nil }, opts)
+	require.NoError(t, err)
+	var listed []schema.GroupVersionResource
+	for _, s := range stats {
+		if !s.Skipped {
+			listed = append(listed, s.Resource)
+		}
+	}
+	require.ElementsMatch(t, resources, listed)
+}
+
+func Test_FilterDumpableResources(t *testing.T) {
+	list := []string{"list", "get"}
+	lists := []*metav1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: list},
+				{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: list},
+				{Name: "events", Kind: "Event", Namespaced: true, Verbs: list},
+				{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: []string{"create"}},
+				{Name: "namespaces", Kind: "Namespace", Verbs: list},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: list},
+			},
+		},
+		{
+			GroupVersion: "autoscaling/v2",
+			APIResources: []metav1.APIResource{
+				{Name: "horizontalpodautoscalers", Kind: "HorizontalPodAutoscaler", Namespaced: true, Verbs: list},
+			},
+		},
+		{
+			GroupVersion: "autoscaling/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "horizontalpodautoscalers", Kind: "HorizontalPodAutoscaler", Namespaced: true, Verbs: list},
+			},
+		},
+	}
+
+	resources, err := discovery.FilterDumpableResources(lists, discovery.DiscoveryOptions{
+		ExcludeKinds: []string{"Secret"},
+		Scope:        discovery.ScopeNamespaced,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []schema.GroupVersionResource{
+		{Version: "v1", Resource: "configmaps"},
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+		{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"},
+	}, resources)
+
+	resources, err = discovery.FilterDumpableResources(lists, discovery.DiscoveryOptions{
+		IncludeKinds: []string{"*map", "horizontal?odautoscaler", "Deploy"},
+		ExcludeKinds: []string{"config[a-z]ap"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []schema.GroupVersionResource{
+		{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"},
+	}, resources, "patterns should match case-insensitively, exact kinds should not match prefixes")
+
+	_, err = discovery.FilterDumpableResources(lists, discovery.DiscoveryOptions{
+		ExcludeKinds: []string{"Config[Map"},
+	})
+	require.ErrorContains(t, err, `invalid kind pattern "Config[Map"`)
+
+	var log bytes.Buffer
+	resources, err = discovery.FilterDumpableResources(lists, discovery.DiscoveryOptions{
+		IncludeGroupVersions: []schema.GroupVersion{{Group: "autoscaling", Version: "v1"}, {Version: "v1"}, {Group: "batch", Version: "v1"}},
+		Quiet:                true,
+		LogWriter:            &log,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []schema.GroupVersionResource{
+		{Version: "v1", Resource: "configmaps"},
+		{Version: "v1", Resource: "secrets"},
+		{Version: "v1", Resource: "namespaces"},
+		{Group: "autoscaling", Version: "v1", Resource: "horizontalpodautoscalers"},
+	}, resources, "the selected version should be dumped even if another version is preferred")
+	require.Contains(t, log.String(), "Selected group versions: autoscaling/v1, v1")
+	require.Contains(t, log.String(), "warning: group version batch/v1 is not served")
+
+	_, err = discovery.FilterDumpableResources(lists, discovery.DiscoveryOptions{
+		MustExistResources: []string{"apps/v1/statefulsets"},
+	})
+	require.ErrorContains(t, err, "missing resources")
+}
+
+func Test_ParseGroupVersion(t *testing.T) {
+	for in, expected := range map[string]schema.GroupVersion{
+		"apps/v1":                 {Group: "apps", Version: "v1"},
+		"v1":                      {Version: "v1"},
+		"cert-manager.io/v1beta1": {Group: "cert-manager.io", Version: "v1beta1"},
+	} {
+		gv, err := discovery.ParseGroupVersion(in)
+		require.NoError(t, err, in)
+		require.Equal(t, expected, gv, in)
+	}
+	for _, in := range []string{"", "apps/", "/v1", "apps/v1/deployments"} {
+		_, err := discovery.ParseGroupVersion(in)
+		require.ErrorContains(t, err, "invalid group version", in)
+	}
+}
+
+func Test_DiscoverObjects_InvalidScope(t *testing.T) {
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), &rest.Config{}, discard, discovery.DiscoveryOptions{
+		Scope: "galaxy",
+	}), `unknown scope "galaxy"`)
+}
+
+func Test_DiscoverObjects_InvalidLabelSelector(t *testing.T) {
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), &rest.Config{}, discard, discovery.DiscoveryOptions{
+		LabelSelector: "team in (a",
+	}), `invalid label selector "team in (a"`)
+}
+
+func Test_DiscoverObjects_InvalidAnnotationSelector(t *testing.T) {
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), &rest.Config{}, discard, discovery.DiscoveryOptions{
+		AnnotationSelector: "not a key",
+	}), `invalid annotation selector "not a key"`)
+}
+
+func Test_DiscoverObjects_InvalidRedactPath(t *testing.T) {
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), &rest.Config{}, discard, discovery.DiscoveryOptions{
+		RedactPaths: []string{"{.spec.password}", "{.spec.password"},
+	}), `invalid redact path "{.spec.password"`)
+}
+
+func Test_DiscoverObjects_InvalidExcludeNameRegex(t *testing.T) {
+	discard := func(obj *unstructured.UnstructuredList) error {
+		return nil
+	}
+
+	require.ErrorContains(t, discovery.DiscoverObjects(context.Background(), &rest.Config{}, discard, discovery.DiscoveryOptions{
+		ExcludeNameRegex: "tmp-(",
+	}), `invalid exclude name regex "tmp-("`)
+}
+
+func setupEnvtestEnv(tb testing.TB) (cfg *rest.Config, stop func()) {
+	tb.Helper()
 
 	testEnv := &envtest.Environment{}
 
 	cfg, err := testEnv.Start()
-	require.NoError(t, err)
+	require.NoError(tb, err)
 
 	return cfg, func() {
-		require.NoError(t, testEnv.Stop())
+		require.NoError(tb, testEnv.Stop())
 	}
 }
@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/utils/clock"
+)
+
+// DefaultDiscoveryCacheTTL is the time the cached discovery is used for if DiscoveryOptions.DiscoveryCacheTTL is not set.
+const DefaultDiscoveryCacheTTL = 10 * time.Minute
+
+// illegalCacheDirChars matches the characters of the API server host replaced in the name of its cache directory.
+var illegalCacheDirChars = regexp.MustCompile(`[^\w.-]`)
+
+// discoveryCacheEntry is the content of a discovery cache file.
+type discoveryCacheEntry struct {
+	Time time.Time `json:"time"`
+	// Groups are only set for the result of ServerGroupsAndResources.
+	Groups    []*metav1.APIGroup        `json:"groups,omitempty"`
+	Resources []*metav1.APIResourceList `json:"resources"`
+}
+
+// cachedDiscovery caches the results of ServerPreferredResources and ServerGroupsAndResources in files.
+// Results with errors, for example of groups failing discovery, are not cached.
+// All other methods are passed to the wrapped client.
+type cachedDiscovery struct {
+	discovery.DiscoveryInterface
+	dir     string
+	ttl     time.Duration
+	refresh bool
+	log     *slog.Logger
+	clock   clock.PassiveClock
+}
+
+// cachedDiscovery wraps dc with a cache in the directory of the API server host if DiscoveryCacheDir is set.
+// dc is returned unchanged otherwise.
+func (opts DiscoveryOptions) cachedDiscovery(dc discovery.DiscoveryInterface, host string) discovery.DiscoveryInterface {
+	if opts.DiscoveryCacheDir == "" {
+		return dc
+	}
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	return &cachedDiscovery{
+		DiscoveryInterface: dc,
+		dir:                filepath.Join(opts.DiscoveryCacheDir, illegalCacheDirChars.ReplaceAllString(host, "_")),
+		ttl:                opts.GetDiscoveryCacheTTL(),
+		refresh:            opts.RefreshDiscovery,
+		log:                opts.GetLogger(),
+		clock:              opts.GetClock(),
+	}
+}
+
+// ServerPreferredResources implements discovery.DiscoveryInterface.
+func (c *cachedDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	path := filepath.Join(c.dir, "preferred.json")
+	if e, ok := c.read(path); ok {
+		return e.Resources, nil
+	}
+	rls, err := c.DiscoveryInterface.ServerPreferredResources()
+	if err == nil {
+		c.write(path, discoveryCacheEntry{Time: c.clock.Now(), Resources: rls})
+	}
+	return rls, err
+}
+
+// ServerGroupsAndResources implements discovery.DiscoveryInterface.
+func (c *cachedDiscovery) ServerGroupsAndResources() ([]*metav1.APIGroup, []*metav1.APIResourceList, error) {
+	path := filepath.Join(c.dir, "all.json")
+	if e, ok := c.read(path); ok {
+		return e.Groups, e.Resources, nil
+	}
+	groups, rls, err := c.DiscoveryInterface.ServerGroupsAndResources()
+	if err == nil {
+		c.write(path, discoveryCacheEntry{Time: c.clock.Now(), Groups: groups, Resources: rls})
+	}
+	return groups, rls, err
+}
+
+// read returns the cache entry at path if it exists, can be decoded, and is not expired.
+// Unreadable entries are logged and treated as missing, the discovery is then run again.
+func (c *cachedDiscovery) read(path string) (discoveryCacheEntry, bool) {
+	if c.refresh {
+		return discoveryCacheEntry{}, false
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return discoveryCacheEntry{}, false
+	}
+	var e discoveryCacheEntry
+	if err == nil {
+		err = json.Unmarshal(raw, &e)
+	}
+	if err != nil {
+		c.log.Warn(fmt.Sprintf("warning: ignoring discovery cache %s: %v", path, err), "path", path, "error", err)
+		return discoveryCacheEntry{}, false
+	}
+	age := c.clock.Since(e.Time)
+	if age > c.ttl {
+		return discoveryCacheEntry{}, false
+	}
+	c.log.Info(fmt.Sprintf("using the discovery cached %s ago in %s", age.Round(time.Second), path), "path", path, "age", age)
+	return e, true
+}
+
+// write writes the cache entry to path.
+// Failures are logged, the dump does not depend on the cache.
+func (c *cachedDiscovery) write(path string, e discoveryCacheEntry) {
+	raw, err := json.Marshal(e)
+	if err == nil {
+		err = os.MkdirAll(c.dir, 0o750)
+	}
+	if err == nil {
+		err = writeFileAtomic(path, raw)
+	}
+	if err != nil {
+		c.log.Warn(fmt.Sprintf("warning: failed to write discovery cache %s: %v", path, err), "path", path, "error", err)
+	}
+}
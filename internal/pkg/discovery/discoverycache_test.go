@@ -0,0 +1,47 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func Test_cachedDiscovery(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+	clk := testingclock.NewFakePassiveClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := DiscoveryOptions{DiscoveryCacheDir: t.TempDir(), Quiet: true, Clock: clk}
+	discover := func(opts DiscoveryOptions) int {
+		t.Helper()
+		d, err := opts.discoverResources(opts.cachedDiscovery(c.discovery, "https://api.example.com:6443"), opts.GetLogger())
+		require.NoError(t, err)
+		return len(d.resources())
+	}
+
+	require.Equal(t, 3, discover(opts))
+	require.FileExists(t, filepath.Join(opts.DiscoveryCacheDir, "api.example.com_6443", "preferred.json"))
+
+	removed := c.discovery.Resources[0].APIResources[0]
+	c.discovery.Resources[0].APIResources = c.discovery.Resources[0].APIResources[1:]
+	clk.SetTime(clk.Now().Add(DefaultDiscoveryCacheTTL))
+	require.Equal(t, 3, discover(opts), "the cached discovery should be used until the TTL elapsed")
+
+	clk.SetTime(clk.Now().Add(time.Second))
+	require.Equal(t, 2, discover(opts), "an expired cache should be replaced")
+	c.discovery.Resources[0].APIResources = append(c.discovery.Resources[0].APIResources, removed)
+	require.Equal(t, 2, discover(opts), "the replaced cache should be used")
+
+	refresh := opts
+	refresh.RefreshDiscovery = true
+	require.Equal(t, 3, discover(refresh), "the cache should be ignored with RefreshDiscovery")
+
+	require.NoError(t, os.WriteFile(filepath.Join(opts.DiscoveryCacheDir, "api.example.com_6443", "preferred.json"), []byte("{"), 0o644))
+	require.Equal(t, 3, discover(opts), "a corrupt cache should be ignored")
+
+	opts.AllVersions = true
+	require.Equal(t, 3, discover(opts))
+	require.FileExists(t, filepath.Join(opts.DiscoveryCacheDir, "api.example.com_6443", "all.json"))
+}
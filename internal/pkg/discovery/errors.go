@@ -0,0 +1,75 @@
+package discovery
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ErrMaxObjectsReached is contained in the error returned by DiscoverObjects if the dump was stopped by DiscoveryOptions.MaxObjects.
+var ErrMaxObjectsReached = errors.New("maximum number of objects reached")
+
+// ResourceError is an error encountered while dumping a resource.
+type ResourceError struct {
+	// Resource is the failed resource.
+	// It is empty for errors not caused by a single resource, for example a group failing discovery or a cancelled dump.
+	Resource schema.GroupVersionResource
+	Err      error
+	// Time is when the error was encountered. Errors of a resource are timed when listing the resource ended.
+	Time time.Time
+}
+
+// DumpError is returned by DiscoverObjects if objects could not be dumped.
+// errors.Is and errors.As match any of the contained errors.
+type DumpError struct {
+	// Errors are sorted by resource, errors not caused by a single resource come first.
+	Errors []ResourceError
+}
+
+// newDumpError returns a DumpError with the errors sorted by resource.
+// The order of the errors of a resource is kept.
+func newDumpError(errs []ResourceError) *DumpError {
+	slices.SortStableFunc(errs, func(a, b ResourceError) int {
+		if a.Resource.Empty() != b.Resource.Empty() {
+			if a.Resource.Empty() {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Resource.String(), b.Resource.String())
+	})
+	return &DumpError{Errors: errs}
+}
+
+// Error returns one line per resource with its errors separated by semicolons.
+// Errors not caused by a single resource are returned on their own line.
+func (e *DumpError) Error() string {
+	var b strings.Builder
+	for i, re := range e.Errors {
+		sameResource := i > 0 && !re.Resource.Empty() && re.Resource == e.Errors[i-1].Resource
+		switch {
+		case sameResource:
+			b.WriteString("; ")
+		case i > 0:
+			b.WriteByte('\n')
+		}
+		if !sameResource && !re.Resource.Empty() {
+			b.WriteString(re.Resource.String())
+			b.WriteString(": ")
+		}
+		b.WriteString(re.Err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the contained errors.
+func (e *DumpError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, re := range e.Errors {
+		errs[i] = re.Err
+	}
+	return errs
+}
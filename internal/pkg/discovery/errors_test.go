@@ -0,0 +1,34 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func Test_DumpError(t *testing.T) {
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	forbidden := apierrors.NewForbidden(secrets.GroupResource(), "", errors.New("no access"))
+	err := newDumpError([]ResourceError{
+		{Resource: secrets, Err: forbidden},
+		{Resource: deployments, Err: errors.New("failed to list")},
+		{Err: errors.New("failed to discover group")},
+		{Resource: secrets, Err: errors.New("failed to dump")},
+		{Err: context.Canceled},
+	})
+
+	require.Equal(t, `failed to discover group
+context canceled
+/v1, Resource=secrets: `+forbidden.Error()+`; failed to dump
+apps/v1, Resource=deployments: failed to list`, err.Error())
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, secrets, err.Errors[2].Resource)
+	var statusErr *apierrors.StatusError
+	require.ErrorAs(t, err, &statusErr)
+	require.Equal(t, forbidden, statusErr)
+}
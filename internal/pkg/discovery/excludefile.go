@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// kindPattern matches valid kind names.
+var kindPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// LoadGroupKindsFile reads a YAML or JSON file containing a list of group kinds, e.g. Deployment.apps.
+// Kinds without a group select the core group. Comments are supported in YAML files.
+// The file is read on every call so changes are picked up by the next dump.
+// An error naming the offending line is returned for malformed entries.
+func LoadGroupKindsFile(path string) ([]schema.GroupKind, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	gks, err := parseGroupKinds(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return gks, nil
+}
+
+// parseGroupKinds parses a YAML or JSON list of group kinds.
+// Errors of malformed entries are prefixed with the line number.
+func parseGroupKinds(raw []byte) ([]schema.GroupKind, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	list := doc.Content[0]
+	if list.Kind == yaml.ScalarNode && list.Tag == "!!null" {
+		return nil, nil
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("line %d: expected a list of group kinds", list.Line)
+	}
+	gks := make([]schema.GroupKind, 0, len(list.Content))
+	for _, n := range list.Content {
+		if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+			return nil, fmt.Errorf("line %d: expected a group kind string", n.Line)
+		}
+		gk, err := parseGroupKind(n.Value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		gks = append(gks, gk)
+	}
+	return gks, nil
+}
+
+// parseGroupKind parses and validates a group kind of the form Kind[.group].
+func parseGroupKind(s string) (schema.GroupKind, error) {
+	gk := schema.ParseGroupKind(strings.TrimSpace(s))
+	if !kindPattern.MatchString(gk.Kind) {
+		return gk, fmt.Errorf("invalid group kind %q: invalid kind %q", s, gk.Kind)
+	}
+	if gk.Group != "" {
+		if errs := validation.IsDNS1123Subdomain(gk.Group); len(errs) > 0 {
+			return gk, fmt.Errorf("invalid group kind %q: invalid group %q: %s", s, gk.Group, strings.Join(errs, "; "))
+		}
+	}
+	return gk, nil
+}
@@ -0,0 +1,88 @@
+package discovery_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+)
+
+func Test_LoadGroupKindsFile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		content   string
+		expected  []schema.GroupKind
+		expectErr string
+	}{
+		{
+			name: "YAML",
+			content: `# Generated objects
+- Deployment.apps
+- ConfigMap # core group
+- Certificate.cert-manager.io
+`,
+			expected: []schema.GroupKind{
+				{Group: "apps", Kind: "Deployment"},
+				{Kind: "ConfigMap"},
+				{Group: "cert-manager.io", Kind: "Certificate"},
+			},
+		},
+		{
+			name:     "JSON",
+			content:  `["Deployment.apps", "Secret"]`,
+			expected: []schema.GroupKind{{Group: "apps", Kind: "Deployment"}, {Kind: "Secret"}},
+		},
+		{
+			name:    "Empty",
+			content: "# nothing excluded\n",
+		},
+		{
+			name: "InvalidKind",
+			content: `- Deployment.apps
+# comment
+- "Config Map"
+`,
+			expectErr: `line 3: invalid group kind "Config Map"`,
+		},
+		{
+			name: "InvalidGroup",
+			content: `- Deployment.Apps_
+`,
+			expectErr: `line 1: invalid group kind "Deployment.Apps_"`,
+		},
+		{
+			name: "NotAString",
+			content: `- Deployment.apps
+- {kind: Secret}
+`,
+			expectErr: "line 2: expected a group kind string",
+		},
+		{
+			name:      "NotAList",
+			content:   "kinds: [Secret]\n",
+			expectErr: "line 1: expected a list of group kinds",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "exclude.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tc.content), 0644))
+
+			gks, err := discovery.LoadGroupKindsFile(path)
+			if tc.expectErr != "" {
+				require.ErrorContains(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, gks)
+		})
+	}
+}
+
+func Test_LoadGroupKindsFile_NotFound(t *testing.T) {
+	_, err := discovery.LoadGroupKindsFile(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,26 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"k8s.io/client-go/rest"
+)
+
+// checkExecProvider returns an error if the config uses an exec credential plugin that is not installed.
+// Without the check, client-go only fails at the first request with an authentication error.
+// Relative commands are resolved against the directory of the kubeconfig by clientcmd, other commands are looked up in PATH.
+func checkExecProvider(conf *rest.Config) error {
+	if conf.ExecProvider == nil {
+		return nil
+	}
+	if _, err := exec.LookPath(conf.ExecProvider.Command); err != nil {
+		msg := fmt.Sprintf("the kubeconfig uses the exec credential plugin %q, which is not installed or not executable: %v", conf.ExecProvider.Command, err)
+		if hint := conf.ExecProvider.InstallHint; hint != "" {
+			msg += "\n" + hint
+		}
+		return errors.New(msg)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// execKubeconfig is a kubeconfig authenticating with the exec credential plugin %s, like the kubeconfigs of EKS.
+const execKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: eks
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: eks
+  context:
+    cluster: eks
+    user: eks
+current-context: eks
+users:
+- name: eks
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: %s
+      args: [eks, get-token, --cluster-name, test]
+      installHint: Install the AWS CLI.
+      interactiveMode: Never
+`
+
+func Test_checkExecProvider(t *testing.T) {
+	tdir := t.TempDir()
+	plugin := filepath.Join(tdir, "fake-credential-plugin")
+	require.NoError(t, os.WriteFile(plugin, []byte("#!/bin/sh\n"), 0755))
+
+	for command, wantErr := range map[string]bool{
+		plugin:                               false,
+		"./fake-credential-plugin":           false,
+		"k8s-object-dumper-missing-aws-test": true,
+	} {
+		path := filepath.Join(tdir, "kubeconfig")
+		require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(execKubeconfig, command)), 0644))
+		conf, err := clientcmd.BuildConfigFromFlags("", path)
+		require.NoError(t, err)
+		require.NotNil(t, conf.ExecProvider)
+
+		err = checkExecProvider(conf)
+		if !wantErr {
+			require.NoError(t, err, command)
+			continue
+		}
+		require.ErrorContains(t, err, `the kubeconfig uses the exec credential plugin "k8s-object-dumper-missing-aws-test", which is not installed`)
+		require.ErrorContains(t, err, "Install the AWS CLI.")
+
+		_, err = DiscoverObjectsWithStats(context.Background(), conf, func(*unstructured.UnstructuredList) error { return nil }, DiscoveryOptions{})
+		require.ErrorContains(t, err, "which is not installed", "the discovery should fail before connecting")
+	}
+}
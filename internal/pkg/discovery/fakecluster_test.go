@@ -0,0 +1,451 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// fakeCluster is a fake API server to run the discovery against without a real cluster.
+// The dynamic client does not paginate and ignores field selectors.
+// Reactors can be added to the dynamic client to inject errors or custom responses.
+type fakeCluster struct {
+	t         testing.TB
+	discovery *fakePreferredDiscovery
+	dynamic   *dynamicfake.FakeDynamicClient
+}
+
+// fakePreferredDiscovery is a fake discovery client returning all resources as preferred resources.
+// The fake discovery client of client-go does not implement ServerPreferredResources.
+type fakePreferredDiscovery struct {
+	*fakediscovery.FakeDiscovery
+}
+
+// ServerPreferredResources returns all resources of the fake.
+func (d *fakePreferredDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	_, rls, err := d.ServerGroupsAndResources()
+	return rls, err
+}
+
+// fakeResource is a resource served by a fakeCluster.
+type fakeResource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+	// verbs defaults to list if empty.
+	verbs []string
+}
+
+// newFakeCluster creates a fake cluster serving the resources.
+func newFakeCluster(t testing.TB, resources ...fakeResource) *fakeCluster {
+	t.Helper()
+
+	listKinds := make(map[schema.GroupVersionResource]string, len(resources))
+	var rls []*metav1.APIResourceList
+	for _, res := range resources {
+		listKinds[res.gvr] = res.kind + "List"
+		verbs := res.verbs
+		if len(verbs) == 0 {
+			verbs = []string{"list"}
+		}
+		gv := res.gvr.GroupVersion().String()
+		var rl *metav1.APIResourceList
+		for _, l := range rls {
+			if l.GroupVersion == gv {
+				rl = l
+			}
+		}
+		if rl == nil {
+			rl = &metav1.APIResourceList{GroupVersion: gv}
+			rls = append(rls, rl)
+		}
+		rl.APIResources = append(rl.APIResources, metav1.APIResource{
+			Name:       res.gvr.Resource,
+			Kind:       res.kind,
+			Namespaced: res.namespaced,
+			Verbs:      verbs,
+		})
+	}
+
+	c := &fakeCluster{
+		t:       t,
+		dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds),
+	}
+	c.discovery = &fakePreferredDiscovery{FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: rls}}}
+	return c
+}
+
+// addObjects adds the objects of the resource to the cluster.
+func (c *fakeCluster) addObjects(res schema.GroupVersionResource, objs ...*unstructured.Unstructured) {
+	c.t.Helper()
+	for _, o := range objs {
+		require.NoError(c.t, c.dynamic.Tracker().Create(res, o, o.GetNamespace()))
+	}
+}
+
+// discover runs the discovery against the cluster and returns the dumped objects as namespace/name, by resource.
+func (c *fakeCluster) discover(opts DiscoveryOptions) (map[string][]string, []ResourceStat, error) {
+	c.t.Helper()
+	var mu sync.Mutex
+	objs := map[string][]string{}
+	stats, err := DiscoverObjectsWithClients(context.Background(), c.discovery, c.dynamic, func(l *unstructured.UnstructuredList) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, o := range l.Items {
+			k := strings.ToLower(o.GetKind())
+			objs[k] = append(objs[k], objectName(o))
+		}
+		return nil
+	}, opts)
+	return objs, stats, err
+}
+
+// newFakeObject returns an object of the kind with the given name and namespace.
+func newFakeObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	o := &unstructured.Unstructured{Object: map[string]any{"apiVersion": apiVersion, "kind": kind}}
+	o.SetNamespace(namespace)
+	o.SetName(name)
+	return o
+}
+
+var (
+	fakeNamespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	fakeConfigMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	fakeRolesGVR      = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}
+)
+
+// newDefaultFakeCluster returns a cluster with namespaces a and b, each with ConfigMaps and a Role.
+func newDefaultFakeCluster(t testing.TB) *fakeCluster {
+	c := newFakeCluster(t,
+		fakeResource{gvr: fakeNamespacesGVR, kind: "Namespace"},
+		fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true},
+		fakeResource{gvr: fakeRolesGVR, kind: "Role", namespaced: true},
+		fakeResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "bindings"}, kind: "Binding", namespaced: true, verbs: []string{"create"}},
+	)
+	for _, ns := range []string{"a", "b"} {
+		c.addObjects(fakeNamespacesGVR, newFakeObject("v1", "Namespace", "", ns))
+		c.addObjects(fakeConfigMapsGVR,
+			newFakeObject("v1", "ConfigMap", ns, "config"),
+			newFakeObject("v1", "ConfigMap", ns, "tmp-config"),
+		)
+		c.addObjects(fakeRolesGVR, newFakeObject("rbac.authorization.k8s.io/v1", "Role", ns, "role"))
+	}
+	return c
+}
+
+func Test_DiscoverObjectsWithClients_FakeCluster(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+
+	objs, stats, err := c.discover(DiscoveryOptions{})
+	require.NoError(t, err)
+	for _, names := range objs {
+		slices.Sort(names)
+	}
+	require.Equal(t, map[string][]string{
+		"namespace": {"a", "b"},
+		"configmap": {"a/config", "a/tmp-config", "b/config", "b/tmp-config"},
+		"role":      {"a/role", "b/role"},
+	}, objs)
+
+	counts := map[string]int{}
+	for _, s := range stats {
+		counts[s.Resource.Resource] = s.Count
+		if s.Resource.Resource == "bindings" {
+			require.True(t, s.Skipped)
+		}
+	}
+	require.Equal(t, map[string]int{"namespaces": 2, "configmaps": 4, "roles": 2, "bindings": 0}, counts)
+}
+
+func Test_DiscoverObjectsWithClients_FakeCluster_Filters(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+
+	objs, _, err := c.discover(DiscoveryOptions{
+		ExcludeNamespaces: []string{"a"},
+		ExcludeKinds:      []string{"role"},
+		ExcludeNameRegex:  "^tmp-",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"namespace": {"b"},
+		"configmap": {"b/config"},
+	}, objs)
+}
+
+func Test_DiscoverObjectsWithClients_FakeCluster_KindPatterns(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+
+	for name, tc := range map[string]struct {
+		include, exclude []string
+		expected         []string
+	}{
+		"glob":              {include: []string{"*map"}, expected: []string{"configmap"}},
+		"character class":   {include: []string{"[nr]*"}, expected: []string{"namespace", "role"}},
+		"exclude glob":      {exclude: []string{"?ole", "config*"}, expected: []string{"namespace"}},
+		"escaped glob":      {include: []string{`Role\*`}},
+		"literal":           {include: []string{"ROLE"}, expected: []string{"role"}},
+		"literal backslash": {include: []string{`Role\`}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			objs, _, err := c.discover(DiscoveryOptions{IncludeKinds: tc.include, ExcludeKinds: tc.exclude})
+			require.NoError(t, err)
+			require.ElementsMatch(t, tc.expected, slices.Collect(maps.Keys(objs)))
+		})
+	}
+
+	_, _, err := c.discover(DiscoveryOptions{IncludeKinds: []string{"Role[", `Role\`}})
+	require.ErrorContains(t, err, `invalid kind pattern "Role["`)
+	require.NotContains(t, err.Error(), `Role\\`, "patterns without glob metacharacters are not validated")
+}
+
+func Test_DiscoverObjectsWithClients_FakeCluster_Retry(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+	failures := 2
+	c.dynamic.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, apierrors.NewTooManyRequests("slow down", 0)
+		}
+		return false, nil, nil
+	})
+
+	objs, _, err := c.discover(DiscoveryOptions{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	require.NoError(t, err)
+	require.Len(t, objs["configmap"], 4, "the listing should succeed after retrying")
+
+	failures = 3
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	objs, stats, err := c.discover(DiscoveryOptions{MaxRetries: 2, RetryBackoff: time.Millisecond, Clock: testingclock.NewFakePassiveClock(now)})
+	require.ErrorContains(t, err, "slow down")
+	var dumpErr *DumpError
+	require.ErrorAs(t, err, &dumpErr)
+	require.Len(t, dumpErr.Errors, 1)
+	require.Equal(t, fakeConfigMapsGVR, dumpErr.Errors[0].Resource)
+	require.True(t, apierrors.IsTooManyRequests(dumpErr.Errors[0].Err))
+	require.Equal(t, now, dumpErr.Errors[0].Time)
+	require.Empty(t, objs["configmap"])
+	require.Len(t, objs["role"], 2, "other resources should still be dumped")
+	i := slices.IndexFunc(stats, func(s ResourceStat) bool { return s.Resource == fakeConfigMapsGVR })
+	require.True(t, stats[i].Failed)
+}
+
+func Test_DiscoverObjectsWithClients_RestoreOrder(t *testing.T) {
+	crdsGVR := schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	c := newFakeCluster(t,
+		fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true},
+		fakeResource{gvr: fakeRolesGVR, kind: "Role", namespaced: true},
+		fakeResource{gvr: crdsGVR, kind: "CustomResourceDefinition"},
+		fakeResource{gvr: fakeNamespacesGVR, kind: "Namespace"},
+	)
+	c.addObjects(fakeConfigMapsGVR, newFakeObject("v1", "ConfigMap", "a", "config"))
+	c.addObjects(fakeRolesGVR, newFakeObject("rbac.authorization.k8s.io/v1", "Role", "a", "role"))
+	c.addObjects(crdsGVR, newFakeObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "tests.example.com"))
+	c.addObjects(fakeNamespacesGVR, newFakeObject("v1", "Namespace", "", "a"))
+
+	for _, concurrency := range []int{1, 4} {
+		var mu sync.Mutex
+		var kinds []string
+		_, err := DiscoverObjectsWithClients(context.Background(), c.discovery, c.dynamic, func(l *unstructured.UnstructuredList) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, o := range l.Items {
+				kinds = append(kinds, o.GetKind())
+			}
+			return nil
+		}, DiscoveryOptions{RestoreOrder: true, Concurrency: concurrency})
+		require.NoError(t, err)
+		require.Len(t, kinds, 4)
+		require.ElementsMatch(t, []string{"CustomResourceDefinition", "Namespace"}, kinds[:2], "namespaces and CRDs should be dumped first")
+	}
+}
+
+func Test_DiscoverObjectsWithClients_Resource(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+
+	for _, resource := range []string{"roles.rbac.authorization.k8s.io", "roles.v1.rbac.authorization.k8s.io"} {
+		objs, stats, err := c.discover(DiscoveryOptions{Resource: resource, IncludeNamespaces: []string{"b"}})
+		require.NoError(t, err)
+		require.Equal(t, map[string][]string{"role": {"b/role"}}, objs)
+		require.Len(t, stats, 1, "only the resource should be listed")
+	}
+
+	objs, _, err := c.discover(DiscoveryOptions{Resource: "configmaps", ExcludeNameRegex: "^tmp-"})
+	require.NoError(t, err)
+	slices.Sort(objs["configmap"])
+	require.Equal(t, map[string][]string{"configmap": {"a/config", "b/config"}}, objs, "object filters should still be applied")
+
+	for resource, msg := range map[string]string{
+		"roles.example.com":                  `resource "roles.example.com" not found: no such group or version`,
+		"widgets":                            `resource "widgets" not found in v1`,
+		"bindings":                           "no list verb",
+		"roles.v2.rbac.authorization.k8s.io": `resource "roles.v2.rbac.authorization.k8s.io" not found: no such group or version`,
+	} {
+		_, _, err := c.discover(DiscoveryOptions{Resource: resource})
+		require.ErrorContains(t, err, msg, resource)
+	}
+}
+
+func Test_DiscoverObjectsWithClients_MaxObjects(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+
+	for _, concurrency := range []int{1, 4} {
+		objs, stats, err := c.discover(DiscoveryOptions{MaxObjects: 3, Concurrency: concurrency})
+		require.ErrorIs(t, err, ErrMaxObjectsReached)
+		var dumpErr *DumpError
+		require.ErrorAs(t, err, &dumpErr)
+		require.Len(t, dumpErr.Errors, 1, "errors of the stopped resources should be replaced")
+		n, counted := 0, 0
+		for _, names := range objs {
+			n += len(names)
+		}
+		for _, s := range stats {
+			counted += s.Count
+		}
+		require.Equal(t, 3, n, "the dump should stop at the limit")
+		require.Equal(t, 3, counted)
+	}
+
+	objs, _, err := c.discover(DiscoveryOptions{MaxObjects: 8})
+	require.ErrorIs(t, err, ErrMaxObjectsReached, "reaching the limit with the last object should stop the dump")
+	require.Len(t, objs["configmap"], 4)
+
+	_, _, err = c.discover(DiscoveryOptions{MaxObjects: 9})
+	require.NoError(t, err)
+}
+
+func Test_DiscoverObjectsWithClients_ExcludeSystemNamespaces(t *testing.T) {
+	c := newFakeCluster(t,
+		fakeResource{gvr: fakeNamespacesGVR, kind: "Namespace"},
+		fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true},
+	)
+	for _, ns := range []string{"app", "kube-system", "kube-public", "kube-flannel"} {
+		c.addObjects(fakeNamespacesGVR, newFakeObject("v1", "Namespace", "", ns))
+		c.addObjects(fakeConfigMapsGVR, newFakeObject("v1", "ConfigMap", ns, "config"))
+	}
+
+	for name, tc := range map[string]struct {
+		opts     DiscoveryOptions
+		expected []string
+	}{
+		"system": {
+			opts:     DiscoveryOptions{ExcludeSystemNamespaces: true},
+			expected: []string{"app", "kube-flannel"},
+		},
+		"kube prefix": {
+			opts:     DiscoveryOptions{ExcludeKubeNamespaces: true},
+			expected: []string{"app"},
+		},
+		"explicit include wins": {
+			opts:     DiscoveryOptions{ExcludeKubeNamespaces: true, IncludeNamespaces: []string{"app", "kube-public"}},
+			expected: []string{"app", "kube-public"},
+		},
+		"explicit exclude wins": {
+			opts:     DiscoveryOptions{ExcludeSystemNamespaces: true, IncludeNamespaces: []string{"app", "kube-public"}, ExcludeNamespaces: []string{"kube-public"}},
+			expected: []string{"app"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			objs, _, err := c.discover(tc.opts)
+			require.NoError(t, err)
+			namespaces := objs["namespace"]
+			slices.Sort(namespaces)
+			configMaps := objs["configmap"]
+			slices.Sort(configMaps)
+			expectedConfigMaps := make([]string, len(tc.expected))
+			for i, ns := range tc.expected {
+				expectedConfigMaps[i] = ns + "/config"
+			}
+			require.Equal(t, expectedConfigMaps, configMaps)
+			if len(tc.opts.IncludeNamespaces) == 0 {
+				require.Equal(t, tc.expected, namespaces, "the Namespace objects of skipped namespaces should not be dumped")
+			}
+		})
+	}
+}
+
+func Test_DiscoveryOptions_GetBatchSize(t *testing.T) {
+	overrides := map[schema.GroupResource]int64{fakeConfigMapsGVR.GroupResource(): 20}
+	for size, expected := range map[int64]int64{
+		0:            DefaultBatchSize,
+		NoBatchLimit: 0,
+		100:          100,
+	} {
+		opts := DiscoveryOptions{BatchSize: size, OverrideBatchSize: overrides}
+		require.Equal(t, expected, opts.GetBatchSize(), size)
+		require.Equal(t, expected, opts.GetBatchSizeFor(fakeRolesGVR.GroupResource()), size)
+		require.Equal(t, int64(20), opts.GetBatchSizeFor(fakeConfigMapsGVR.GroupResource()), "the override should win over %d", size)
+	}
+
+	c := newDefaultFakeCluster(t)
+	_, _, err := c.discover(DiscoveryOptions{BatchSize: -2})
+	require.ErrorContains(t, err, "invalid batch size -2")
+	objs, _, err := c.discover(DiscoveryOptions{BatchSize: NoBatchLimit})
+	require.NoError(t, err)
+	require.Len(t, objs["configmap"], 4)
+}
+
+func Test_DiscoverObjectsWithClients_ServerVersion(t *testing.T) {
+	c := newDefaultFakeCluster(t)
+	c.discovery.FakedServerVersion = &version.Info{GitVersion: "v1.31.0"}
+
+	var log bytes.Buffer
+	var got *version.Info
+	_, _, err := c.discover(DiscoveryOptions{LogWriter: &log, ServerVersion: func(v *version.Info) { got = v }})
+	require.NoError(t, err)
+	require.Equal(t, "v1.31.0", got.GitVersion)
+	require.Contains(t, log.String(), "Kubernetes server version v1.31.0")
+
+	c.discovery.PrependReactor("get", "version", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	log.Reset()
+	got = nil
+	objs, _, err := c.discover(DiscoveryOptions{LogWriter: &log, ServerVersion: func(v *version.Info) { got = v }})
+	require.NoError(t, err, "the dump should continue without the version")
+	require.Len(t, objs["configmap"], 4)
+	require.Nil(t, got)
+	require.Contains(t, log.String(), "warning: failed to get the server version: connection refused")
+}
+
+func Test_DiscoverObjectsWithClients_FakeCluster_DuplicateVersions(t *testing.T) {
+	hpaV2 := schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+	hpaV1 := hpaV2.GroupResource().WithVersion("v1")
+	// The first version is the preferred one, as returned by ServerPreferredResources.
+	c := newFakeCluster(t,
+		fakeResource{gvr: hpaV2, kind: "HorizontalPodAutoscaler", namespaced: true},
+		fakeResource{gvr: hpaV1, kind: "HorizontalPodAutoscaler", namespaced: true},
+	)
+	c.addObjects(hpaV2, newFakeObject("autoscaling/v2", "HorizontalPodAutoscaler", "a", "hpa"))
+	c.addObjects(hpaV1, newFakeObject("autoscaling/v1", "HorizontalPodAutoscaler", "a", "hpa"))
+
+	var log bytes.Buffer
+	objs, stats, err := c.discover(DiscoveryOptions{LogWriter: &log})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/hpa"}, objs["horizontalpodautoscaler"], "the resource should only be listed once")
+	require.Len(t, stats, 2)
+	i := slices.IndexFunc(stats, func(s ResourceStat) bool { return s.Resource == hpaV2 })
+	require.False(t, stats[i].Skipped, "the preferred version should be listed")
+	require.Equal(t, 1, stats[i].Count)
+	i = slices.IndexFunc(stats, func(s ResourceStat) bool { return s.Resource == hpaV1 })
+	require.True(t, stats[i].Skipped)
+	require.Equal(t, "duplicate of version v2", stats[i].SkipReason)
+	require.Contains(t, log.String(), "skipping autoscaling/v1, Resource=horizontalpodautoscalers: duplicate of version v2")
+}
@@ -0,0 +1,478 @@
+package discovery
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/dynamic"
+)
+
+// maxContinueRestarts is the number of times the listing of a resource is restarted if the continue token expired.
+const maxContinueRestarts = 3
+
+// listJob is a resource to be listed by a worker.
+type listJob struct {
+	res        schema.GroupVersionResource
+	namespaced bool
+}
+
+// listJobErrors are the errors encountered while listing a resource.
+type listJobErrors struct {
+	res  schema.GroupVersionResource
+	errs []error
+	// time is when listing the resource ended.
+	time time.Time
+}
+
+// lister lists resources in batches and passes the objects to the callback.
+type lister struct {
+	opts     DiscoveryOptions
+	client   dynamic.Interface
+	listOpts metav1.ListOptions
+	// namespaces to list namespaced resources in. If IncludeNamespaces is empty, namespaced resources are listed cluster-wide.
+	namespaces []string
+	// keep returns false for objects that should be removed from a batch before calling the callback.
+	keep func(schema.GroupVersionResource, unstructured.Unstructured) bool
+	// annotationMatches returns true for objects matching the annotation selector. Nil if no selector is set.
+	annotationMatches func(unstructured.Unstructured) bool
+	// excludeName matches the names of objects to remove from a batch. Nil if DiscoveryOptions.ExcludeNameRegex is not set.
+	excludeName *regexp.Regexp
+	// excludeUIDs are the UIDs of objects to remove from a batch. Nil if DiscoveryOptions.ExcludeUIDs is empty.
+	excludeUIDs sets.Set[types.UID]
+	// transforms are applied to every object before calling the callback.
+	transforms []TransformFunc
+	cb         func(*unstructured.UnstructuredList) error
+	log        *slog.Logger
+	checkpoint *checkpoint
+	watermarks *watermarks
+	since      *sinceFile
+	// watchUntil is the time all watches end at if DiscoveryOptions.WatchDuration is set.
+	watchUntil time.Time
+	// dumped is the number of objects passed to the callback, counted if DiscoveryOptions.MaxObjects is set.
+	dumped atomic.Int64
+	// stop cancels the dump once DiscoveryOptions.MaxObjects is reached. Nil if MaxObjects is not set.
+	stop context.CancelCauseFunc
+}
+
+// run lists all objects of the job's resource, per namespace if required.
+func (rl *lister) run(ctx context.Context, j listJob) (ResourceStat, []error) {
+	start := rl.opts.GetClock().Now()
+	stat := ResourceStat{Resource: j.res}
+	defer rl.opts.Metrics.startListing(j.res)()
+	ctx, span := tracer().Start(ctx, "ListResource", trace.WithAttributes(resourceAttributes(j.res)...))
+	defer span.End()
+	list := rl.listResource
+	switch {
+	case rl.opts.DryRun:
+		list = rl.estimateResource
+	case rl.opts.WatchDuration > 0:
+		list = rl.watchResource
+	default:
+		size := rl.opts.GetBatchSizeFor(j.res.GroupResource())
+		if size == 0 {
+			rl.log.Debug(fmt.Sprintf("%s: listing without batch size limit", j.res), gvrAttr(j.res), "batch_size", size)
+		} else {
+			rl.log.Debug(fmt.Sprintf("%s: listing with batch size %d", j.res, size), gvrAttr(j.res), "batch_size", size)
+		}
+	}
+	var errs []error
+	if !j.namespaced || len(rl.opts.IncludeNamespaces) == 0 {
+		errs = list(ctx, j.res, "", &stat)
+	} else {
+		errs = rl.listNamespaces(ctx, j.res, list, &stat)
+	}
+	stat.Duration = rl.opts.GetClock().Since(start)
+	stat.Failed = len(errs) > 0
+	// Resources cut short by MaxObjects keep their watermark, the objects not dumped may have lower resourceVersions than the dumped ones.
+	if stat.Failed || errors.Is(context.Cause(ctx), ErrMaxObjectsReached) {
+		rl.watermarks.reset(j.res)
+	}
+	span.SetAttributes(attribute.Int("k8s.items", stat.Count), attribute.Int("k8s.batches", stat.Batches))
+	recordErrors(span, errs...)
+	rl.opts.Metrics.addErrors(len(errs))
+	if stat.Skipped {
+		rl.opts.Metrics.addSkipped(1)
+	}
+	if stat.ExcludedByOwner > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects owned by excluded kinds", j.res, stat.ExcludedByOwner),
+			gvrAttr(j.res), "count", stat.ExcludedByOwner, "skipped_reason", "owned by excluded kind")
+	}
+	if stat.ExcludedByName > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects with names matching %q", j.res, stat.ExcludedByName, rl.opts.ExcludeNameRegex),
+			gvrAttr(j.res), "count", stat.ExcludedByName, "skipped_reason", "name matches exclude regex")
+	}
+	if stat.ExcludedBySize > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects larger than %d bytes", j.res, stat.ExcludedBySize, rl.opts.MaxObjectBytes),
+			gvrAttr(j.res), "count", stat.ExcludedBySize, "skipped_reason", "larger than max object bytes")
+	}
+	if stat.ExcludedByWatermark > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects not changed since the last watermark", j.res, stat.ExcludedByWatermark),
+			gvrAttr(j.res), "count", stat.ExcludedByWatermark, "skipped_reason", "not changed since watermark")
+	}
+	if stat.ExcludedByUID > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects seen in a previous dump", j.res, stat.ExcludedByUID),
+			gvrAttr(j.res), "count", stat.ExcludedByUID, "skipped_reason", "seen in a previous dump")
+	}
+	if stat.ExcludedByCreationTime > 0 {
+		rl.log.Info(fmt.Sprintf("%s: skipped %d objects created outside the time window", j.res, stat.ExcludedByCreationTime),
+			gvrAttr(j.res), "count", stat.ExcludedByCreationTime, "skipped_reason", "created outside the time window")
+	}
+	if rl.annotationMatches != nil && stat.Count+stat.ExcludedByAnnotation > 0 && !rl.opts.DryRun {
+		rl.log.Info(fmt.Sprintf("%s: annotation selector %q kept %d objects and skipped %d", j.res, rl.opts.AnnotationSelector, stat.Count, stat.ExcludedByAnnotation),
+			gvrAttr(j.res), "annotation_selector", rl.opts.AnnotationSelector, "count", stat.Count, "skipped", stat.ExcludedByAnnotation)
+	}
+	if stat.UnknownCreationTime > 0 {
+		rl.log.Warn(fmt.Sprintf("%s: kept %d objects with a missing or invalid creation timestamp", j.res, stat.UnknownCreationTime),
+			gvrAttr(j.res), "count", stat.UnknownCreationTime)
+	}
+	rl.progress(ProgressEvent{Resource: j.res, Count: stat.Count, Complete: true})
+	return stat, errs
+}
+
+// listNamespaces lists the resource from every namespace, up to opts.NamespaceConcurrency namespaces in parallel.
+// With opts.WatchDuration all namespaces are watched in parallel.
+// The stats of the namespaces are added to stat.
+func (rl *lister) listNamespaces(ctx context.Context, res schema.GroupVersionResource, list listFunc, stat *ResourceStat) []error {
+	var mu sync.Mutex
+	var errs []error
+	nsCh := make(chan string)
+	var wg sync.WaitGroup
+	workers := rl.opts.GetNamespaceConcurrency()
+	if rl.opts.WatchDuration > 0 {
+		// A watch blocks its goroutine for the whole duration, all namespaces must be watched at the same time.
+		workers = len(rl.namespaces)
+	}
+	for range min(workers, len(rl.namespaces)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ns := range nsCh {
+				nsStat := ResourceStat{Resource: res}
+				nsErrs := list(ctx, res, ns, &nsStat)
+				mu.Lock()
+				stat.add(nsStat)
+				errs = append(errs, nsErrs...)
+				mu.Unlock()
+			}
+		}()
+	}
+dispatch:
+	for _, ns := range rl.namespaces {
+		select {
+		case nsCh <- ns:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(nsCh)
+	wg.Wait()
+	return errs
+}
+
+// listFunc lists the resource from the namespace, or all namespaces if ns is empty, and adds the counts to stat.
+type listFunc func(ctx context.Context, res schema.GroupVersionResource, ns string, stat *ResourceStat) []error
+
+// skipOnError returns true and marks the resource as skipped if the list error should not fail the dump.
+func (rl *lister) skipOnError(err error, key checkpointKey, stat *ResourceStat) bool {
+	switch {
+	case isFieldSelectorNotSupported(err):
+		stat.Skipped = true
+		stat.SkipReason = err.Error()
+	case rl.opts.SkipForbidden && apierrors.IsForbidden(err):
+		stat.Skipped = true
+		stat.SkipReason = SkipReasonForbidden
+	default:
+		return false
+	}
+	rl.log.Info(fmt.Sprintf("skipping %s: %v", key, err), append(key.logAttrs(), "skipped_reason", stat.SkipReason)...)
+	return true
+}
+
+func (rl *lister) progress(e ProgressEvent) {
+	if rl.opts.Progress != nil {
+		rl.opts.Progress(e)
+	}
+}
+
+// listResource lists all objects of the given resource in batches and calls the callback for each batch.
+// If ns is not empty, only objects in the given namespace are listed.
+// The number of listed objects and batches is added to stat.
+// If the continue token expires, the listing is restarted from the beginning up to maxContinueRestarts times.
+// If the API server returns the continue token it was sent, the listing is stopped with an error.
+// If the resource is not found anymore while paginating, the listing is stopped with a warning and the objects listed before are kept.
+// The objects listed before the restart are then passed to the callback again.
+// Errors are returned and do not stop the listing of other resources.
+func (rl *lister) listResource(ctx context.Context, res schema.GroupVersionResource, ns string, stat *ResourceStat) []error {
+	var ri dynamic.ResourceInterface = rl.client.Resource(res)
+	if ns != "" {
+		ri = rl.client.Resource(res).Namespace(ns)
+	}
+
+	key := newCheckpointKey(res, ns)
+	if rl.checkpoint.isCompleted(key) {
+		stat.Skipped = true
+		stat.SkipReason = "completed according to checkpoint"
+		rl.log.Info(fmt.Sprintf("skipping %s: %s", key, stat.SkipReason), append(key.logAttrs(), "skipped_reason", stat.SkipReason)...)
+		return nil
+	}
+
+	var errors []error
+	// dumpFailed stops the progress from being persisted, so a resumed dump retries the failed batch.
+	dumpFailed := false
+	listOpts := rl.listOpts
+	listOpts.Limit = rl.opts.GetBatchSizeFor(res.GroupResource())
+	if cont := rl.checkpoint.continueToken(key); cont != "" {
+		rl.log.Info(fmt.Sprintf("resuming %s from checkpoint", key), key.logAttrs()...)
+		listOpts.Continue = cont
+	}
+	restarts := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return append(errors, fmt.Errorf("listing %s interrupted: %w", key, err))
+		}
+		l, err := rl.listWithRetry(ctx, ri, res, ns, listOpts)
+		if rl.skipOnError(err, key, stat) {
+			break
+		}
+		if apierrors.IsResourceExpired(err) && listOpts.Continue != "" && restarts < maxContinueRestarts {
+			restarts++
+			rl.log.Warn(fmt.Sprintf("warning: continue token of %s expired, restarting the listing (restart %d/%d): %v", key, restarts, maxContinueRestarts, err),
+				append(key.logAttrs(), "restart", restarts, "error", err)...)
+			listOpts.Continue = ""
+			continue
+		}
+		if apierrors.IsNotFound(err) && listOpts.Continue != "" {
+			// The resource was removed while listing, for example by deleting its CRD. The objects dumped before are still valid.
+			rl.log.Warn(fmt.Sprintf("warning: %s was removed while listing, keeping the %d objects listed before: %v", key, stat.Count, err),
+				append(key.logAttrs(), "count", stat.Count, "error", err)...)
+			break
+		}
+		if err != nil {
+			return append(errors, fmt.Errorf("failed to list %s: %w", res, err))
+		}
+		if listOpts.Continue != "" && l.GetContinue() == listOpts.Continue {
+			// A buggy API server returning the same token would make the loop list the same batch forever.
+			return append(errors, fmt.Errorf("failed to list %s: the API server returned the continue token %q it was sent", key, l.GetContinue()))
+		}
+		errs, failed := rl.dumpBatch(ctx, res, ns, l, stat)
+		errors = append(errors, errs...)
+		dumpFailed = dumpFailed || failed
+		if l.GetContinue() == "" {
+			break
+		}
+		listOpts.Continue = l.GetContinue()
+		if dumpFailed {
+			continue
+		}
+		if err := rl.checkpoint.saveProgress(key, listOpts.Continue); err != nil {
+			errors = append(errors, fmt.Errorf("failed to save checkpoint for %s: %w", res, err))
+		}
+	}
+	if dumpFailed {
+		return errors
+	}
+	if err := rl.checkpoint.complete(key); err != nil {
+		errors = append(errors, fmt.Errorf("failed to save checkpoint for %s: %w", res, err))
+	}
+	return errors
+}
+
+// dumpBatch filters and transforms the listed objects, passes the remaining objects to the callback, and adds the counts to stat.
+// dumpFailed is true if the callback failed.
+func (rl *lister) dumpBatch(ctx context.Context, res schema.GroupVersionResource, ns string, l *unstructured.UnstructuredList, stat *ResourceStat) (errors []error, dumpFailed bool) {
+	if rl.watermarks != nil {
+		// All listed objects raise the watermark, even if they are filtered below.
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return !rl.watermarks.changed(res, o)
+		})
+		stat.ExcludedByWatermark += n - len(l.Items)
+	}
+	if rl.excludeUIDs != nil {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return rl.excludeUIDs.Has(o.GetUID())
+		})
+		stat.ExcludedByUID += n - len(l.Items)
+	}
+	l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+		return !rl.keep(res, o)
+	})
+	if len(rl.opts.ExcludeOwnedBy) > 0 {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return ownedByExcludedKind(o, rl.opts.ExcludeOwnedBy)
+		})
+		stat.ExcludedByOwner += n - len(l.Items)
+	}
+	if rl.excludeName != nil {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return rl.excludeName.MatchString(o.GetName())
+		})
+		stat.ExcludedByName += n - len(l.Items)
+	}
+	if !rl.opts.CreatedAfter.IsZero() || !rl.opts.CreatedBefore.IsZero() {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			in, known := rl.opts.createdInWindow(o)
+			if !known {
+				stat.UnknownCreationTime++
+			}
+			return !in
+		})
+		stat.ExcludedByCreationTime += n - len(l.Items)
+	}
+	if rl.annotationMatches != nil {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return !rl.annotationMatches(o)
+		})
+		stat.ExcludedByAnnotation += n - len(l.Items)
+	}
+	if len(rl.transforms) > 0 {
+		kept := l.Items[:0]
+		for i := range l.Items {
+			if err := rl.transform(&l.Items[i]); err != nil {
+				errors = append(errors, fmt.Errorf("failed to transform %s %s: %w", res, objectName(l.Items[i]), err))
+				continue
+			}
+			kept = append(kept, l.Items[i])
+		}
+		l.Items = kept
+	}
+	if rl.opts.MaxObjectBytes > 0 {
+		n := len(l.Items)
+		l.Items = slices.DeleteFunc(l.Items, func(o unstructured.Unstructured) bool {
+			return rl.oversized(res, o)
+		})
+		stat.ExcludedBySize += n - len(l.Items)
+	}
+	if rl.opts.SortItems {
+		sortItems(l.Items)
+	}
+	reachedMax := false
+	if rl.stop != nil {
+		l.Items, reachedMax = rl.limit(l.Items)
+	}
+	if err := rl.dumpWithRetry(ctx, res, ns, l); err != nil {
+		errors = append(errors, fmt.Errorf("failed to dump %s: %w", res, err))
+		dumpFailed = true
+	} else {
+		rl.opts.Metrics.addObjects(res, len(l.Items))
+		rl.since.observe(l.Items)
+	}
+	stat.Count += len(l.Items)
+	stat.Batches++
+	rl.progress(ProgressEvent{Resource: res, Namespace: ns, BatchCount: len(l.Items), Count: stat.Count})
+	if reachedMax {
+		// The truncated batch is dumped before stopping, so the dumper has written all counted objects.
+		rl.stop(fmt.Errorf("stopped after %d objects: %w", rl.opts.MaxObjects, ErrMaxObjectsReached))
+	}
+	return errors, dumpFailed
+}
+
+// limit truncates the items to the number of objects left until DiscoveryOptions.MaxObjects is reached.
+// reached is true if the limit is reached with the returned items.
+func (rl *lister) limit(items []unstructured.Unstructured) (limited []unstructured.Unstructured, reached bool) {
+	total := rl.dumped.Add(int64(len(items)))
+	if total < rl.opts.MaxObjects {
+		return items, false
+	}
+	over := min(total-rl.opts.MaxObjects, int64(len(items)))
+	return items[:int64(len(items))-over], true
+}
+
+// transform applies the transformations to the object. The first error stops the transformation.
+func (rl *lister) transform(o *unstructured.Unstructured) error {
+	for _, t := range rl.transforms {
+		if err := t(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oversized returns true and logs the object if its JSON serialization is larger than DiscoveryOptions.MaxObjectBytes.
+// Objects that can't be serialized are kept so the dumper reports the error.
+func (rl *lister) oversized(res schema.GroupVersionResource, o unstructured.Unstructured) bool {
+	b, err := json.Marshal(o.Object)
+	if err != nil || int64(len(b)) <= rl.opts.MaxObjectBytes {
+		return false
+	}
+	rl.log.Warn(fmt.Sprintf("%s: skipped %s, %d bytes exceed the maximum of %d", res, objectName(o), len(b), rl.opts.MaxObjectBytes),
+		gvrAttr(res), "object", objectName(o), "size", len(b), "skipped_reason", "larger than max object bytes")
+	return true
+}
+
+// sortItems sorts the objects by namespace, then name.
+func sortItems(items []unstructured.Unstructured) {
+	slices.SortFunc(items, func(a, b unstructured.Unstructured) int {
+		return cmp.Or(
+			strings.Compare(a.GetNamespace(), b.GetNamespace()),
+			strings.Compare(a.GetName(), b.GetName()),
+		)
+	})
+}
+
+// objectName returns the name of the object prefixed by its namespace if namespaced.
+func objectName(o unstructured.Unstructured) string {
+	if o.GetNamespace() == "" {
+		return o.GetName()
+	}
+	return o.GetNamespace() + "/" + o.GetName()
+}
+
+// estimateResource lists a single object of the given resource to estimate the number of objects.
+// The estimate is logged and added to stat. The callback is not called.
+func (rl *lister) estimateResource(ctx context.Context, res schema.GroupVersionResource, ns string, stat *ResourceStat) []error {
+	var ri dynamic.ResourceInterface = rl.client.Resource(res)
+	if ns != "" {
+		ri = rl.client.Resource(res).Namespace(ns)
+	}
+	key := newCheckpointKey(res, ns)
+
+	listOpts := rl.listOpts
+	listOpts.Limit = 1
+	l, err := rl.listWithRetry(ctx, ri, res, ns, listOpts)
+	if rl.skipOnError(err, key, stat) {
+		return nil
+	}
+	if err != nil {
+		return []error{fmt.Errorf("failed to list %s: %w", res, err)}
+	}
+	stat.Batches++
+
+	n := int64(len(l.Items))
+	var msg string
+	if l.GetContinue() == "" {
+		msg = fmt.Sprintf("%s: %d objects", key, n)
+	} else if rem := l.GetRemainingItemCount(); rem != nil {
+		n += *rem
+		msg = fmt.Sprintf("%s: ~%d objects", key, n)
+	} else {
+		// The API server does not report the remaining item count for selectors.
+		msg = fmt.Sprintf("%s: more than %d objects", key, n)
+	}
+	rl.log.Info(msg, append(key.logAttrs(), "count", n, "estimated", l.GetContinue() != "")...)
+	stat.Count += int(n)
+	return nil
+}
@@ -0,0 +1,416 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_lister_RepeatingContinueToken(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"})
+	var calls int
+	client.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMapList"}}
+		l.Items = []unstructured.Unstructured{{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]any{"name": "test-cm"}}}}
+		// A buggy API server always returning the same token.
+		l.SetContinue("same-token")
+		return true, l, nil
+	})
+
+	var batches int
+	rl := &lister{
+		client: client,
+		keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		cb: func(*unstructured.UnstructuredList) error {
+			batches++
+			return nil
+		},
+		log: slog.New(newLineHandler(io.Discard)),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], `returned the continue token "same-token" it was sent`)
+	require.True(t, stat.Failed)
+	require.Equal(t, 2, calls, "the listing should stop at the first repeated token")
+	require.Equal(t, 1, batches, "the repeated batch should not be dumped")
+}
+
+func Test_lister_SortItems(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	names := [][2]string{{"b", "cm-1"}, {"a", "cm-2"}, {"b", "cm-0"}, {"a", "cm-1"}, {"c", "cm-0"}}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"})
+	client.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMapList"}}
+		for _, i := range rand.Perm(len(names)) {
+			l.Items = append(l.Items, unstructured.Unstructured{Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"namespace": names[i][0], "name": names[i][1]},
+			}})
+		}
+		return true, l, nil
+	})
+
+	for range 10 {
+		var got []string
+		rl := &lister{
+			opts:   DiscoveryOptions{SortItems: true},
+			client: client,
+			keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+			cb: func(l *unstructured.UnstructuredList) error {
+				for _, o := range l.Items {
+					got = append(got, objectName(o))
+				}
+				return nil
+			},
+			log: slog.New(newLineHandler(io.Discard)),
+		}
+		_, errs := rl.run(context.Background(), listJob{res: res})
+		require.Empty(t, errs)
+		require.Equal(t, []string{"a/cm-1", "a/cm-2", "b/cm-0", "b/cm-1", "c/cm-0"}, got)
+	}
+}
+
+func Test_lister_ExcludeNameRegex(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"})
+	client.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMapList"}}
+		for _, name := range []string{"tmp-1", "config", "my-tmp-2", "tmp"} {
+			l.Items = append(l.Items, unstructured.Unstructured{Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"namespace": "default", "name": name},
+			}})
+		}
+		return true, l, nil
+	})
+
+	var got []string
+	var logs bytes.Buffer
+	rl := &lister{
+		opts:        DiscoveryOptions{ExcludeNameRegex: "^tmp-"},
+		client:      client,
+		keep:        func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		excludeName: regexp.MustCompile("^tmp-"),
+		cb: func(l *unstructured.UnstructuredList) error {
+			for _, o := range l.Items {
+				got = append(got, o.GetName())
+			}
+			return nil
+		},
+		log: slog.New(newLineHandler(&logs)),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"config", "my-tmp-2", "tmp"}, got)
+	require.Equal(t, 3, stat.Count)
+	require.Equal(t, 1, stat.ExcludedByName)
+	require.Contains(t, logs.String(), `/v1, Resource=configmaps: skipped 1 objects with names matching "^tmp-"`)
+}
+
+func Test_lister_MaxObjectBytes(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"})
+	client.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMapList"}}
+		for name, data := range map[string]string{"small": "x", "huge": strings.Repeat("x", 1024)} {
+			l.Items = append(l.Items, unstructured.Unstructured{Object: map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"namespace": "default", "name": name},
+				"data":       map[string]any{"blob": data},
+			}})
+		}
+		return true, l, nil
+	})
+
+	var got []string
+	var logs bytes.Buffer
+	rl := &lister{
+		opts:   DiscoveryOptions{MaxObjectBytes: 512},
+		client: client,
+		keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		cb: func(l *unstructured.UnstructuredList) error {
+			for _, o := range l.Items {
+				got = append(got, o.GetName())
+			}
+			return nil
+		},
+		log: slog.New(newLineHandler(&logs)),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"small"}, got)
+	require.Equal(t, 1, stat.Count)
+	require.Equal(t, 1, stat.ExcludedBySize)
+	require.Contains(t, logs.String(), "skipped default/huge, ")
+	require.Contains(t, logs.String(), "bytes exceed the maximum of 512")
+	require.Contains(t, logs.String(), "/v1, Resource=configmaps: skipped 1 objects larger than 512 bytes")
+}
+
+func Test_lister_DumpRetries(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"},
+		&unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]any{"namespace": "default", "name": "cm"},
+		}})
+
+	for _, tc := range []struct {
+		failures, retries int
+		wantErr           bool
+	}{
+		{failures: 2, retries: 2},
+		{failures: 3, retries: 2, wantErr: true},
+	} {
+		calls := 0
+		var logs bytes.Buffer
+		rl := &lister{
+			opts:   DiscoveryOptions{DumpRetries: tc.retries, RetryBackoff: time.Millisecond},
+			client: client,
+			keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+			cb: func(l *unstructured.UnstructuredList) error {
+				calls++
+				require.Len(t, l.Items, 1, "the same list should be passed again")
+				if calls <= tc.failures {
+					return errors.New("disk full")
+				}
+				return nil
+			},
+			log: slog.New(newLineHandler(&logs)),
+		}
+		stat, errs := rl.run(context.Background(), listJob{res: res})
+		require.Equal(t, min(tc.failures+1, tc.retries+1), calls)
+		require.Contains(t, logs.String(), "retrying dump of /v1, Resource=configmaps in ")
+		if tc.wantErr {
+			require.Len(t, errs, 1)
+			require.ErrorContains(t, errs[0], "disk full")
+			require.True(t, stat.Failed)
+			continue
+		}
+		require.Empty(t, errs)
+		require.Equal(t, 1, stat.Count)
+	}
+}
+
+func Test_lister_Watch(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	cm := func(ns, name, rv string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]any{"namespace": ns, "name": name, "resourceVersion": rv},
+		}}
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"},
+		cm("default", "existing", "1"))
+
+	// The first watch is closed by the server after its events, the second one stays open until the watch duration elapsed.
+	closed := watch.NewFakeWithChanSize(10, false)
+	closed.Add(cm("default", "added", "2"))
+	closed.Modify(cm("default", "existing", "3"))
+	closed.Delete(cm("default", "deleted", "4"))
+	closed.Add(cm("kube-system", "excluded", "5"))
+	closed.Stop()
+	open := watch.NewFakeWithChanSize(10, false)
+	open.Add(cm("default", "late", "6"))
+	var resourceVersions []string
+	client.PrependWatchReactor("configmaps", func(a k8stesting.Action) (bool, watch.Interface, error) {
+		resourceVersions = append(resourceVersions, a.(k8stesting.WatchAction).GetWatchRestrictions().ResourceVersion)
+		if len(resourceVersions) == 1 {
+			return true, closed, nil
+		}
+		return true, open, nil
+	})
+
+	var got []string
+	rl := &lister{
+		opts:   DiscoveryOptions{WatchDuration: 100 * time.Millisecond},
+		client: client,
+		keep: func(_ schema.GroupVersionResource, o unstructured.Unstructured) bool {
+			return o.GetNamespace() != "kube-system"
+		},
+		cb: func(l *unstructured.UnstructuredList) error {
+			require.Equal(t, "ConfigMapList", l.GetKind())
+			for _, o := range l.Items {
+				got = append(got, o.GetName())
+			}
+			return nil
+		},
+		log:        slog.New(newLineHandler(io.Discard)),
+		watchUntil: time.Now().Add(100 * time.Millisecond),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"added", "existing", "late"}, got, "only added and modified objects should be dumped")
+	require.Equal(t, 3, stat.Count)
+	require.Len(t, resourceVersions, 2)
+	require.Equal(t, "5", resourceVersions[1], "the watch should be restarted from the last seen resourceVersion")
+
+	failing := watch.NewFakeWithChanSize(1, false)
+	failing.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusInternalServerError, Reason: metav1.StatusReasonInternalError, Message: "etcd unavailable"})
+	client.PrependWatchReactor("configmaps", func(k8stesting.Action) (bool, watch.Interface, error) {
+		return true, failing, nil
+	})
+	rl.watchUntil = time.Now().Add(time.Minute)
+	stat, errs = rl.run(context.Background(), listJob{res: res})
+	require.Len(t, errs, 1)
+	require.ErrorContains(t, errs[0], "etcd unavailable")
+	require.True(t, stat.Failed)
+}
+
+func Test_lister_WatchExpired(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"})
+	lists := 0
+	client.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "v1", "kind": "ConfigMapList"}}
+		l.SetResourceVersion(strconv.Itoa(lists * 10))
+		return true, l, nil
+	})
+
+	// The first watch expires with an error event, the second one fails to start with 410 Gone, the third one stays open.
+	expired := watch.NewFakeWithChanSize(1, false)
+	expired.Error(&metav1.Status{Status: metav1.StatusFailure, Code: http.StatusGone, Reason: metav1.StatusReasonExpired, Message: "too old resource version"})
+	open := watch.NewFakeWithChanSize(1, false)
+	open.Add(&unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]any{"namespace": "default", "name": "after-expiry", "resourceVersion": "31"},
+	}})
+	var resourceVersions []string
+	client.PrependWatchReactor("configmaps", func(a k8stesting.Action) (bool, watch.Interface, error) {
+		resourceVersions = append(resourceVersions, a.(k8stesting.WatchAction).GetWatchRestrictions().ResourceVersion)
+		switch len(resourceVersions) {
+		case 1:
+			return true, expired, nil
+		case 2:
+			return true, nil, apierrors.NewResourceExpired("too old resource version")
+		}
+		return true, open, nil
+	})
+
+	var got []string
+	var logs bytes.Buffer
+	rl := &lister{
+		opts:   DiscoveryOptions{WatchDuration: 100 * time.Millisecond},
+		client: client,
+		keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		cb: func(l *unstructured.UnstructuredList) error {
+			for _, o := range l.Items {
+				got = append(got, o.GetName())
+			}
+			return nil
+		},
+		log:        slog.New(newLineHandler(&logs)),
+		watchUntil: time.Now().Add(100 * time.Millisecond),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs, "an expired watch should be restarted, not fail")
+	require.False(t, stat.Failed)
+	require.Equal(t, []string{"after-expiry"}, got)
+	require.Equal(t, []string{"10", "20", "30"}, resourceVersions, "the watch should be restarted from the resourceVersion of a new list")
+	require.Contains(t, logs.String(), "warning: /v1, Resource=configmaps: resourceVersion 10 of the watch expired, restarting from resourceVersion 20, changes in between are not dumped")
+}
+
+func Test_lister_ExcludeUIDs(t *testing.T) {
+	res := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	var objs []runtime.Object
+	for _, name := range []string{"seen", "new"} {
+		objs = append(objs, &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]any{"namespace": "default", "name": name, "uid": name + "-uid"},
+		}})
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "ConfigMapList"}, objs...)
+
+	var got []string
+	var logs bytes.Buffer
+	rl := &lister{
+		client:      client,
+		keep:        func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		excludeUIDs: sets.New[types.UID]("seen-uid"),
+		cb: func(l *unstructured.UnstructuredList) error {
+			for _, o := range l.Items {
+				got = append(got, o.GetName())
+			}
+			return nil
+		},
+		log: slog.New(newLineHandler(&logs)),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs)
+	require.Equal(t, []string{"new"}, got)
+	require.Equal(t, 1, stat.Count)
+	require.Equal(t, 1, stat.ExcludedByUID)
+	require.Contains(t, logs.String(), "/v1, Resource=configmaps: skipped 1 objects seen in a previous dump")
+}
+
+func Test_lister_NotFoundWhilePaginating(t *testing.T) {
+	res := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{res: "WidgetList"})
+	var calls int
+	client.PrependReactor("list", "widgets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		calls++
+		if calls > 1 {
+			// The CRD was deleted between the batches.
+			return true, nil, apierrors.NewNotFound(res.GroupResource(), "")
+		}
+		l := &unstructured.UnstructuredList{Object: map[string]any{"apiVersion": "example.com/v1", "kind": "WidgetList"}}
+		l.Items = []unstructured.Unstructured{{Object: map[string]any{"apiVersion": "example.com/v1", "kind": "Widget", "metadata": map[string]any{"name": "w"}}}}
+		l.SetContinue("next")
+		return true, l, nil
+	})
+
+	var batches int
+	var logs bytes.Buffer
+	rl := &lister{
+		client: client,
+		keep:   func(schema.GroupVersionResource, unstructured.Unstructured) bool { return true },
+		cb: func(*unstructured.UnstructuredList) error {
+			batches++
+			return nil
+		},
+		log: slog.New(newLineHandler(&logs)),
+	}
+	stat, errs := rl.run(context.Background(), listJob{res: res})
+	require.Empty(t, errs)
+	require.False(t, stat.Failed)
+	require.Equal(t, 1, stat.Count)
+	require.Equal(t, 1, batches, "the batch listed before should be dumped")
+	require.Contains(t, logs.String(), "was removed while listing, keeping the 1 objects listed before")
+
+	// Without a continue token, NotFound is still an error.
+	calls = 1
+	_, errs = rl.run(context.Background(), listJob{res: res})
+	require.Len(t, errs, 1)
+	require.True(t, apierrors.IsNotFound(errs[0]))
+}
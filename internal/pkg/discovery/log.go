@@ -0,0 +1,55 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// lineHandler is a slog.Handler writing the message of every record on a separate line.
+// The level and the attributes are dropped to keep the output human-readable.
+// It is safe for concurrent use.
+type lineHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func newLineHandler(w io.Writer) *lineHandler {
+	return &lineHandler{mu: new(sync.Mutex), w: w}
+}
+
+// Enabled implements slog.Handler. Debug messages are dropped.
+func (h *lineHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= slog.LevelInfo
+}
+
+// Handle implements slog.Handler.
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, r.Message+"\n")
+	return err
+}
+
+// WithAttrs implements slog.Handler.
+func (h *lineHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+// WithGroup implements slog.Handler.
+func (h *lineHandler) WithGroup(string) slog.Handler { return h }
+
+// gvrAttr returns the log attribute of the resource.
+func gvrAttr(res schema.GroupVersionResource) slog.Attr {
+	return slog.String("gvr", res.String())
+}
+
+// logAttrs returns the log attributes of the resource and namespace of the key.
+func (k checkpointKey) logAttrs() []any {
+	attrs := []any{gvrAttr(schema.GroupVersionResource{Group: k.Group, Version: k.Version, Resource: k.Resource})}
+	if k.Namespace != "" {
+		attrs = append(attrs, slog.String("namespace", k.Namespace))
+	}
+	return attrs
+}
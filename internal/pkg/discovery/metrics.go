@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// resourceLabels are the labels of the per resource metrics.
+var resourceLabels = []string{"group", "version", "resource"}
+
+// Metrics are the prometheus metrics updated during discovery.
+// Must be initialized with NewMetrics.
+// A nil *Metrics disables the metrics.
+type Metrics struct {
+	objectsDumped    *prometheus.CounterVec
+	resourcesSkipped prometheus.Counter
+	errors           prometheus.Counter
+	listing          *prometheus.GaugeVec
+}
+
+// NewMetrics creates the discovery metrics and registers them with reg.
+// The metrics can be shared by multiple calls to DiscoverObjects.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		objectsDumped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "k8s_object_dumper_objects_dumped_total",
+			Help: "Number of objects passed to the dumper.",
+		}, resourceLabels),
+		resourcesSkipped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "k8s_object_dumper_resources_skipped_total",
+			Help: "Number of resources not listed, for example because of filters or missing permissions.",
+		}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "k8s_object_dumper_errors_total",
+			Help: "Number of errors encountered while discovering, listing, or dumping objects.",
+		}),
+		listing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "k8s_object_dumper_listing_resource",
+			Help: "Set to 1 for the resources currently being listed.",
+		}, resourceLabels),
+	}
+	for _, c := range []prometheus.Collector{m.objectsDumped, m.resourcesSkipped, m.errors, m.listing} {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) addObjects(res schema.GroupVersionResource, n int) {
+	if m != nil {
+		m.objectsDumped.WithLabelValues(res.Group, res.Version, res.Resource).Add(float64(n))
+	}
+}
+
+func (m *Metrics) addSkipped(n int) {
+	if m != nil {
+		m.resourcesSkipped.Add(float64(n))
+	}
+}
+
+func (m *Metrics) addErrors(n int) {
+	if m != nil {
+		m.errors.Add(float64(n))
+	}
+}
+
+// startListing marks the resource as being listed. The returned function unmarks it.
+func (m *Metrics) startListing(res schema.GroupVersionResource) func() {
+	if m == nil {
+		return func() {}
+	}
+	m.listing.WithLabelValues(res.Group, res.Version, res.Resource).Set(1)
+	return func() { m.listing.DeleteLabelValues(res.Group, res.Version, res.Resource) }
+}
@@ -0,0 +1,183 @@
+package discovery
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// redactPath is a parsed DiscoveryOptions.RedactPaths expression.
+type redactPath struct {
+	expr string
+	// full selects the values to redact.
+	full *jsonpath.JSONPath
+	// If the expression ends with a field, parent selects the objects containing the field and key is the field name.
+	// Values found in maps cannot be replaced through the results of full.
+	// parent is nil if the parent is the object itself.
+	parent *jsonpath.JSONPath
+	key    string
+	// fieldTerminated is true if the expression ends with a field.
+	fieldTerminated bool
+	// recursive is true if the field is selected by recursive descent, e.g. {..password}.
+	recursive bool
+}
+
+// parseRedactPath parses a JSONPath expression of DiscoveryOptions.RedactPaths.
+func parseRedactPath(expr string) (redactPath, error) {
+	p := redactPath{expr: expr}
+	full, err := parseJSONPath(expr)
+	if err != nil {
+		return p, err
+	}
+	p.full = full
+
+	trimmed := strings.TrimSpace(expr)
+	if !strings.HasPrefix(trimmed, "{") || !strings.HasSuffix(trimmed, "}") {
+		return p, fmt.Errorf("must be a single {...} expression")
+	}
+	inner := trimmed[1 : len(trimmed)-1]
+	i := lastFieldSeparator(inner)
+	if i < 0 {
+		// Not ending with a field, only array elements and maps can be redacted.
+		return p, nil
+	}
+	p.fieldTerminated = true
+	p.key = strings.ReplaceAll(inner[i+1:], `\.`, ".")
+	parent := inner[:i]
+	if strings.HasSuffix(parent, ".") {
+		p.recursive = true
+		parent = strings.TrimSuffix(parent, ".")
+	}
+	if parent != "" {
+		if p.parent, err = parseJSONPath("{" + parent + "}"); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+func parseJSONPath(expr string) (*jsonpath.JSONPath, error) {
+	jp := jsonpath.New("redact").AllowMissingKeys(true)
+	if err := jp.Parse(expr); err != nil {
+		return nil, err
+	}
+	return jp, nil
+}
+
+// lastFieldSeparator returns the index of the dot starting the last segment of the expression if the segment is a plain field name.
+// It returns -1 if the last segment is not a field, for example an array index or a filter.
+func lastFieldSeparator(expr string) int {
+	for i := len(expr) - 1; i >= 0; i-- {
+		switch expr[i] {
+		case '.':
+			if i > 0 && expr[i-1] == '\\' {
+				continue
+			}
+			if i == len(expr)-1 {
+				return -1
+			}
+			return i
+		case '[', ']', '(', ')', '@', '?', '*', '\'', '"', ' ':
+			return -1
+		}
+	}
+	return -1
+}
+
+// redact replaces the values selected by the path with RedactedPlaceholder.
+// If a selected value is a map or an array, all values it contains are redacted.
+func (p redactPath) redact(o *unstructured.Unstructured) error {
+	if p.fieldTerminated {
+		parents := []reflect.Value{reflect.ValueOf(o.Object)}
+		if p.parent != nil {
+			results, err := p.parent.FindResults(o.Object)
+			if err != nil {
+				return fmt.Errorf("failed to evaluate redact path %q: %w", p.expr, err)
+			}
+			parents = flattenResults(results)
+		}
+		for _, parent := range parents {
+			p.redactField(indirect(parent).Interface())
+		}
+		return nil
+	}
+
+	results, err := p.full.FindResults(o.Object)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate redact path %q: %w", p.expr, err)
+	}
+	for _, v := range flattenResults(results) {
+		switch {
+		case v.CanSet():
+			v.Set(reflect.ValueOf(redactValue(v.Interface())))
+		case indirect(v).Kind() == reflect.Map || indirect(v).Kind() == reflect.Slice:
+			redactValue(indirect(v).Interface())
+		default:
+			return fmt.Errorf("cannot redact the value selected by redact path %q, end the path with a field name instead", p.expr)
+		}
+	}
+	return nil
+}
+
+// redactField redacts the field of the parent if it is a map.
+// With recursive descent the field is redacted in all maps in the parent, too.
+func (p redactPath) redactField(parent any) {
+	switch parent := parent.(type) {
+	case map[string]any:
+		if p.recursive {
+			for k, v := range parent {
+				if k != p.key {
+					p.redactField(v)
+				}
+			}
+		}
+		if v, ok := parent[p.key]; ok {
+			parent[p.key] = redactValue(v)
+		}
+	case []any:
+		if p.recursive {
+			for _, v := range parent {
+				p.redactField(v)
+			}
+		}
+	}
+}
+
+func flattenResults(results [][]reflect.Value) []reflect.Value {
+	var vs []reflect.Value
+	for _, r := range results {
+		vs = append(vs, r...)
+	}
+	return vs
+}
+
+// indirect returns the value held by an interface.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// redactValue returns RedactedPlaceholder for non-nil scalar values.
+// Maps and arrays are redacted in place and returned.
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		for k, e := range v {
+			v[k] = redactValue(e)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+		return v
+	}
+	return RedactedPlaceholder
+}
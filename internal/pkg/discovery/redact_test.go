@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func Test_redactPath(t *testing.T) {
+	newObj := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"kind": "Database",
+			"spec": map[string]any{
+				"password": "hunter2",
+				"users": []any{
+					map[string]any{"name": "admin", "password": "admin"},
+					map[string]any{"name": "guest", "password": "guest"},
+				},
+				"tokens":      []any{"a", "b"},
+				"credentials": map[string]any{"key": "k", "nested": map[string]any{"cert": "c"}},
+			},
+			"metadata": map[string]any{"annotations": map[string]any{"example.com/secret": "s"}},
+		}}
+	}
+
+	for _, tc := range []struct {
+		path     string
+		field    []string
+		expected any
+	}{
+		{path: "{.spec.password}", field: []string{"spec", "password"}, expected: RedactedPlaceholder},
+		{path: "{.spec.users[*].password}", field: []string{"spec", "users"}, expected: []any{
+			map[string]any{"name": "admin", "password": RedactedPlaceholder},
+			map[string]any{"name": "guest", "password": RedactedPlaceholder},
+		}},
+		{path: `{.spec.users[?(@.name=="guest")].password}`, field: []string{"spec", "users"}, expected: []any{
+			map[string]any{"name": "admin", "password": "admin"},
+			map[string]any{"name": "guest", "password": RedactedPlaceholder},
+		}},
+		{path: "{..password}", field: []string{"spec"}, expected: map[string]any{
+			"password": RedactedPlaceholder,
+			"users": []any{
+				map[string]any{"name": "admin", "password": RedactedPlaceholder},
+				map[string]any{"name": "guest", "password": RedactedPlaceholder},
+			},
+			"tokens":      []any{"a", "b"},
+			"credentials": map[string]any{"key": "k", "nested": map[string]any{"cert": "c"}},
+		}},
+		{path: "{.spec.tokens[0]}", field: []string{"spec", "tokens"}, expected: []any{RedactedPlaceholder, "b"}},
+		{path: "{.spec.credentials}", field: []string{"spec", "credentials"}, expected: map[string]any{
+			"key": RedactedPlaceholder, "nested": map[string]any{"cert": RedactedPlaceholder},
+		}},
+		{path: `{.metadata.annotations.example\.com/secret}`, field: []string{"metadata", "annotations", "example.com/secret"}, expected: RedactedPlaceholder},
+		{path: "{.spec.missing}", field: []string{"spec", "password"}, expected: "hunter2"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			p, err := parseRedactPath(tc.path)
+			require.NoError(t, err)
+			o := newObj()
+			require.NoError(t, p.redact(o))
+			v, found, err := unstructured.NestedFieldNoCopy(o.Object, tc.field...)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, tc.expected, v)
+			require.Equal(t, "Database", o.GetKind(), "other fields should be kept")
+		})
+	}
+}
+
+func Test_parseRedactPath_Invalid(t *testing.T) {
+	for _, path := range []string{"{.spec.password", "spec.password", "{.spec[?(@.name==}"} {
+		_, err := parseRedactPath(path)
+		require.Error(t, err, path)
+	}
+}
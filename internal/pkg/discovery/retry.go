@@ -0,0 +1,93 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+)
+
+// listWithRetry lists the resource and retries transient errors with exponential backoff.
+// The number of retries is limited by opts.MaxRetries.
+// If ctx has a deadline, the API server is asked to stop the list call at the deadline.
+// A span is started for the list call, including the retries.
+func (rl *lister) listWithRetry(ctx context.Context, ri dynamic.ResourceInterface, res schema.GroupVersionResource, ns string, listOpts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	ctx, span := tracer().Start(ctx, "List", trace.WithAttributes(resourceAttributes(res)...))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("k8s.namespace", ns),
+		attribute.Bool("k8s.continue", listOpts.Continue != ""),
+	)
+
+	backoff := rl.opts.GetRetryBackoff()
+	for attempt := 1; ; attempt++ {
+		if dl, ok := ctx.Deadline(); ok {
+			secs := max(int64(math.Ceil(time.Until(dl).Seconds())), 1)
+			listOpts.TimeoutSeconds = &secs
+		}
+		l, err := ri.List(ctx, listOpts)
+		if err == nil || attempt > rl.opts.MaxRetries || !isRetryable(err) {
+			span.SetAttributes(attribute.Int("k8s.attempts", attempt))
+			if err != nil {
+				recordErrors(span, err)
+				return l, err
+			}
+			span.SetAttributes(
+				attribute.Int("k8s.items", len(l.Items)),
+				attribute.Bool("k8s.has_continue", l.GetContinue() != ""),
+			)
+			return l, nil
+		}
+
+		d := wait.Jitter(backoff, 0.5)
+		rl.log.Warn(fmt.Sprintf("retrying %s in %s (attempt %d/%d): %v", res, d.Round(time.Millisecond), attempt, rl.opts.MaxRetries, err),
+			append(newCheckpointKey(res, ns).logAttrs(), "attempt", attempt, "backoff", d, "error", err)...)
+		select {
+		case <-ctx.Done():
+			recordErrors(span, ctx.Err())
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+		backoff *= 2
+	}
+}
+
+// dumpWithRetry passes the list to the callback and retries with exponential backoff if it returns an error.
+// The number of retries is limited by opts.DumpRetries. The last error is returned if all attempts fail.
+func (rl *lister) dumpWithRetry(ctx context.Context, res schema.GroupVersionResource, ns string, l *unstructured.UnstructuredList) error {
+	backoff := rl.opts.GetRetryBackoff()
+	for attempt := 1; ; attempt++ {
+		err := rl.cb(l)
+		if err == nil || attempt > rl.opts.DumpRetries {
+			return err
+		}
+
+		d := wait.Jitter(backoff, 0.5)
+		rl.log.Warn(fmt.Sprintf("retrying dump of %s in %s (attempt %d/%d): %v", res, d.Round(time.Millisecond), attempt, rl.opts.DumpRetries, err),
+			append(newCheckpointKey(res, ns).logAttrs(), "attempt", attempt, "backoff", d, "error", err)...)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+		backoff *= 2
+	}
+}
+
+// isRetryable returns true if the error is transient and the request should be retried.
+func isRetryable(err error) bool {
+	return apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsInternalError(err) ||
+		utilnet.IsConnectionReset(err)
+}
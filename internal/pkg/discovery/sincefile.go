@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// sinceFile tracks the newest creation timestamp of the dumped objects and persists it to a file.
+// All methods are safe for concurrent use and no-ops on a nil sinceFile.
+type sinceFile struct {
+	path string
+	// since is the timestamp loaded from the file. Zero if the file does not exist.
+	since time.Time
+
+	mu sync.Mutex
+	// newest is the newest creation timestamp of the objects dumped by the current dump.
+	newest time.Time
+}
+
+// loadSinceFile loads the timestamp from the given path.
+// If the file does not exist, the timestamp is zero.
+// If the path is empty, nil is returned.
+func loadSinceFile(path string) (*sinceFile, error) {
+	if path == "" {
+		return nil, nil
+	}
+	sf := &sinceFile{path: path}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sf, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read since file %q: %w", path, err)
+	}
+	if s := strings.TrimSpace(string(raw)); s != "" {
+		if sf.since, err = time.Parse(time.RFC3339, s); err != nil {
+			return nil, fmt.Errorf("failed to parse since file %q: %w", path, err)
+		}
+	}
+	sf.newest = sf.since
+	return sf, nil
+}
+
+// observe records the creation timestamps of the dumped objects.
+// Objects with a missing or unparseable creation timestamp are ignored.
+func (sf *sinceFile) observe(items []unstructured.Unstructured) {
+	if sf == nil {
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, o := range items {
+		raw, _, _ := unstructured.NestedString(o.Object, "metadata", "creationTimestamp")
+		if created, err := time.Parse(time.RFC3339, raw); err == nil && created.After(sf.newest) {
+			sf.newest = created
+		}
+	}
+}
+
+// write persists the newest observed creation timestamp.
+// The file is not written if no object with a creation timestamp was dumped and no timestamp was loaded.
+func (sf *sinceFile) write() error {
+	if sf == nil {
+		return nil
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.newest.IsZero() {
+		return nil
+	}
+	return writeFileAtomic(sf.path, []byte(sf.newest.UTC().Format(time.RFC3339)+"\n"))
+}
@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DiscoverObjectsWithClients_SinceFile(t *testing.T) {
+	c := newFakeCluster(t,
+		fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true},
+	)
+	for name, created := range map[string]time.Time{
+		"old": time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		"new": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		o := newFakeObject("v1", "ConfigMap", "default", name)
+		o.SetCreationTimestamp(metav1.NewTime(created))
+		c.addObjects(fakeConfigMapsGVR, o)
+	}
+
+	path := filepath.Join(t.TempDir(), "since")
+	opts := DiscoveryOptions{SinceFile: path}
+
+	objs, _, err := c.discover(opts)
+	require.NoError(t, err)
+	slices.Sort(objs["configmap"])
+	require.Equal(t, []string{"default/new", "default/old"}, objs["configmap"], "all objects should be dumped without a since file")
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "2024-01-01T00:00:00Z\n", string(raw))
+
+	newer := newFakeObject("v1", "ConfigMap", "default", "newer")
+	newer.SetCreationTimestamp(metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)))
+	c.addObjects(fakeConfigMapsGVR, newer)
+	failing := true
+	c.dynamic.PrependReactor("list", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, apierrors.NewInternalError(os.ErrClosed)
+		}
+		return false, nil, nil
+	})
+
+	_, _, err = c.discover(opts)
+	require.Error(t, err)
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "2024-01-01T00:00:00Z\n", string(raw), "the since file should not be written after a failed dump")
+
+	failing = false
+	objs, _, err = c.discover(opts)
+	require.NoError(t, err)
+	slices.Sort(objs["configmap"])
+	require.Equal(t, []string{"default/new", "default/newer"}, objs["configmap"], "objects created before the timestamp should be skipped")
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "2025-01-01T00:00:00Z\n", string(raw))
+}
+
+func Test_loadSinceFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "since")
+	require.NoError(t, os.WriteFile(path, []byte("yesterday\n"), 0o644))
+
+	_, err := loadSinceFile(path)
+	require.ErrorContains(t, err, "failed to parse since file")
+}
@@ -0,0 +1,37 @@
+package discovery
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// tracerName is the name of the tracer creating the spans of the discovery.
+const tracerName = "github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+
+// tracer returns the tracer of the global tracer provider.
+// It is a no-op if no tracer provider is configured.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// resourceAttributes returns the span attributes identifying the resource.
+func resourceAttributes(res schema.GroupVersionResource) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("k8s.group", res.Group),
+		attribute.String("k8s.version", res.Version),
+		attribute.String("k8s.resource", res.Resource),
+	}
+}
+
+// recordErrors records the errors on the span and marks it as failed if there are any.
+func recordErrors(span trace.Span, errs ...error) {
+	for _, err := range errs {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RedactedPlaceholder replaces redacted values.
+const RedactedPlaceholder = "REDACTED"
+
+var secretGK = schema.GroupKind{Kind: "Secret"}
+
+// volatileMetadataFields are removed by DiscoveryOptions.StableOutput.
+var volatileMetadataFields = []string{"resourceVersion", "uid", "generation", "creationTimestamp", "managedFields"}
+
+// TransformFunc modifies an object in place before it is passed to the callback.
+type TransformFunc func(*unstructured.Unstructured) error
+
+// transforms returns the transformations enabled by the options in the order they are applied.
+// DiscoveryOptions.Transform runs last.
+// An error is returned if a redact path is invalid.
+func (opts DiscoveryOptions) transforms() ([]TransformFunc, error) {
+	var ts []TransformFunc
+	if opts.StripManagedFields {
+		ts = append(ts, stripManagedFields)
+	}
+	if opts.StableOutput {
+		ts = append(ts, stripVolatileMetadata)
+	}
+	if opts.StripStatus {
+		ts = append(ts, stripStatus)
+	}
+	if !opts.IncludeSecretData {
+		ts = append(ts, redactSecretData)
+	}
+	for _, expr := range opts.RedactPaths {
+		p, err := parseRedactPath(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact path %q: %w", expr, err)
+		}
+		ts = append(ts, p.redact)
+	}
+	if opts.Transform != nil {
+		ts = append(ts, opts.Transform)
+	}
+	return ts, nil
+}
+
+func stripManagedFields(o *unstructured.Unstructured) error {
+	unstructured.RemoveNestedField(o.Object, "metadata", "managedFields")
+	return nil
+}
+
+func stripVolatileMetadata(o *unstructured.Unstructured) error {
+	for _, f := range volatileMetadataFields {
+		unstructured.RemoveNestedField(o.Object, "metadata", f)
+	}
+	return nil
+}
+
+func stripStatus(o *unstructured.Unstructured) error {
+	delete(o.Object, "status")
+	return nil
+}
+
+// redactSecretData replaces the values of the data and stringData fields of core Secrets with RedactedPlaceholder.
+func redactSecretData(o *unstructured.Unstructured) error {
+	if o.GroupVersionKind().GroupKind() != secretGK {
+		return nil
+	}
+	for _, field := range []string{"data", "stringData"} {
+		m, ok := o.Object[field].(map[string]any)
+		if !ok {
+			continue
+		}
+		for k := range m {
+			m[k] = RedactedPlaceholder
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package discovery
+
+import "net/http"
+
+// headerRoundTripper sets extra headers on every request before passing it to the wrapped round tripper.
+type headerRoundTripper struct {
+	headers http.Header
+	rt      http.RoundTripper
+}
+
+// newHeaderRoundTripper returns a round tripper setting the headers on every request.
+// The headers are copied, later changes to them have no effect.
+func newHeaderRoundTripper(headers http.Header, rt http.RoundTripper) http.RoundTripper {
+	return &headerRoundTripper{headers: headers.Clone(), rt: rt}
+}
+
+// RoundTrip implements http.RoundTripper.
+// The request is cloned since a round tripper must not modify it.
+func (h *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range h.headers {
+		req.Header[http.CanonicalHeaderKey(k)] = v
+	}
+	return h.rt.RoundTrip(req)
+}
+
+// WrappedRoundTripper returns the wrapped round tripper.
+// Used by client-go to find the underlying transport.
+func (h *headerRoundTripper) WrappedRoundTripper() http.RoundTripper {
+	return h.rt
+}
@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+func Test_restConfig_UserAgentAndHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var requests []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Header.Clone())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/version" {
+			w.Write([]byte(`{"gitVersion":"v1.31.0"}`))
+			return
+		}
+		w.Write([]byte(`{"apiVersion":"v1","kind":"ConfigMapList","metadata":{},"items":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := DiscoveryOptions{
+		UserAgent: "k8s-object-dumper/test",
+		Headers:   http.Header{"x-route": {"dumps"}},
+	}
+	conf := opts.restConfig(&rest.Config{Host: srv.URL})
+
+	dc, err := discovery.NewDiscoveryClientForConfig(conf)
+	require.NoError(t, err)
+	_, err = dc.ServerVersion()
+	require.NoError(t, err)
+	dyn, err := dynamic.NewForConfig(conf)
+	require.NoError(t, err)
+	_, err = dyn.Resource(schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+
+	require.Len(t, requests, 2)
+	for _, h := range requests {
+		require.Equal(t, "k8s-object-dumper/test", h.Get("User-Agent"))
+		require.Equal(t, "dumps", h.Get("X-Route"))
+	}
+}
@@ -0,0 +1,149 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// watchResource watches the given resource until rl.watchUntil and calls the callback for every added or modified object.
+// If ns is not empty, only objects in the given namespace are watched.
+// The watch starts at the current resourceVersion of the resource, objects existing before are not dumped.
+// Deleted objects are ignored. Objects are filtered and transformed like listed objects.
+// If the API server closes the watch early, it is restarted from the last seen resourceVersion.
+// If the resourceVersion expired, the resource is listed again and the watch is restarted from the current resourceVersion,
+// like the reflector of client-go. Changes between the expired and the current resourceVersion are not dumped, a warning is logged.
+// Errors are returned and do not stop the watches of other resources.
+func (rl *lister) watchResource(ctx context.Context, res schema.GroupVersionResource, ns string, stat *ResourceStat) []error {
+	var ri dynamic.ResourceInterface = rl.client.Resource(res)
+	if ns != "" {
+		ri = rl.client.Resource(res).Namespace(ns)
+	}
+	key := newCheckpointKey(res, ns)
+
+	l, err := rl.currentResourceVersion(ctx, ri, res, ns)
+	if rl.skipOnError(err, key, stat) {
+		return nil
+	}
+	if err != nil {
+		return []error{fmt.Errorf("failed to list %s: %w", res, err)}
+	}
+	header := map[string]any{"apiVersion": l.GetAPIVersion(), "kind": l.GetKind()}
+
+	watchCtx, cancel := context.WithDeadline(ctx, rl.watchUntil)
+	defer cancel()
+	watchOpts := rl.listOpts
+	watchOpts.Limit = 0
+	watchOpts.ResourceVersion = l.GetResourceVersion()
+	watchOpts.AllowWatchBookmarks = true
+
+	var errors []error
+	for {
+		secs := max(int64(math.Ceil(time.Until(rl.watchUntil).Seconds())), 1)
+		watchOpts.TimeoutSeconds = &secs
+		w, err := ri.Watch(watchCtx, watchOpts)
+		if watchCtx.Err() != nil {
+			return rl.watchEnded(ctx, key, errors)
+		}
+		expired := isWatchExpired(err)
+		switch {
+		case expired:
+		case rl.skipOnError(err, key, stat):
+			return errors
+		case err != nil:
+			return append(errors, fmt.Errorf("failed to watch %s: %w", res, err))
+		default:
+			var rv string
+			var errs []error
+			var done bool
+			rv, errs, done, expired = rl.consumeWatch(watchCtx, w, res, ns, header, stat)
+			w.Stop()
+			errors = append(errors, errs...)
+			if done || watchCtx.Err() != nil {
+				return rl.watchEnded(ctx, key, errors)
+			}
+			if rv != "" {
+				watchOpts.ResourceVersion = rv
+			}
+		}
+		if !expired {
+			rl.log.Debug(fmt.Sprintf("%s: watch closed by the API server, restarting from resourceVersion %s", key, watchOpts.ResourceVersion),
+				append(key.logAttrs(), "resource_version", watchOpts.ResourceVersion)...)
+			continue
+		}
+
+		l, err := rl.currentResourceVersion(watchCtx, ri, res, ns)
+		if watchCtx.Err() != nil {
+			return rl.watchEnded(ctx, key, errors)
+		}
+		if err != nil {
+			return append(errors, fmt.Errorf("failed to list %s after its watch expired: %w", res, err))
+		}
+		rl.log.Warn(fmt.Sprintf("warning: %s: resourceVersion %s of the watch expired, restarting from resourceVersion %s, changes in between are not dumped", key, watchOpts.ResourceVersion, l.GetResourceVersion()),
+			append(key.logAttrs(), "expired_resource_version", watchOpts.ResourceVersion, "resource_version", l.GetResourceVersion())...)
+		watchOpts.ResourceVersion = l.GetResourceVersion()
+	}
+}
+
+// consumeWatch dumps the added and modified objects of the watch until it is closed or ctx is done.
+// It returns the last seen resourceVersion. done is true if the watch must not be restarted, for example after an error event.
+// expired is true if the watch ended with an error event because its resourceVersion expired.
+func (rl *lister) consumeWatch(ctx context.Context, w watch.Interface, res schema.GroupVersionResource, ns string, header map[string]any, stat *ResourceStat) (rv string, errors []error, done, expired bool) {
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return rv, errors, true, false
+		case e, ok = <-w.ResultChan():
+		}
+		if !ok {
+			return rv, errors, false, false
+		}
+		if e.Type == watch.Error {
+			err := apierrors.FromObject(e.Object)
+			if isWatchExpired(err) {
+				return rv, errors, false, true
+			}
+			return rv, append(errors, fmt.Errorf("failed to watch %s: %w", res, err)), true, false
+		}
+		o, isUnstructured := e.Object.(*unstructured.Unstructured)
+		if !isUnstructured {
+			return rv, append(errors, fmt.Errorf("failed to watch %s: unexpected object %T", res, e.Object)), true, false
+		}
+		rv = o.GetResourceVersion()
+		if e.Type != watch.Added && e.Type != watch.Modified {
+			continue
+		}
+		l := &unstructured.UnstructuredList{Object: header, Items: []unstructured.Unstructured{*o}}
+		errs, _ := rl.dumpBatch(ctx, res, ns, l, stat)
+		errors = append(errors, errs...)
+	}
+}
+
+// currentResourceVersion lists a single object of the resource, the list has the current resourceVersion to start a watch from.
+func (rl *lister) currentResourceVersion(ctx context.Context, ri dynamic.ResourceInterface, res schema.GroupVersionResource, ns string) (*unstructured.UnstructuredList, error) {
+	listOpts := rl.listOpts
+	listOpts.Limit = 1
+	return rl.listWithRetry(ctx, ri, res, ns, listOpts)
+}
+
+// isWatchExpired returns true if the error is returned by the API server because the resourceVersion of the watch is too old.
+func isWatchExpired(err error) bool {
+	return apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+}
+
+// watchEnded returns the errors of the watch and an error if ctx was cancelled before the watch duration elapsed.
+func (rl *lister) watchEnded(ctx context.Context, key checkpointKey, errors []error) []error {
+	if err := ctx.Err(); err != nil {
+		return append(errors, fmt.Errorf("watching %s interrupted: %w", key, err))
+	}
+	return errors
+}
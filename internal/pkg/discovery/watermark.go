@@ -0,0 +1,153 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// watermarks tracks the highest resourceVersion of every resource and persists it to a file.
+// All methods are safe for concurrent use and no-ops on a nil watermarks.
+type watermarks struct {
+	path string
+
+	mu sync.Mutex
+	// previous are the watermarks loaded from the file. Objects with a lower or equal resourceVersion are skipped.
+	previous map[schema.GroupVersionResource]uint64
+	// observed are the highest resourceVersions of the current dump.
+	observed map[schema.GroupVersionResource]uint64
+}
+
+// watermarkFile is the persisted form of the watermarks.
+type watermarkFile struct {
+	Resources []watermarkEntry `json:"resources"`
+}
+
+type watermarkEntry struct {
+	Group           string `json:"group,omitempty"`
+	Version         string `json:"version"`
+	Resource        string `json:"resource"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+// loadWatermarks loads the watermarks from the given path.
+// If the file does not exist, empty watermarks are returned.
+// If the path is empty, nil is returned.
+func loadWatermarks(path string) (*watermarks, error) {
+	if path == "" {
+		return nil, nil
+	}
+	wm := &watermarks{
+		path:     path,
+		previous: map[schema.GroupVersionResource]uint64{},
+		observed: map[schema.GroupVersionResource]uint64{},
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return wm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read watermarks %q: %w", path, err)
+	}
+	var f watermarkFile
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse watermarks %q: %w", path, err)
+	}
+	for _, e := range f.Resources {
+		rv, err := strconv.ParseUint(e.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse watermarks %q: invalid resourceVersion %q: %w", path, e.ResourceVersion, err)
+		}
+		res := schema.GroupVersionResource{Group: e.Group, Version: e.Version, Resource: e.Resource}
+		wm.previous[res] = rv
+		wm.observed[res] = rv
+	}
+	return wm, nil
+}
+
+// changed records the resourceVersion of the object and returns true if it is newer than the watermark of the resource.
+// Objects with a missing or non-numeric resourceVersion are always considered changed.
+func (wm *watermarks) changed(res schema.GroupVersionResource, o unstructured.Unstructured) bool {
+	if wm == nil {
+		return true
+	}
+	rv, err := strconv.ParseUint(o.GetResourceVersion(), 10, 64)
+	if err != nil {
+		return true
+	}
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if rv > wm.observed[res] {
+		wm.observed[res] = rv
+	}
+	return rv > wm.previous[res]
+}
+
+// reset restores the watermark of the resource to the loaded value.
+// Called if the resource failed to list, so the next dump emits the objects again.
+func (wm *watermarks) reset(res schema.GroupVersionResource) {
+	if wm == nil {
+		return
+	}
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	if rv, ok := wm.previous[res]; ok {
+		wm.observed[res] = rv
+	} else {
+		delete(wm.observed, res)
+	}
+}
+
+// write atomically persists the observed watermarks by writing to a temporary file and renaming it.
+func (wm *watermarks) write() error {
+	if wm == nil {
+		return nil
+	}
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	f := watermarkFile{Resources: []watermarkEntry{}}
+	for res, rv := range wm.observed {
+		f.Resources = append(f.Resources, watermarkEntry{Group: res.Group, Version: res.Version, Resource: res.Resource, ResourceVersion: strconv.FormatUint(rv, 10)})
+	}
+	slices.SortFunc(f.Resources, func(a, b watermarkEntry) int {
+		return strings.Compare(a.Group+"/"+a.Version+"/"+a.Resource, b.Group+"/"+b.Version+"/"+b.Resource)
+	})
+	raw, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(wm.path, raw)
+}
+
+// writeFileAtomic writes the data to a temporary file in the directory of path and renames it to path.
+// Readers never see a partially written file.
+func writeFileAtomic(path string, raw []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
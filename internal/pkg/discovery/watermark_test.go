@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_DiscoverObjectsWithClients_Watermark(t *testing.T) {
+	c := newFakeCluster(t,
+		fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true},
+		fakeResource{gvr: fakeRolesGVR, kind: "Role", namespaced: true},
+	)
+	for name, rv := range map[string]string{"old": "10", "new": "20", "no-rv": ""} {
+		o := newFakeObject("v1", "ConfigMap", "default", name)
+		o.SetResourceVersion(rv)
+		c.addObjects(fakeConfigMapsGVR, o)
+	}
+	role := newFakeObject("rbac.authorization.k8s.io/v1", "Role", "default", "role")
+	role.SetResourceVersion("15")
+	c.addObjects(fakeRolesGVR, role)
+
+	path := filepath.Join(t.TempDir(), "watermarks.json")
+	opts := DiscoveryOptions{WatermarkFile: path}
+
+	objs, _, err := c.discover(opts)
+	require.NoError(t, err)
+	slices.Sort(objs["configmap"])
+	require.Equal(t, []string{"default/new", "default/no-rv", "default/old"}, objs["configmap"], "all objects should be dumped without a watermark")
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"resources":[
+		{"version":"v1","resource":"configmaps","resourceVersion":"20"},
+		{"group":"rbac.authorization.k8s.io","version":"v1","resource":"roles","resourceVersion":"15"}
+	]}`, string(raw))
+
+	updated := newFakeObject("v1", "ConfigMap", "default", "old")
+	updated.SetResourceVersion("30")
+	require.NoError(t, c.dynamic.Tracker().Update(fakeConfigMapsGVR, updated, "default"))
+	c.dynamic.PrependReactor("list", "roles", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewInternalError(os.ErrClosed)
+	})
+
+	objs, stats, err := c.discover(opts)
+	require.Error(t, err)
+	require.Equal(t, map[string][]string{"configmap": {"default/no-rv", "default/old"}}, objs, "only changed objects should be dumped")
+	i := slices.IndexFunc(stats, func(s ResourceStat) bool { return s.Resource == fakeConfigMapsGVR })
+	require.Equal(t, 1, stats[i].ExcludedByWatermark)
+
+	wm, err := loadWatermarks(path)
+	require.NoError(t, err)
+	require.Equal(t, map[schema.GroupVersionResource]uint64{
+		fakeConfigMapsGVR: 30,
+		fakeRolesGVR:      15,
+	}, wm.previous, "the watermark of the failed resource should be kept")
+}
+
+func Test_DiscoverObjectsWithClients_Watermark_MaxObjects(t *testing.T) {
+	c := newFakeCluster(t, fakeResource{gvr: fakeConfigMapsGVR, kind: "ConfigMap", namespaced: true})
+	// The resourceVersions are not in list order, a truncated list may skip lower resourceVersions.
+	for name, rv := range map[string]string{"a": "20", "b": "10", "c": "30"} {
+		o := newFakeObject("v1", "ConfigMap", "default", name)
+		o.SetResourceVersion(rv)
+		c.addObjects(fakeConfigMapsGVR, o)
+	}
+	path := filepath.Join(t.TempDir(), "watermarks.json")
+
+	objs, _, err := c.discover(DiscoveryOptions{WatermarkFile: path, MaxObjects: 1})
+	require.ErrorIs(t, err, ErrMaxObjectsReached)
+	require.Len(t, objs["configmap"], 1)
+	wm, err := loadWatermarks(path)
+	require.NoError(t, err)
+	require.Empty(t, wm.previous, "the watermark of the truncated resource should not be raised")
+
+	objs, _, err = c.discover(DiscoveryOptions{WatermarkFile: path})
+	require.NoError(t, err)
+	slices.Sort(objs["configmap"])
+	require.Equal(t, []string{"default/a", "default/b", "default/c"}, objs["configmap"], "the objects of the truncated resource should be dumped again")
+	wm, err = loadWatermarks(path)
+	require.NoError(t, err)
+	require.Equal(t, map[schema.GroupVersionResource]uint64{fakeConfigMapsGVR: 30}, wm.previous)
+}
+
+func Test_loadWatermarks_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "watermarks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"resources":[{"version":"v1","resource":"configmaps","resourceVersion":"abc"}]}`), 0o644))
+
+	_, err := loadWatermarks(path)
+	require.ErrorContains(t, err, `invalid resourceVersion "abc"`)
+}
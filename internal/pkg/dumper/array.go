@@ -0,0 +1,90 @@
+package dumper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// JSONArrayDumper writes all dumped objects as a single JSON array.
+// The array is opened on the first write and closed on Close. An empty dump results in [].
+// Must be initialized with DumpToWriterJSONArray.
+// Must be closed after use.
+// It is safe for concurrent use.
+type JSONArrayDumper struct {
+	mu sync.Mutex
+	w  io.Writer
+	// started is true once the opening bracket is written.
+	started bool
+	// written is true once an object is written, every further object is preceded by a comma.
+	written bool
+}
+
+// DumpToWriterJSONArray creates a JSONArrayDumper writing to the given writer.
+func DumpToWriterJSONArray(w io.Writer) *JSONArrayDumper {
+	return &JSONArrayDumper{w: w}
+}
+
+// Dump writes the objects in the list as elements of the array.
+// Every object is written on a separate line.
+func (d *JSONArrayDumper) Dump(l *unstructured.UnstructuredList) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.start(); err != nil {
+		return err
+	}
+	for i := range l.Items {
+		b, err := json.Marshal(l.Items[i].Object)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %w", err)
+		}
+		sep := "\n"
+		if d.written {
+			sep = ",\n"
+		}
+		if _, err := io.WriteString(d.w, sep); err != nil {
+			return fmt.Errorf("failed to write separator: %w", err)
+		}
+		if _, err := d.w.Write(b); err != nil {
+			return fmt.Errorf("failed to write object: %w", err)
+		}
+		d.written = true
+	}
+	return nil
+}
+
+// Close writes the closing bracket of the array.
+// The underlying writer is not closed.
+// The JSONArrayDumper cannot be used after it is closed.
+func (d *JSONArrayDumper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.start(); err != nil {
+		return err
+	}
+	end := "]\n"
+	if d.written {
+		end = "\n]\n"
+	}
+	if _, err := io.WriteString(d.w, end); err != nil {
+		return fmt.Errorf("failed to close array: %w", err)
+	}
+	return nil
+}
+
+// start writes the opening bracket if not yet written.
+func (d *JSONArrayDumper) start() error {
+	if d.started {
+		return nil
+	}
+	if _, err := io.WriteString(d.w, "["); err != nil {
+		return fmt.Errorf("failed to open array: %w", err)
+	}
+	d.started = true
+	return nil
+}
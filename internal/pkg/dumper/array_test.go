@@ -0,0 +1,54 @@
+package dumper_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_JSONArrayDumper(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpToWriterJSONArray(&b)
+
+	for _, names := range [][]string{{"test-pod", "test-pod-2"}, {}, {"test-pod-3"}} {
+		l := &unstructured.UnstructuredList{}
+		for _, name := range names {
+			l.Items = append(l.Items, unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      name,
+						"namespace": "test-ns",
+					},
+				},
+			})
+		}
+		require.NoError(t, subject.Dump(l))
+	}
+	require.NoError(t, subject.Close())
+
+	var got []unstructured.Unstructured
+	require.NoError(t, json.Unmarshal(b.Bytes(), &got), "output should be a valid JSON array: %s", b.String())
+	names := make([]string, 0, len(got))
+	for _, o := range got {
+		names = append(names, o.GetName())
+	}
+	require.Equal(t, []string{"test-pod", "test-pod-2", "test-pod-3"}, names)
+}
+
+func Test_JSONArrayDumper_Empty(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpToWriterJSONArray(&b)
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{}))
+	require.NoError(t, subject.Close())
+
+	require.Equal(t, "[]\n", b.String())
+}
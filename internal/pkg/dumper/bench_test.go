@@ -0,0 +1,173 @@
+package dumper_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+// benchmarkList returns a list of n ConfigMaps with some data.
+func benchmarkList(n int) *unstructured.UnstructuredList {
+	l := &unstructured.UnstructuredList{Object: map[string]interface{}{"kind": "ConfigMapList", "apiVersion": "v1"}}
+	for i := range n {
+		l.Items = append(l.Items, unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       "ConfigMap",
+				"apiVersion": "v1",
+				"metadata": map[string]interface{}{
+					"name":      fmt.Sprintf("test-cm-%d", i),
+					"namespace": "test-ns",
+				},
+				"data": map[string]interface{}{
+					"config.yaml": "key: value\nother: value\n",
+				},
+			},
+		})
+	}
+	return l
+}
+
+// benchmarkDumper runs the dumper created by newDumper with lists of the given size.
+func benchmarkDumper(b *testing.B, n int, newDumper func(tb testing.TB) dumper.DumperFunc) {
+	l := benchmarkList(n)
+	df := newDumper(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := df(l); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+var benchmarkDumpers = map[string]func(tb testing.TB) dumper.DumperFunc{
+	"DumpToWriter": func(testing.TB) dumper.DumperFunc {
+		return dumper.DumpToWriter(io.Discard)
+	},
+	"DumpToWriterYAML": func(testing.TB) dumper.DumperFunc {
+		return dumper.DumpToWriterYAML(io.Discard)
+	},
+	"TarDumper": func(testing.TB) dumper.DumperFunc {
+		d := dumper.NewTarDumper(io.Discard, dumper.TarDumperOptions{})
+		return d.Dump
+	},
+	"DirDumper": func(tb testing.TB) dumper.DumperFunc {
+		d, err := dumper.NewDirDumper(tb.TempDir(), dumper.DirDumperOptions{})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { d.Close() })
+		return d.Dump
+	},
+}
+
+func BenchmarkDumpers(b *testing.B) {
+	for name, newDumper := range benchmarkDumpers {
+		for _, n := range []int{10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/items=%d", name, n), func(b *testing.B) {
+				benchmarkDumper(b, n, newDumper)
+			})
+		}
+	}
+}
+
+// Test_Dumpers_AllocationsPerObject verifies that the dumpers do not buffer whole lists.
+// The allocated bytes per object must not grow with the size of the list.
+func Test_Dumpers_AllocationsPerObject(t *testing.T) {
+	const runs = 5
+	bytesPerObject := func(t *testing.T, n int, df dumper.DumperFunc) float64 {
+		l := benchmarkList(n)
+		// Warm up shared buffers and caches.
+		require.NoError(t, df(l))
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for range runs {
+			require.NoError(t, df(l))
+		}
+		runtime.ReadMemStats(&after)
+		return float64(after.TotalAlloc-before.TotalAlloc) / float64(runs*n)
+	}
+
+	for name, newDumper := range benchmarkDumpers {
+		t.Run(name, func(t *testing.T) {
+			small := bytesPerObject(t, 10, newDumper(t))
+			large := bytesPerObject(t, 1000, newDumper(t))
+			require.Less(t, large, small*1.5, "allocated bytes per object should stay constant, got %.0f B/object for 10 objects and %.0f B/object for 1000 objects", small, large)
+		})
+	}
+}
+
+// writeCounter counts the writes to the underlying writer.
+type writeCounter struct {
+	w      io.Writer
+	writes int
+}
+
+func (c *writeCounter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.w.Write(p)
+}
+
+// BenchmarkBufferSize compares buffered and unbuffered writes.
+// The writes/op metric of the TarDumper is the number of writes to the file, each one a syscall.
+func BenchmarkBufferSize(b *testing.B) {
+	for _, size := range []int{-1, dumper.DefaultBufferSize} {
+		b.Run(fmt.Sprintf("TarDumper/buffer=%d", size), func(b *testing.B) {
+			f, err := os.Create(filepath.Join(b.TempDir(), "dump.tar"))
+			require.NoError(b, err)
+			defer f.Close()
+			wc := &writeCounter{w: f}
+			d := dumper.NewTarDumper(wc, dumper.TarDumperOptions{BufferSize: size})
+			benchmarkDumper(b, 100, func(testing.TB) dumper.DumperFunc { return d.Dump })
+			b.ReportMetric(float64(wc.writes)/float64(b.N), "writes/op")
+		})
+		b.Run(fmt.Sprintf("DirDumper/buffer=%d", size), func(b *testing.B) {
+			benchmarkDumper(b, 100, func(tb testing.TB) dumper.DumperFunc {
+				d, err := dumper.NewDirDumper(tb.TempDir(), dumper.DirDumperOptions{BufferSize: size})
+				require.NoError(tb, err)
+				tb.Cleanup(func() { d.Close() })
+				return d.Dump
+			})
+		})
+	}
+}
+
+// BenchmarkDirDumper_GzipConcurrency dumps from 8 goroutines with gzip enabled and a file per object.
+// Every file needs a gzip writer, their allocations dominate.
+func BenchmarkDirDumper_GzipConcurrency(b *testing.B) {
+	const concurrency = 8
+	d, err := dumper.NewDirDumper(b.TempDir(), dumper.DirDumperOptions{Gzip: true, Layout: dumper.LayoutNamespaced})
+	require.NoError(b, err)
+	defer d.Close()
+	lists := make([]*unstructured.UnstructuredList, concurrency)
+	for i := range lists {
+		lists[i] = benchmarkList(10)
+		for j := range lists[i].Items {
+			lists[i].Items[j].SetNamespace(fmt.Sprintf("test-ns-%d", i))
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		var wg sync.WaitGroup
+		for _, l := range lists {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := d.Dump(l); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
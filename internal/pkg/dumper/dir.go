@@ -1,89 +1,756 @@
 package dumper
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"compress/gzip"
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
 
 	"go.uber.org/multierr"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 // DirDumper writes objects to a directory.
 // Must be initialized with newDirDumper.
 // Must be closed after use.
+// It is safe for concurrent use.
 type DirDumper struct {
-	dir string
+	// mu guards the open files, the shared buffer, and the written files.
+	mu sync.Mutex
 
-	openFiles map[string]*os.File
+	dir  string
+	ext  string
+	enc  Encoder
+	gzip bool
+	// overrides are the encoders of kinds with a format override.
+	overrides map[schema.GroupKind]objectEncoding
+	// gzipLevel is the compression level of the gzip writers.
+	gzipLevel int
+	// gzipPool reuses the gzip writers of closed files, each one allocates about a megabyte.
+	gzipPool *sync.Pool
+	// bufferSize is the size of the write buffer of files kept open. Zero disables buffering.
+	bufferSize int
+	layout     Layout
+	append     bool
+	name       *template.Template
+	// includeVersion adds the API version to the kind in paths.
+	includeVersion bool
+	metrics        *Metrics
+
+	openFiles map[string]*outputFile
+	// manifest are the checksums of the closed files. Nil if no manifest is written.
+	manifest map[string]ManifestEntry
+	// uids are the UIDs of the written objects for the manifest.
+	uids sets.Set[string]
+	// serverVersion is recorded in the manifest.
+	serverVersion string
+	// edges are the owner references of the written objects. Nil if no edges file is written.
+	edges sets.Set[Edge]
+	// errors are the recorded failures of the dump. Nil if no errors file is written.
+	errors []ErrorRecord
+	// index records the written files for the HTML index. Nil if no index is written.
+	index *htmlIndex
+	// written are the files written with the name template.
+	// They are appended to if written again.
+	written   sets.Set[string]
 	sharedBuf *bytes.Buffer
+	// skipUnchanged skips writing single-object files with unchanged content.
+	skipUnchanged bool
+	stats         DirDumperStats
+}
+
+// objectEncoding is the encoder of objects and the extension of their files, including .gz if gzip is enabled.
+type objectEncoding struct {
+	enc Encoder
+	ext string
+}
+
+// DirDumperStats counts the files written by a DirDumper.
+type DirDumperStats struct {
+	// Written is the number of file writes. A file written multiple times is counted every time.
+	Written int
+	// Unchanged is the number of writes skipped because the file already had the same content, see DirDumperOptions.SkipUnchanged.
+	Unchanged int
+}
+
+// outputFile is a file opened by the DirDumper.
+type outputFile struct {
+	f *os.File
+	// w writes to f, possibly through a compressing writer.
+	w io.Writer
+	// bw buffers the writes to f if buffering is enabled.
+	bw *bufio.Writer
+	// gz is the compressing writer if gzip is enabled.
+	gz *gzip.Writer
+	// gzipPool receives gz after it is closed.
+	gzipPool *sync.Pool
+	// cw computes the checksum of the file if a manifest is written.
+	cw *checksumWriter
+}
+
+// Close flushes and closes the compressing writer, if any, flushes the buffer, and closes the file.
+// The compressing writer is returned to the pool of the DirDumper.
+func (o *outputFile) Close() error {
+	var errs []error
+	if o.gz != nil {
+		if err := o.gz.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close gzip writer for %q: %w", o.f.Name(), err))
+		}
+		// The writer is reset before reuse, no state of this file is carried over.
+		o.gzipPool.Put(o.gz)
+		o.gz = nil
+	}
+	if o.bw != nil {
+		if err := o.bw.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush %q: %w", o.f.Name(), err))
+		}
+	}
+	if err := o.f.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return multierr.Combine(errs...)
+}
+
+// DirDumperOptions configures a DirDumper.
+type DirDumperOptions struct {
+	// Format is the serialization format of the written objects.
+	// Defaults to JSON.
+	Format Format
+
+	// Encoder overrides the encoder of Format, for example to write a custom format.
+	// Extension must be set if Encoder is set.
+	Encoder Encoder
+	// Extension is the file extension used with Encoder, without a leading dot.
+	Extension string
+
+	// FormatOverrides sets the format of specific kinds, for example to write Secrets as YAML for review.
+	// An override takes precedence over Format and Encoder. The files of an overridden kind get the extension of its format.
+	// With LayoutFlat the overridden kinds are written to separate namespace files, e.g. split/<namespace>/__all__.yaml.
+	// Paths of a NameTemplate are not changed.
+	FormatOverrides map[schema.GroupKind]Format
+
+	// Gzip enables gzip compression of the written files.
+	// The files get an additional .gz extension.
+	Gzip bool
+	// GzipLevel is the gzip compression level, from gzip.BestSpeed to gzip.BestCompression.
+	// Defaults to gzip.DefaultCompression.
+	GzipLevel int
+
+	// BufferSize is the size of the write buffer of every file kept open until Close, used by LayoutFlat and LayoutPerKind.
+	// Buffering reduces the number of write syscalls for small objects. The buffers are flushed at the end of every Dump.
+	// Files written with a single object are not buffered.
+	// Defaults to DefaultBufferSize. A negative size disables buffering.
+	BufferSize int
+
+	// Layout is the directory layout of the written files.
+	// Defaults to LayoutFlat.
+	Layout Layout
+
+	// Append appends to existing files instead of truncating them.
+	// Used to continue an interrupted dump.
+	Append bool
+
+	// NameTemplate is a text/template for the path of the file an object is written to, relative to the directory.
+	// The available fields are .Group, .Version, .Kind, .Namespace, .Name, and .UID.
+	// Slashes in the field values are replaced, slashes in the template create subdirectories.
+	// Objects resulting in the same path are written to the same file.
+	// The template must include the file extension. The .gz extension is added if gzip is enabled.
+	// Cannot be combined with LayoutNamespaced or LayoutPerKind.
+	// Defaults to the layout's file names.
+	NameTemplate string
+
+	// Manifest writes a manifest.json file listing every written file with its size and SHA-256 checksum on Close.
+	// The checksums are computed while writing. Appended files are read once to include their existing contents.
+	// Only files written by this dumper are listed.
+	// The UIDs of the written objects are listed too, with Append including the UIDs of the existing manifest.
+	Manifest bool
+
+	// HTMLIndex writes an index.html file on Close linking to every written file, grouped by namespace and kind, with object counts.
+	// Only files written by this dumper are listed.
+	HTMLIndex bool
+
+	// Edges writes an edges.json file on Close with an edge from every owner reference of the written objects to the object, see Edges.
+	// The edges are taken from the dumped objects, the owners are not looked up.
+	// With Append the edges of the existing file are kept.
+	Edges bool
+
+	// Errors writes an errors.json file on Close with the failures recorded by RecordErrors, see Errors.
+	// No file is written if there were no failures, an existing file is removed.
+	Errors bool
+
+	// IncludeVersion adds the API version to the kind in the file and directory names, e.g. objects-Deployment.v1.apps.json.
+	// Required to keep objects of different versions of the same kind apart.
+	IncludeVersion bool
+
+	// SkipUnchanged does not rewrite a file if it already has the same content, for repeated dumps into the same directory.
+	// The SHA-256 of the content, before compression if gzip is enabled, is compared with the existing file.
+	// Comparing reads the existing file, which is cheaper than writing it on network filesystems.
+	// Only files written with a single object, with LayoutNamespaced or a NameTemplate, are compared.
+	// Files kept open, and files appended to, are always written.
+	SkipUnchanged bool
+
+	// Metrics counts the bytes written to the files, including the manifest. If nil, no metrics are recorded.
+	Metrics *Metrics
+}
+
+// GetGzipLevel returns the set gzip compression level or the default.
+func (opts DirDumperOptions) GetGzipLevel() int {
+	if opts.GzipLevel == 0 {
+		return gzip.DefaultCompression
+	}
+	return opts.GzipLevel
+}
+
+// DefaultBufferSize is the default size of the write buffers of the dumpers.
+const DefaultBufferSize = 32 * 1024
+
+// GetBufferSize returns the set buffer size, the default, or zero if buffering is disabled.
+func (opts DirDumperOptions) GetBufferSize() int {
+	return bufferSize(opts.BufferSize)
+}
+
+// bufferSize returns the buffer size for the given option.
+func bufferSize(size int) int {
+	switch {
+	case size < 0:
+		return 0
+	case size == 0:
+		return DefaultBufferSize
+	}
+	return size
+}
+
+// validateGzipLevel returns an error if the level is neither zero, for the default, nor between gzip.BestSpeed and gzip.BestCompression.
+func validateGzipLevel(level int) error {
+	if level == 0 || level == gzip.DefaultCompression || (level >= gzip.BestSpeed && level <= gzip.BestCompression) {
+		return nil
+	}
+	return fmt.Errorf("invalid gzip level %d, must be between %d and %d", level, gzip.BestSpeed, gzip.BestCompression)
+}
+
+// nameTemplateData are the fields available in DirDumperOptions.NameTemplate.
+type nameTemplateData struct {
+	Group     string
+	Version   string
+	Kind      string
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// Layout is the directory layout of a DirDumper.
+type Layout string
+
+const (
+	// LayoutFlat writes objects of the same kind into a single file, see DirDumper.Dump.
+	LayoutFlat Layout = "flat"
+	// LayoutNamespaced writes every object to a separate file named <namespace>/<kind>/<name>.<ext>.
+	// Cluster-scoped objects are written to _cluster/<kind>/<name>.<ext>.
+	LayoutNamespaced Layout = "namespaced"
+	// LayoutPerKind writes all objects of a kind to a single file named <group>_<version>_<kind>.<ext>.
+	// The core group is written as "core". With JSON every object is written on a separate line.
+	LayoutPerKind Layout = "per-kind"
+)
+
+// clusterScopedDir is the directory cluster-scoped objects are written to in the namespaced layout.
+const clusterScopedDir = "_cluster"
+
+// ParseLayout parses the given string into a Layout.
+// An error is returned if the layout is unknown.
+func ParseLayout(s string) (Layout, error) {
+	switch l := Layout(s); l {
+	case LayoutFlat, LayoutNamespaced, LayoutPerKind:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown layout %q, must be one of %q, %q, %q", s, LayoutFlat, LayoutNamespaced, LayoutPerKind)
 }
 
 // NewDirDumper creates a new dirDumper that writes objects to the given directory.
 // The directory will be created if it does not exist.
-// If the directory cannot be created, an error is returned.
-func NewDirDumper(dir string) (*DirDumper, error) {
+// If the directory cannot be created or the name template is invalid, an error is returned.
+func NewDirDumper(dir string, opts DirDumperOptions) (*DirDumper, error) {
+	var name *template.Template
+	if opts.NameTemplate != "" {
+		if opts.Layout != "" && opts.Layout != LayoutFlat {
+			return nil, fmt.Errorf("name template cannot be combined with the %s layout", opts.Layout)
+		}
+		t, err := template.New("name").Parse(opts.NameTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse name template: %w", err)
+		}
+		// Unknown fields are only detected when executing the template.
+		if err := t.Execute(io.Discard, nameTemplateData{}); err != nil {
+			return nil, fmt.Errorf("invalid name template: %w", err)
+		}
+		name = t
+	}
+	if opts.Encoder != nil && opts.Extension == "" {
+		return nil, fmt.Errorf("an extension is required for a custom encoder")
+	}
+	if err := validateGzipLevel(opts.GzipLevel); err != nil {
+		return nil, err
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory %q: %w", dir, err)
 	}
-	return &DirDumper{
-		dir:       dir,
-		openFiles: make(map[string]*os.File),
-		sharedBuf: new(bytes.Buffer),
-	}, nil
+	d := &DirDumper{
+		dir:            dir,
+		ext:            string(FormatJSON),
+		enc:            EncoderForFormat(opts.Format),
+		gzip:           opts.Gzip,
+		gzipLevel:      opts.GetGzipLevel(),
+		gzipPool:       new(sync.Pool),
+		bufferSize:     opts.GetBufferSize(),
+		layout:         opts.Layout,
+		append:         opts.Append,
+		name:           name,
+		includeVersion: opts.IncludeVersion,
+		metrics:        opts.Metrics,
+		openFiles:      make(map[string]*outputFile),
+		written:        sets.New[string](),
+		sharedBuf:      new(bytes.Buffer),
+		skipUnchanged:  opts.SkipUnchanged,
+	}
+	if opts.Format == FormatYAML {
+		d.ext = string(FormatYAML)
+	}
+	if opts.Encoder != nil {
+		d.enc = opts.Encoder
+		d.ext = opts.Extension
+	}
+	if opts.Gzip {
+		d.ext += ".gz"
+	}
+	for gk, f := range opts.FormatOverrides {
+		if _, err := ParseFormat(string(f)); err != nil {
+			return nil, fmt.Errorf("invalid format override for %s: %w", gk, err)
+		}
+		if d.overrides == nil {
+			d.overrides = make(map[schema.GroupKind]objectEncoding, len(opts.FormatOverrides))
+		}
+		e := objectEncoding{enc: EncoderForFormat(f), ext: string(f)}
+		if opts.Gzip {
+			e.ext += ".gz"
+		}
+		d.overrides[gk] = e
+	}
+	if opts.Manifest {
+		d.manifest = make(map[string]ManifestEntry)
+		d.uids = sets.New[string]()
+		if opts.Append {
+			// The UIDs of the objects written before the dump was interrupted are kept.
+			if m, err := ReadManifest(filepath.Join(dir, ManifestFile)); err == nil {
+				d.uids.Insert(m.UIDs...)
+			}
+		}
+	}
+	if opts.Edges {
+		d.edges = sets.New[Edge]()
+		if opts.Append {
+			if e, err := readEdges(filepath.Join(dir, EdgesFile)); err == nil {
+				d.edges.Insert(e.Edges...)
+			}
+		}
+	}
+	if opts.Errors {
+		d.errors = []ErrorRecord{}
+	}
+	if opts.HTMLIndex {
+		d.index = newHTMLIndex()
+	}
+	return d, nil
 }
 
 // Close closes the dirDumper and all open files.
+// Compressed files are flushed before they are closed.
+// The manifest, the HTML index, the edges, and the errors are written after all files are closed, if enabled.
 // The dirDumper cannot be used after it is closed.
 func (d *DirDumper) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	var errs []error
 	for _, f := range d.openFiles {
-		if err := f.Close(); err != nil {
+		if err := d.closeFile(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.manifest != nil {
+		if err := d.writeManifest(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.index != nil {
+		if err := d.writeIndex(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.edges != nil {
+		if err := d.writeEdges(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if d.errors != nil {
+		if err := d.writeErrors(); err != nil {
 			errs = append(errs, err)
 		}
 	}
 	return multierr.Combine(errs...)
 }
 
+// closeFile closes the file and records its checksum in the manifest.
+func (d *DirDumper) closeFile(f *outputFile) error {
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return d.recordChecksum(f.f.Name(), f)
+}
+
+// recordChecksum records the checksum of the closed file in the manifest under the given path.
+// The path differs from the name of the file if it was written to a temporary file.
+func (d *DirDumper) recordChecksum(path string, f *outputFile) error {
+	if f.cw == nil {
+		return nil
+	}
+	return d.recordManifestEntry(path, f.cw)
+}
+
+// recordManifestEntry records the checksum of cw in the manifest under the given path.
+func (d *DirDumper) recordManifestEntry(path string, cw *checksumWriter) error {
+	rel, err := filepath.Rel(d.dir, path)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path of %q: %w", path, err)
+	}
+	rel = filepath.ToSlash(rel)
+	d.manifest[rel] = cw.entry(rel)
+	return nil
+}
+
+// SetServerVersion sets the version of the API server recorded in the manifest, see DiscoveryOptions.ServerVersion.
+func (d *DirDumper) SetServerVersion(v string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.serverVersion = v
+}
+
+// Stats returns the number of written and unchanged files.
+func (d *DirDumper) Stats() DirDumperStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+func (d *DirDumper) writeManifest() error {
+	path := filepath.Join(d.dir, ManifestFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+	if err := encodeManifest(d.metrics.Writer(f), d.manifest, d.uids, d.serverVersion); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return f.Close()
+}
+
 // Dump writes the objects in the list to the directory.
-// The objects are written to the directory in two ways:
-// - All objects are written to a file named objects-<kind>.json
+// With the default flat layout the objects are written to the directory in two ways:
+// - All objects are written to a file named objects-<kind>.<ext>
 // - Objects with a namespace are written to a directory named split/<namespace> with two files:
-//   - __all__.json contains all objects in the namespace
-//   - <kind>.json contains all objects of the kind in the namespace
+//   - __all__.<ext> contains all objects in the namespace
+//   - <kind>.<ext> contains all objects of the kind in the namespace
+//
+// With the namespaced layout every object is written to a separate file, see LayoutNamespaced.
+// With the per-kind layout the objects are written to a file per kind, see LayoutPerKind.
+// With a name template the objects are written to the file resulting from the template, see DirDumperOptions.NameTemplate.
+//
+// The extension is json or yaml depending on the configured format, with an additional .gz if gzip is enabled.
+// Kinds with a format override, see DirDumperOptions.FormatOverrides, use the extension of their format.
+//
+// Writes to files kept open are buffered, see DirDumperOptions.BufferSize, and flushed before returning.
+// With gzip the files kept open are only valid gzip files after Close, before that they lack the gzip trailer.
 //
 // If an object cannot be written, an error is returned.
-// This method is not safe for concurrent use.
+// Concurrent calls are serialized so the objects of a list are never interleaved with other lists.
 func (d *DirDumper) Dump(l *unstructured.UnstructuredList) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	buf := d.sharedBuf
 	var errs []error
 	for _, o := range l.Items {
 		buf.Reset()
-		if err := json.NewEncoder(buf).Encode(o.Object); err != nil {
+		e := d.encoding(o)
+		if err := e.enc.Encode(&o, buf); err != nil {
 			errs = append(errs, fmt.Errorf("failed to encode object: %w", err))
 			continue
 		}
 		p := buf.Bytes()
-		gk := o.GroupVersionKind().GroupKind()
 
-		if err := d.writeToFile(fmt.Sprintf("%s/objects-%s.json", d.dir, gk), p); err != nil {
-			errs = append(errs, err)
+		n := len(errs)
+		switch {
+		case d.name != nil:
+			if err := d.dumpTemplate(o, p); err != nil {
+				errs = append(errs, err)
+			}
+		case d.layout == LayoutNamespaced:
+			if err := d.dumpNamespaced(o, p, e.ext); err != nil {
+				errs = append(errs, err)
+			}
+		case d.layout == LayoutPerKind:
+			if err := d.dumpPerKind(o, p, e.ext); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			errs = append(errs, d.dumpFlat(o, p, e.ext)...)
 		}
-
-		if o.GetNamespace() == "" {
+		if d.uids != nil && len(errs) == n && o.GetUID() != "" {
+			d.uids.Insert(string(o.GetUID()))
+		}
+		if d.edges != nil && len(errs) == n {
+			d.edges.Insert(ownerEdges(o)...)
+		}
+	}
+	// The objects of a list are written to the files when Dump returns, for example before a checkpoint is saved.
+	// With gzip the compressed data is flushed, but the gzip trailer of files kept open is only written on Close.
+	for _, f := range d.openFiles {
+		if f.gz != nil {
+			if err := f.gz.Flush(); err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush gzip writer for %q: %w", f.f.Name(), err))
+			}
+		}
+		if f.bw == nil {
 			continue
 		}
+		if err := f.bw.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush %q: %w", f.f.Name(), err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// encoding returns the encoder and file extension of the object, taking the format overrides into account.
+func (d *DirDumper) encoding(o unstructured.Unstructured) objectEncoding {
+	if e, ok := d.overrides[o.GroupVersionKind().GroupKind()]; ok {
+		return e
+	}
+	return objectEncoding{enc: d.enc, ext: d.ext}
+}
+
+// kindName returns the name of the kind of the object used in paths.
+func (d *DirDumper) kindName(o unstructured.Unstructured) string {
+	gvk := o.GroupVersionKind()
+	if !d.includeVersion {
+		return gvk.GroupKind().String()
+	}
+	name := gvk.Kind + "." + gvk.Version
+	if gvk.Group != "" {
+		name += "." + gvk.Group
+	}
+	return name
+}
 
-		if err := d.writeToFile(fmt.Sprintf("%s/split/%s/__all__.json", d.dir, o.GetNamespace()), p); err != nil {
+func (d *DirDumper) dumpFlat(o unstructured.Unstructured, p []byte, ext string) []error {
+	kind := d.kindName(o)
+	paths := []string{fmt.Sprintf("%s/objects-%s.%s", d.dir, kind, ext)}
+	if o.GetNamespace() != "" {
+		paths = append(paths,
+			fmt.Sprintf("%s/split/%s/__all__.%s", d.dir, o.GetNamespace(), ext),
+			fmt.Sprintf("%s/split/%s/%s.%s", d.dir, o.GetNamespace(), kind, ext),
+		)
+	}
+
+	var errs []error
+	written := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if err := d.writeToFile(path, p); err != nil {
 			errs = append(errs, err)
+			continue
 		}
-		if err := d.writeToFile(fmt.Sprintf("%s/split/%s/%s.json", d.dir, o.GetNamespace(), gk), p); err != nil {
-			errs = append(errs, err)
+		written = append(written, path)
+	}
+	if err := d.recordIndex(o, written...); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (d *DirDumper) dumpNamespaced(o unstructured.Unstructured, p []byte, ext string) error {
+	ns := o.GetNamespace()
+	if ns == "" {
+		ns = clusterScopedDir
+	}
+	path := filepath.Join(d.dir, sanitizePathSegment(ns), sanitizePathSegment(d.kindName(o)), sanitizePathSegment(o.GetName())+"."+ext)
+
+	if err := d.writeAndClose(path, p, d.append); err != nil {
+		return err
+	}
+	return d.recordIndex(o, path)
+}
+
+// dumpPerKind appends the object to the file of its kind.
+// The files stay open until Close, objects of the same kind from later batches are appended.
+func (d *DirDumper) dumpPerKind(o unstructured.Unstructured, p []byte, ext string) error {
+	gvk := o.GroupVersionKind()
+	group := gvk.Group
+	if group == "" {
+		group = "core"
+	}
+	name := strings.Join([]string{group, gvk.Version, gvk.Kind}, "_")
+	path := filepath.Join(d.dir, sanitizePathSegment(name)+"."+ext)
+	if err := d.writeToFile(path, p); err != nil {
+		return err
+	}
+	return d.recordIndex(o, path)
+}
+
+func (d *DirDumper) dumpTemplate(o unstructured.Unstructured, p []byte) error {
+	gvk := o.GroupVersionKind()
+	r := pathSeparatorReplacer
+	var name strings.Builder
+	err := d.name.Execute(&name, nameTemplateData{
+		Group:     r.Replace(gvk.Group),
+		Version:   r.Replace(gvk.Version),
+		Kind:      r.Replace(gvk.Kind),
+		Namespace: r.Replace(o.GetNamespace()),
+		Name:      r.Replace(o.GetName()),
+		UID:       r.Replace(string(o.GetUID())),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to execute name template: %w", err)
+	}
+	rel := filepath.Clean(name.String())
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("name template resulted in invalid path %q", name.String())
+	}
+	if d.gzip {
+		rel += ".gz"
+	}
+	path := filepath.Join(d.dir, rel)
+
+	// Files are closed after every object to not run out of file descriptors and appended to if written again.
+	err = d.writeAndClose(path, p, d.append || d.written.Has(path))
+	d.written.Insert(path)
+	if err != nil {
+		return err
+	}
+	return d.recordIndex(o, path)
+}
+
+// writeAndClose writes b to a new file and closes it.
+// The file is appended to instead of truncated if appendFile is true.
+// A failed write does not leave a partial file: New files are written to a temporary file renamed on success,
+// appended files are truncated to their previous size.
+// New files are not written if the file already has the content b and SkipUnchanged is set.
+func (d *DirDumper) writeAndClose(path string, b []byte, appendFile bool) error {
+	if appendFile {
+		d.stats.Written++
+		return d.appendAndClose(path, b)
+	}
+	if d.skipUnchanged {
+		if cw, ok := d.unchanged(path, b); ok {
+			d.stats.Unchanged++
+			if d.manifest == nil {
+				return nil
+			}
+			return d.recordManifestEntry(path, cw)
 		}
 	}
-	return multierr.Combine(errs...)
+	d.stats.Written++
+	tmp := path + ".tmp"
+	// The object is written with a single write, buffering would only add a copy.
+	f, err := d.createFile(tmp, false, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file for copying: %w", err)
+	}
+	if _, err := f.w.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to copy to file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename %q: %w", tmp, err)
+	}
+	return d.recordChecksum(path, f)
+}
+
+// unchanged returns true if the file at path has the content b, after decompressing it if gzip is enabled.
+// The returned checksumWriter holds the checksum and size of the existing file for the manifest.
+// Missing or unreadable files are considered changed.
+func (d *DirDumper) unchanged(path string, b []byte) (*checksumWriter, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+	cw := newChecksumWriter(io.Discard)
+	var r io.Reader = io.TeeReader(f, cw)
+	if d.gzip {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, false
+		}
+		r = gz
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, false
+	}
+	want := sha256.Sum256(b)
+	return cw, bytes.Equal(h.Sum(nil), want[:])
+}
+
+// appendAndClose appends b to the file and closes it.
+// The file is truncated to its previous size if the write fails.
+func (d *DirDumper) appendAndClose(path string, b []byte) error {
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	f, err := d.createFile(path, true, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file for copying: %w", err)
+	}
+	var werr error
+	if _, err := f.w.Write(b); err != nil {
+		werr = fmt.Errorf("failed to copy to file: %w", err)
+	} else if err := f.Close(); err != nil {
+		werr = err
+	}
+	if werr != nil {
+		f.f.Close()
+		if err := os.Truncate(path, size); err != nil {
+			werr = multierr.Append(werr, fmt.Errorf("failed to truncate %q after failed write: %w", path, err))
+		}
+		return werr
+	}
+	return d.recordChecksum(path, f)
+}
+
+// pathSeparatorReplacer replaces path separators.
+var pathSeparatorReplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// sanitizePathSegment makes the given string safe to use as a single path segment.
+func sanitizePathSegment(s string) string {
+	s = pathSeparatorReplacer.Replace(s)
+	if s == "" || s == "." || s == ".." {
+		return "_" + s
+	}
+	return s
 }
 
 func (d *DirDumper) writeToFile(path string, b []byte) error {
@@ -91,25 +758,77 @@ func (d *DirDumper) writeToFile(path string, b []byte) error {
 	if err != nil {
 		return fmt.Errorf("failed to open file for copying: %w", err)
 	}
-	if _, err := f.Write(b); err != nil {
+	if _, err := f.w.Write(b); err != nil {
 		return fmt.Errorf("failed to copy to file: %w", err)
 	}
 	return nil
 }
 
-func (d *DirDumper) file(path string) (*os.File, error) {
+func (d *DirDumper) file(path string) (*outputFile, error) {
 	f, ok := d.openFiles[path]
 	if ok {
 		return f, nil
 	}
+	f, err := d.createFile(path, d.append, d.bufferSize)
+	if err != nil {
+		return nil, err
+	}
+	d.stats.Written++
+	d.openFiles[path] = f
+	return f, nil
+}
+
+// gzipWriter returns a gzip writer writing to w, reusing a pooled writer if available.
+func (d *DirDumper) gzipWriter(w io.Writer) *gzip.Writer {
+	if gz, ok := d.gzipPool.Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return gz
+	}
+	// The level is validated by NewDirDumper.
+	gz, _ := gzip.NewWriterLevel(w, d.gzipLevel)
+	return gz
+}
+
+// createFile creates the file and its parent directories.
+// The file is appended to instead of truncated if appendFile is true.
+// Writes are buffered if bufferSize is greater than zero.
+// The file is not tracked and must be closed by the caller.
+func (d *DirDumper) createFile(path string, appendFile bool, bufferSize int) (*outputFile, error) {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory %q: %w", dir, err)
 	}
-	f, err := os.Create(path)
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if appendFile {
+		flags = os.O_RDWR | os.O_CREATE | os.O_APPEND
+	}
+	osf, err := os.OpenFile(path, flags, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file %q: %w", path, err)
 	}
-	d.openFiles[path] = f
+	f := &outputFile{f: osf, w: osf}
+	if bufferSize > 0 {
+		f.bw = bufio.NewWriterSize(osf, bufferSize)
+		f.w = f.bw
+	}
+	f.w = d.metrics.Writer(f.w)
+	if d.manifest != nil {
+		f.cw = newChecksumWriter(f.w)
+		if appendFile {
+			// Include the existing contents in the checksum.
+			n, err := io.Copy(f.cw.h, osf)
+			if err != nil {
+				osf.Close()
+				return nil, fmt.Errorf("failed to read existing file %q: %w", path, err)
+			}
+			f.cw.n = n
+		}
+		f.w = f.cw
+	}
+	if d.gzip {
+		f.gz = d.gzipWriter(f.w)
+		f.gzipPool = d.gzipPool
+		f.w = f.gz
+	}
 	return f, nil
 }
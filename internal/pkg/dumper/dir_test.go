@@ -2,12 +2,20 @@ package dumper_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
 )
@@ -17,7 +25,7 @@ func Test_DirDumper(t *testing.T) {
 	require.NoError(t, err)
 	defer os.RemoveAll(tdir)
 
-	subject, err := dumper.NewDirDumper(tdir)
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{})
 	require.NoError(t, err)
 
 	uls := []*unstructured.UnstructuredList{
@@ -134,6 +142,701 @@ func Test_DirDumper(t *testing.T) {
 	})
 }
 
+func Test_DirDumper_YAML(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Format: dumper.FormatYAML})
+	require.NoError(t, err)
+
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod-2",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	for _, p := range []string{"/objects-Pod.yaml", "/split/test-ns/__all__.yaml", "/split/test-ns/Pod.yaml"} {
+		f, err := os.Open(tdir + p)
+		require.NoError(t, err)
+		defer f.Close()
+		require.Equal(t, []string{"test-pod", "test-pod-2"}, decodeYAMLNames(t, f))
+	}
+}
+
+func Test_DirDumper_Gzip(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true})
+	require.NoError(t, err)
+
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	for _, p := range []string{"/objects-Pod.json.gz", "/split/test-ns/__all__.json.gz", "/split/test-ns/Pod.json.gz"} {
+		f, err := os.Open(tdir + p)
+		require.NoError(t, err)
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		var obj unstructured.Unstructured
+		require.NoError(t, json.NewDecoder(gr).Decode(&obj.Object))
+		require.Equal(t, "test-pod", obj.GetName())
+	}
+}
+
+func Test_DirDumper_Gzip_PooledWriters(t *testing.T) {
+	tdir := t.TempDir()
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true, Layout: dumper.LayoutNamespaced})
+	require.NoError(t, err)
+
+	l := benchmarkList(20)
+	var wg sync.WaitGroup
+	errs := make(chan error, len(l.Items))
+	for i := range l.Items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- subject.Dump(&unstructured.UnstructuredList{Items: l.Items[i : i+1]})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, subject.Close())
+
+	for _, o := range l.Items {
+		f, err := os.Open(filepath.Join(tdir, "test-ns", "ConfigMap", o.GetName()+".json.gz"))
+		require.NoError(t, err)
+		defer f.Close()
+		gr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		var objs []string
+		dec := json.NewDecoder(gr)
+		for dec.More() {
+			var obj unstructured.Unstructured
+			require.NoError(t, dec.Decode(&obj.Object))
+			objs = append(objs, obj.GetName())
+		}
+		require.Equal(t, []string{o.GetName()}, objs, "a reused gzip writer must not carry over data of another file")
+	}
+}
+
+func Test_DirDumper_Gzip_FlushedAfterDump(t *testing.T) {
+	tdir := t.TempDir()
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true, Layout: dumper.LayoutPerKind})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name":      fmt.Sprintf("test-pod-%d", i),
+							"namespace": "test-ns",
+						},
+					},
+				},
+			},
+		}))
+		// The gzip trailer is missing before Close, but the objects written so far can be decompressed.
+		f, err := os.Open(tdir + "/core_v1_Pod.json.gz")
+		require.NoError(t, err)
+		gr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		dec := json.NewDecoder(gr)
+		for j := range i + 1 {
+			var obj unstructured.Unstructured
+			require.NoError(t, dec.Decode(&obj.Object))
+			require.Equal(t, fmt.Sprintf("test-pod-%d", j), obj.GetName())
+		}
+		require.NoError(t, f.Close())
+	}
+	require.NoError(t, subject.Close())
+}
+
+func Test_DirDumper_GzipLevel(t *testing.T) {
+	for level := gzip.DefaultCompression; level <= gzip.BestCompression; level++ {
+		t.Run(fmt.Sprintf("Level%d", level), func(t *testing.T) {
+			tdir, err := os.MkdirTemp(".", "test")
+			require.NoError(t, err)
+			defer os.RemoveAll(tdir)
+
+			subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true, GzipLevel: level})
+			require.NoError(t, err)
+			require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+				Items: []unstructured.Unstructured{
+					{
+						Object: map[string]interface{}{
+							"kind":       "Pod",
+							"apiVersion": "v1",
+							"metadata": map[string]interface{}{
+								"name": "test-pod",
+							},
+						},
+					},
+				},
+			}))
+			require.NoError(t, subject.Close())
+
+			f, err := os.Open(tdir + "/objects-Pod.json.gz")
+			require.NoError(t, err)
+			defer f.Close()
+			gr, err := gzip.NewReader(f)
+			require.NoError(t, err)
+			var obj unstructured.Unstructured
+			require.NoError(t, json.NewDecoder(gr).Decode(&obj.Object))
+			require.Equal(t, "test-pod", obj.GetName())
+		})
+	}
+}
+
+func Test_DirDumper_GzipLevel_Invalid(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	for _, level := range []int{gzip.HuffmanOnly, gzip.BestCompression + 1} {
+		_, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true, GzipLevel: level})
+		require.ErrorContains(t, err, "invalid gzip level")
+	}
+}
+
+func Test_DirDumper_BufferSize(t *testing.T) {
+	for _, size := range []int{-1, 16, dumper.DefaultBufferSize} {
+		t.Run(fmt.Sprintf("Size%d", size), func(t *testing.T) {
+			tdir, err := os.MkdirTemp(".", "test")
+			require.NoError(t, err)
+			defer os.RemoveAll(tdir)
+
+			subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{BufferSize: size, Layout: dumper.LayoutPerKind})
+			require.NoError(t, err)
+			for i := range 3 {
+				require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+					Items: []unstructured.Unstructured{
+						{
+							Object: map[string]interface{}{
+								"kind":       "Pod",
+								"apiVersion": "v1",
+								"metadata": map[string]interface{}{
+									"name":      fmt.Sprintf("test-pod-%d", i),
+									"namespace": "test-ns",
+								},
+							},
+						},
+					},
+				}))
+				// The buffers are flushed after every list.
+				raw, err := os.ReadFile(tdir + "/core_v1_Pod.json")
+				require.NoError(t, err)
+				require.Equal(t, i+1, bytes.Count(raw, []byte("\n")))
+			}
+			require.NoError(t, subject.Close())
+			requireFileContains(t, tdir+"/core_v1_Pod.json", []ExpectedObject{
+				{Kind: "Pod", Name: "test-pod-0", Namespace: "test-ns"},
+				{Kind: "Pod", Name: "test-pod-1", Namespace: "test-ns"},
+				{Kind: "Pod", Name: "test-pod-2", Namespace: "test-ns"},
+			})
+		})
+	}
+}
+
+func Test_DirDumper_NamespacedLayout(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Layout: dumper.LayoutNamespaced})
+	require.NoError(t, err)
+
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+			{
+				Object: map[string]interface{}{
+					"kind":       "ClusterRole",
+					"apiVersion": "rbac.authorization.k8s.io/v1",
+					"metadata": map[string]interface{}{
+						"name": "system:controller/test",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	requireFileContains(t, tdir+"/test-ns/Pod/test-pod.json", []ExpectedObject{
+		{Kind: "Pod", Name: "test-pod", Namespace: "test-ns"},
+	})
+	requireFileContains(t, tdir+"/_cluster/ClusterRole.rbac.authorization.k8s.io/system:controller_test.json", []ExpectedObject{
+		{Kind: "ClusterRole", Name: "system:controller/test"},
+	})
+}
+
+func Test_DirDumper_NamespacedLayout_FailedWrite(t *testing.T) {
+	tdir := t.TempDir()
+	path := filepath.Join(tdir, "test-ns", "Pod", "test-pod.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, os.WriteFile(path, []byte("previous\n"), 0644))
+	// The temporary file can't be created if a directory with its name exists.
+	require.NoError(t, os.Mkdir(path+".tmp", 0755))
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Layout: dumper.LayoutNamespaced})
+	require.NoError(t, err)
+	pod := unstructured.Unstructured{Object: map[string]any{
+		"kind":       "Pod",
+		"apiVersion": "v1",
+		"metadata":   map[string]any{"name": "test-pod", "namespace": "test-ns"},
+	}}
+	require.Error(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{pod}}))
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "previous\n", string(raw), "a failed write should not replace the file")
+
+	require.NoError(t, os.Remove(path+".tmp"))
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{pod}}))
+	require.NoError(t, subject.Close())
+	requireFileContains(t, path, []ExpectedObject{{Kind: "Pod", Name: "test-pod", Namespace: "test-ns"}})
+	require.NoFileExists(t, path+".tmp")
+}
+
+func Test_DirDumper_SkipUnchanged(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		tdir := t.TempDir()
+		opts := dumper.DirDumperOptions{Layout: dumper.LayoutNamespaced, Gzip: gz, Manifest: true, SkipUnchanged: true}
+		pod := func(image string) *unstructured.UnstructuredList {
+			return &unstructured.UnstructuredList{Items: []unstructured.Unstructured{{Object: map[string]any{
+				"kind":       "Pod",
+				"apiVersion": "v1",
+				"metadata":   map[string]any{"name": "test-pod", "namespace": "test-ns"},
+				"spec":       map[string]any{"image": image},
+			}}}}
+		}
+		path := filepath.Join(tdir, "test-ns", "Pod", "test-pod.json")
+		if gz {
+			path += ".gz"
+		}
+		dump := func(l *unstructured.UnstructuredList) (dumper.DirDumperStats, dumper.Manifest) {
+			t.Helper()
+			subject, err := dumper.NewDirDumper(tdir, opts)
+			require.NoError(t, err)
+			require.NoError(t, subject.Dump(l))
+			require.NoError(t, subject.Close())
+			raw, err := os.ReadFile(filepath.Join(tdir, dumper.ManifestFile))
+			require.NoError(t, err)
+			var m dumper.Manifest
+			require.NoError(t, json.Unmarshal(raw, &m))
+			return subject.Stats(), m
+		}
+
+		stats, first := dump(pod("nginx:1"))
+		require.Equal(t, dumper.DirDumperStats{Written: 1}, stats)
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, os.Chtimes(path, past, past))
+
+		stats, second := dump(pod("nginx:1"))
+		require.Equal(t, dumper.DirDumperStats{Unchanged: 1}, stats, "gzip=%t", gz)
+		require.Equal(t, first, second, "unchanged files should be listed in the manifest")
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, past, fi.ModTime(), "the file should not be written")
+
+		stats, _ = dump(pod("nginx:2"))
+		require.Equal(t, dumper.DirDumperStats{Written: 1}, stats)
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		var r io.Reader = f
+		if gz {
+			r, err = gzip.NewReader(f)
+			require.NoError(t, err)
+		}
+		raw, err := io.ReadAll(r)
+		f.Close()
+		require.NoError(t, err)
+		require.Contains(t, string(raw), "nginx:2")
+	}
+}
+
+func Test_DirDumper_PerKindLayout(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Layout: dumper.LayoutPerKind})
+	require.NoError(t, err)
+
+	obj := func(apiVersion, kind, ns, name string) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       kind,
+				"apiVersion": apiVersion,
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": ns,
+				},
+			},
+		}
+	}
+	// Objects of the same kind arrive in multiple batches.
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+		obj("v1", "Pod", "test-ns", "test-pod"),
+		obj("apps/v1", "Deployment", "test-ns", "test-deploy"),
+	}}))
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{
+		obj("v1", "Pod", "test-ns-2", "test-pod-2"),
+	}}))
+	require.NoError(t, subject.Close())
+
+	requireFileContains(t, tdir+"/core_v1_Pod.json", []ExpectedObject{
+		{Kind: "Pod", Name: "test-pod", Namespace: "test-ns"},
+		{Kind: "Pod", Name: "test-pod-2", Namespace: "test-ns-2"},
+	})
+	requireFileContains(t, tdir+"/apps_v1_Deployment.json", []ExpectedObject{
+		{Kind: "Deployment", Name: "test-deploy", Namespace: "test-ns"},
+	})
+	entries, err := os.ReadDir(tdir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "only the per kind files should be written")
+}
+
+func Test_DirDumper_HTMLIndex(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Layout: dumper.LayoutNamespaced, HTMLIndex: true})
+	require.NoError(t, err)
+
+	obj := func(kind, namespace, name string) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       kind,
+				"apiVersion": "v1",
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": namespace,
+				},
+			},
+		}
+	}
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{obj("Pod", "test-ns", "test-pod"), obj("Pod", "test-ns", "<test-pod-2>")},
+	}))
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{obj("Namespace", "", "test-ns")},
+	}))
+	require.NoError(t, subject.Close())
+
+	b, err := os.ReadFile(tdir + "/" + dumper.IndexFile)
+	require.NoError(t, err)
+	index := string(b)
+
+	require.Contains(t, index, "<h2>Cluster-scoped (1)</h2>")
+	require.Contains(t, index, "<h2>test-ns (2)</h2>")
+	require.Contains(t, index, "<li>Pod (2)")
+	require.Contains(t, index, `<a href="test-ns/Pod/test-pod.json">test-ns/Pod/test-pod.json</a> (1)`)
+	require.Contains(t, index, `<a href="_cluster/Namespace/test-ns.json">_cluster/Namespace/test-ns.json</a> (1)`)
+	require.Contains(t, index, "&lt;test-pod-2&gt;.json", "file names should be escaped")
+	require.Less(t, strings.Index(index, "Cluster-scoped"), strings.Index(index, "test-ns (2)"), "cluster-scoped objects should come first")
+}
+
+func Test_DirDumper_NameTemplate(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{
+		Format:       dumper.FormatYAML,
+		NameTemplate: "{{.Namespace}}__{{.Kind}}__{{.Name}}.yaml",
+	})
+	require.NoError(t, err)
+
+	pod := func(name string) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       "Pod",
+				"apiVersion": "v1",
+				"metadata": map[string]interface{}{
+					"name":      name,
+					"namespace": "test-ns",
+				},
+			},
+		}
+	}
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{pod("a/b")}}))
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{pod("a/b")}}))
+	require.NoError(t, subject.Close())
+
+	b, err := os.ReadFile(tdir + "/test-ns__Pod__a_b.yaml")
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(b), "name: a/b"), "objects with the same path should be appended")
+}
+
+func Test_DirDumper_NameTemplate_Invalid(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	_, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{NameTemplate: "{{.Unknown}}.json"})
+	require.ErrorContains(t, err, "Unknown")
+	_, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{NameTemplate: "{{.Name"})
+	require.Error(t, err)
+}
+
+func Test_DirDumper_Encoder(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	_, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Encoder: nameEncoder{}})
+	require.Error(t, err, "an extension is required for custom encoders")
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Encoder: nameEncoder{}, Extension: "txt"})
+	require.NoError(t, err)
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	b, err := os.ReadFile(tdir + "/objects-Pod.txt")
+	require.NoError(t, err)
+	require.Equal(t, "test-pod\n", string(b))
+}
+
+func Test_DirDumper_FormatOverrides(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	_, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{FormatOverrides: map[schema.GroupKind]dumper.Format{{Kind: "Secret"}: "xml"}})
+	require.ErrorContains(t, err, "invalid format override for Secret")
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{
+		Encoder:         nameEncoder{},
+		Extension:       "txt",
+		FormatOverrides: map[schema.GroupKind]dumper.Format{{Kind: "Secret"}: dumper.FormatYAML},
+	})
+	require.NoError(t, err)
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+			{
+				Object: map[string]interface{}{
+					"kind":       "Secret",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-secret",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	for _, p := range []string{"/objects-Pod.txt", "/split/test-ns/__all__.txt", "/split/test-ns/Pod.txt"} {
+		b, err := os.ReadFile(tdir + p)
+		require.NoError(t, err)
+		require.Equal(t, "test-pod\n", string(b), "the global encoder should be used for %s", p)
+	}
+	for _, p := range []string{"/objects-Secret.yaml", "/split/test-ns/__all__.yaml", "/split/test-ns/Secret.yaml"} {
+		f, err := os.Open(tdir + p)
+		require.NoError(t, err)
+		defer f.Close()
+		require.Equal(t, []string{"test-secret"}, decodeYAMLNames(t, f), "the override should take precedence for %s", p)
+	}
+}
+
+func Test_DirDumper_IncludeVersion(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{IncludeVersion: true})
+	require.NoError(t, err)
+
+	hpa := func(apiVersion string) unstructured.Unstructured {
+		return unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"kind":       "HorizontalPodAutoscaler",
+				"apiVersion": apiVersion,
+				"metadata": map[string]interface{}{
+					"name":      "test-hpa",
+					"namespace": "test-ns",
+				},
+			},
+		}
+	}
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{hpa("autoscaling/v1"), hpa("autoscaling/v2")},
+	}))
+	require.NoError(t, subject.Close())
+
+	for _, v := range []string{"v1", "v2"} {
+		expected := []ExpectedObject{{Kind: "HorizontalPodAutoscaler", Name: "test-hpa", Namespace: "test-ns"}}
+		requireFileContains(t, tdir+"/objects-HorizontalPodAutoscaler."+v+".autoscaling.json", expected)
+		requireFileContains(t, tdir+"/split/test-ns/HorizontalPodAutoscaler."+v+".autoscaling.json", expected)
+	}
+}
+
+func Test_DirDumper_Append(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	for i, name := range []string{"test-pod", "test-pod-2"} {
+		subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Append: i > 0})
+		require.NoError(t, err)
+		require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name":      name,
+							"namespace": "test-ns",
+						},
+					},
+				},
+			},
+		}))
+		require.NoError(t, subject.Close())
+	}
+
+	requireFileContains(t, tdir+"/objects-Pod.json", []ExpectedObject{
+		{Kind: "Pod", Name: "test-pod", Namespace: "test-ns"},
+		{Kind: "Pod", Name: "test-pod-2", Namespace: "test-ns"},
+	})
+}
+
+func Test_DirDumper_Concurrent(t *testing.T) {
+	tdir := t.TempDir()
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{})
+	require.NoError(t, err)
+
+	const workers, lists = 8, 20
+	kinds := []string{"Pod", "ConfigMap"}
+	expected := map[string][]ExpectedObject{}
+	for w := range workers {
+		for i := range lists {
+			for _, kind := range kinds {
+				// Objects of the same kind share objects-<kind>.json, the namespaces are split per worker.
+				o := ExpectedObject{Kind: kind, Name: fmt.Sprintf("test-%d-%d", w, i), Namespace: fmt.Sprintf("test-ns-%d", w)}
+				expected["objects-"+kind+".json"] = append(expected["objects-"+kind+".json"], o)
+				expected["split/"+o.Namespace+"/__all__.json"] = append(expected["split/"+o.Namespace+"/__all__.json"], o)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*lists)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range lists {
+				var l unstructured.UnstructuredList
+				for _, kind := range kinds {
+					l.Items = append(l.Items, unstructured.Unstructured{
+						Object: map[string]interface{}{
+							"kind":       kind,
+							"apiVersion": "v1",
+							"metadata": map[string]interface{}{
+								"name":      fmt.Sprintf("test-%d-%d", w, i),
+								"namespace": fmt.Sprintf("test-ns-%d", w),
+								// Large enough to be written in multiple chunks if not serialized.
+								"annotations": map[string]interface{}{"padding": strings.Repeat("x", 16<<10)},
+							},
+						},
+					})
+				}
+				errs <- subject.Dump(&l)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, subject.Close())
+
+	for path, objs := range expected {
+		requireFileContains(t, tdir+"/"+path, objs)
+	}
+}
+
 type ExpectedObject struct {
 	Kind, Name, Namespace string
 }
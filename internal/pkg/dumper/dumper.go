@@ -2,18 +2,217 @@
 package dumper
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
+	"maps"
+	"slices"
 
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 )
 
+// Format is the serialization format of dumped objects.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// ParseFormat parses the given string into a Format.
+// An error is returned if the format is unknown.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatJSON, FormatYAML:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, must be one of %q, %q", s, FormatJSON, FormatYAML)
+}
+
 // Dumper is an interface for dumping a list of unstructured objects
 type DumperFunc func(*unstructured.UnstructuredList) error
 
+// Dump calls f, so a DumperFunc can be used as a Dumper.
+func (f DumperFunc) Dump(l *unstructured.UnstructuredList) error {
+	return f(l)
+}
+
+// Dumper is implemented by the dumpers with state, like DirDumper, and by DumperFunc.
+type Dumper interface {
+	Dump(*unstructured.UnstructuredList) error
+}
+
 // DumpToWriter dumps the list of unstructured objects to the provided writer as JSON
+// The list is written as a single line.
+// The objects are encoded one by one so the encoded list is never held in memory.
 func DumpToWriter(w io.Writer) DumperFunc {
 	return func(l *unstructured.UnstructuredList) error {
-		return json.NewEncoder(w).Encode(l)
+		bw := bufio.NewWriter(w)
+		// Fields are written in sorted order, the same order encoding/json uses for maps.
+		keys := slices.Sorted(maps.Keys(l.Object))
+		if !slices.Contains(keys, "items") {
+			keys = slices.Sorted(slices.Values(append(keys, "items")))
+		}
+		bw.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			kb, err := json.Marshal(k)
+			if err != nil {
+				return fmt.Errorf("failed to encode list field: %w", err)
+			}
+			bw.Write(kb)
+			bw.WriteByte(':')
+			if k == "items" {
+				if err := writeItems(bw, l.Items); err != nil {
+					return err
+				}
+				continue
+			}
+			vb, err := json.Marshal(l.Object[k])
+			if err != nil {
+				return fmt.Errorf("failed to encode list field %q: %w", k, err)
+			}
+			bw.Write(vb)
+		}
+		bw.WriteString("}\n")
+		// Write errors are sticky and returned by Flush.
+		return bw.Flush()
+	}
+}
+
+// writeItems writes the items as a JSON array, encoding one item at a time.
+func writeItems(w *bufio.Writer, items []unstructured.Unstructured) error {
+	w.WriteByte('[')
+	for i := range items {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		b, err := json.Marshal(items[i].Object)
+		if err != nil {
+			return fmt.Errorf("failed to encode object: %w", err)
+		}
+		w.Write(b)
+	}
+	w.WriteByte(']')
+	return nil
+}
+
+// CompletionMarker is the final record written by WriteCompletionMarker after a successful dump.
+// Consumers of a stream can detect a truncated dump by its absence.
+type CompletionMarker struct {
+	// Dump is always "complete".
+	Dump string `json:"_dump"`
+	// Count is the number of dumped objects.
+	Count int `json:"count"`
+}
+
+// WriteCompletionMarker writes a CompletionMarker for the given number of objects to the writer.
+// With JSON the marker is written as a single line, e.g. {"_dump":"complete","count":3}.
+// With YAML it is written as a separate document.
+// It must only be called after all objects were dumped successfully.
+func WriteCompletionMarker(w io.Writer, f Format, count int) error {
+	m := CompletionMarker{Dump: "complete", Count: count}
+	var b []byte
+	var err error
+	if f == FormatYAML {
+		b, err = yaml.Marshal(m)
+		b = append([]byte("---\n"), b...)
+	} else {
+		b, err = json.Marshal(m)
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode completion marker: %w", err)
+	}
+	if _, err := w.Write(b); err != nil {
+		return fmt.Errorf("failed to write completion marker: %w", err)
+	}
+	return nil
+}
+
+// DumpToWriterNDJSON dumps the objects in the list to the provided writer as newline-delimited JSON.
+// Every object is written compactly on a single line terminated by exactly one newline.
+// Every line is written with a single call to Write so readers tailing the stream only see complete records.
+// If the writer has a Flush method, for example a *bufio.Writer, it is flushed after every object.
+func DumpToWriterNDJSON(w io.Writer) DumperFunc {
+	f, _ := w.(interface{ Flush() error })
+	return func(l *unstructured.UnstructuredList) error {
+		for i := range l.Items {
+			b, err := json.Marshal(l.Items[i].Object)
+			if err != nil {
+				return fmt.Errorf("failed to encode object: %w", err)
+			}
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				return fmt.Errorf("failed to write object: %w", err)
+			}
+			if f != nil {
+				if err := f.Flush(); err != nil {
+					return fmt.Errorf("failed to flush object: %w", err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// DumpToWriterYAML dumps the objects in the list to the provided writer as YAML documents.
+// Every document is preceded by a `---` separator.
+// The stream can be applied with kubectl apply -f.
+func DumpToWriterYAML(w io.Writer) DumperFunc {
+	return DumpToWriterWithEncoder(w, YAMLEncoder{})
+}
+
+// DumpToWriterWithEncoder dumps the objects in the list to the provided writer using the encoder.
+func DumpToWriterWithEncoder(w io.Writer, enc Encoder) DumperFunc {
+	return func(l *unstructured.UnstructuredList) error {
+		for i := range l.Items {
+			if err := enc.Encode(&l.Items[i], w); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Encoder serializes objects.
+type Encoder interface {
+	// Encode writes the object to the writer.
+	Encode(obj *unstructured.Unstructured, w io.Writer) error
+}
+
+// EncoderForFormat returns the encoder for the given format.
+// JSONEncoder is returned for unknown formats.
+func EncoderForFormat(f Format) Encoder {
+	if f == FormatYAML {
+		return YAMLEncoder{}
+	}
+	return JSONEncoder{}
+}
+
+// JSONEncoder writes objects as a single line of JSON.
+type JSONEncoder struct{}
+
+// Encode implements Encoder.
+func (JSONEncoder) Encode(obj *unstructured.Unstructured, w io.Writer) error {
+	return json.NewEncoder(w).Encode(obj.Object)
+}
+
+// YAMLEncoder writes objects as YAML documents preceded by a `---` separator.
+type YAMLEncoder struct{}
+
+// Encode implements Encoder.
+func (YAMLEncoder) Encode(obj *unstructured.Unstructured, w io.Writer) error {
+	b, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "---\n"); err != nil {
+		return err
 	}
+	_, err = w.Write(b)
+	return err
 }
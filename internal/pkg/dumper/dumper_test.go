@@ -1,13 +1,19 @@
 package dumper_test
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
 	"github.com/stretchr/testify/require"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
 )
 
 func Test_DumpToWriter(t *testing.T) {
@@ -41,3 +47,270 @@ func Test_DumpToWriter(t *testing.T) {
 	require.Len(t, got.Items, 1)
 	require.Equal(t, "Pod", got.Items[0].GetKind())
 }
+
+func Test_DumpToWriter_MatchesListEncoding(t *testing.T) {
+	l := &unstructured.UnstructuredList{
+		Object: map[string]interface{}{
+			"kind":       "PodList",
+			"apiVersion": "v1",
+			"metadata":   map[string]interface{}{"resourceVersion": "42"},
+		},
+		Items: []unstructured.Unstructured{
+			{Object: map[string]interface{}{"kind": "Pod", "apiVersion": "v1", "metadata": map[string]interface{}{"name": "test-pod"}}},
+			{Object: map[string]interface{}{"kind": "Pod", "apiVersion": "v1", "metadata": map[string]interface{}{"name": "<test-pod-2>"}}},
+		},
+	}
+	var expected bytes.Buffer
+	require.NoError(t, json.NewEncoder(&expected).Encode(l.UnstructuredContent()))
+
+	var b bytes.Buffer
+	require.NoError(t, dumper.DumpToWriter(&b)(l))
+
+	require.Equal(t, expected.String(), b.String())
+}
+
+func Test_DumpToWriterYAML(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpToWriterYAML(&b)
+
+	for _, name := range []string{"test-pod", "test-pod-2"} {
+		require.NoError(t,
+			subject(&unstructured.UnstructuredList{
+				Items: []unstructured.Unstructured{
+					{
+						Object: map[string]interface{}{
+							"kind":       "Pod",
+							"apiVersion": "v1",
+							"metadata": map[string]interface{}{
+								"name":      name,
+								"namespace": "test-ns",
+							},
+						},
+					},
+				},
+			}),
+		)
+	}
+
+	require.Equal(t, []string{"test-pod", "test-pod-2"}, decodeYAMLNames(t, &b))
+}
+
+// Test_DumpToWriterYAML_RoundTrip verifies that the YAML stream can be applied with kubectl apply -f.
+// Every document starts with a separator and decodes to the dumped object.
+func Test_DumpToWriterYAML_RoundTrip(t *testing.T) {
+	objs := []unstructured.Unstructured{
+		{
+			Object: map[string]interface{}{
+				"kind":       "ConfigMap",
+				"apiVersion": "v1",
+				"metadata": map[string]interface{}{
+					"name":      "test-cm",
+					"namespace": "test-ns",
+					"labels":    map[string]interface{}{"app": "test"},
+				},
+				"data": map[string]interface{}{
+					"multiline": "line 1\nline 2\n",
+					"bool":      "yes",
+					"number":    "1",
+					"separator": "---",
+					"colon":     "key: value",
+				},
+			},
+		},
+		{
+			Object: map[string]interface{}{
+				"kind":       "Deployment",
+				"apiVersion": "apps/v1",
+				"metadata": map[string]interface{}{
+					"name":      "test-deploy",
+					"namespace": "test-ns",
+				},
+				"spec": map[string]interface{}{
+					"replicas": int64(3),
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"containers": []interface{}{
+								map[string]interface{}{"name": "app", "args": []interface{}{"--flag", "-v=2"}},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Object: map[string]interface{}{
+				"kind":       "ClusterRole",
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"metadata": map[string]interface{}{
+					"name": "test-role",
+				},
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	subject := dumper.DumpToWriterYAML(&b)
+	require.NoError(t, subject(&unstructured.UnstructuredList{Items: objs[:2]}))
+	require.NoError(t, subject(&unstructured.UnstructuredList{Items: objs[2:]}))
+
+	require.True(t, strings.HasPrefix(b.String(), "---\n"), "the stream should start with a separator")
+	require.Equal(t, len(objs), strings.Count(b.String(), "\n---\n")+1, "every document should start with a separator")
+
+	// Decode the documents like kubectl: split the stream, convert every document to JSON, and decode it.
+	yr := utilyaml.NewYAMLReader(bufio.NewReader(&b))
+	var got []unstructured.Unstructured
+	for {
+		doc, err := yr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		j, err := yaml.YAMLToJSON(doc)
+		require.NoError(t, err)
+		var obj unstructured.Unstructured
+		require.NoError(t, obj.UnmarshalJSON(j))
+		got = append(got, obj)
+	}
+	require.Equal(t, objs, got)
+}
+
+func Test_WriteCompletionMarker(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, dumper.DumpToWriter(&b)(&unstructured.UnstructuredList{
+		Object: map[string]interface{}{"kind": "List"},
+		Items:  []unstructured.Unstructured{{Object: map[string]interface{}{"kind": "Pod", "apiVersion": "v1"}}},
+	}))
+	require.NoError(t, dumper.WriteCompletionMarker(&b, dumper.FormatJSON, 1))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, `{"_dump":"complete","count":1}`, lines[1])
+
+	b.Reset()
+	require.NoError(t, dumper.DumpToWriterYAML(&b)(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{{Object: map[string]interface{}{"kind": "Pod", "apiVersion": "v1", "metadata": map[string]interface{}{"name": "test-pod"}}}},
+	}))
+	require.NoError(t, dumper.WriteCompletionMarker(&b, dumper.FormatYAML, 1))
+	require.True(t, strings.HasSuffix(b.String(), "---\n_dump: complete\ncount: 1\n"), b.String())
+	require.Equal(t, []string{"test-pod", ""}, decodeYAMLNames(t, &b), "the marker should be a separate document")
+}
+
+// flushRecorder records the written lines and the number of flushes.
+type flushRecorder struct {
+	writes  []string
+	flushes int
+}
+
+func (r *flushRecorder) Write(p []byte) (int, error) {
+	r.writes = append(r.writes, string(p))
+	return len(p), nil
+}
+
+func (r *flushRecorder) Flush() error {
+	r.flushes++
+	return nil
+}
+
+func Test_DumpToWriterNDJSON(t *testing.T) {
+	var rec flushRecorder
+
+	subject := dumper.DumpToWriterNDJSON(&rec)
+
+	require.NoError(t,
+		subject(&unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "ConfigMap",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name":      "test-cm",
+							"namespace": "test-ns",
+						},
+						"data": map[string]interface{}{
+							"config.yaml": "multi\nline\r\nvalue\n",
+						},
+					},
+				},
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name":      "test-pod",
+							"namespace": "test-ns",
+						},
+					},
+				},
+			},
+		}),
+	)
+
+	require.Len(t, rec.writes, 2, "every object should be written with a single write")
+	require.Equal(t, 2, rec.flushes, "the writer should be flushed after every object")
+	lines := bytes.SplitAfter([]byte(strings.Join(rec.writes, "")), []byte("\n"))
+	require.Equal(t, []byte{}, lines[len(lines)-1], "output should end with a newline")
+	lines = lines[:len(lines)-1]
+	require.Len(t, lines, 2)
+	for i, line := range lines {
+		require.Equal(t, rec.writes[i], string(line))
+		require.Equal(t, 1, bytes.Count(line, []byte("\n")), "line should contain exactly one newline")
+		require.Equal(t, 0, bytes.Count(line, []byte("\r")), "line should not contain a carriage return")
+	}
+
+	var cm unstructured.Unstructured
+	require.NoError(t, json.Unmarshal(lines[0], &cm.Object))
+	data, _, _ := unstructured.NestedString(cm.Object, "data", "config.yaml")
+	require.Equal(t, "multi\nline\r\nvalue\n", data)
+}
+
+// nameEncoder writes the name of every object on a separate line.
+type nameEncoder struct{}
+
+func (nameEncoder) Encode(obj *unstructured.Unstructured, w io.Writer) error {
+	_, err := io.WriteString(w, obj.GetName()+"\n")
+	return err
+}
+
+func Test_DumpToWriterWithEncoder(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpToWriterWithEncoder(&b, nameEncoder{})
+
+	require.NoError(t,
+		subject(&unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "test-pod"}}},
+				{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "test-pod-2"}}},
+			},
+		}),
+	)
+
+	require.Equal(t, "test-pod\ntest-pod-2\n", b.String())
+}
+
+// decodeYAMLNames decodes a multi document YAML stream and returns the names of the objects.
+func decodeYAMLNames(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	var names []string
+	yr := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := yr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		var obj unstructured.Unstructured
+		require.NoError(t, yaml.Unmarshal(doc, &obj.Object))
+		names = append(names, obj.GetName())
+	}
+	return names
+}
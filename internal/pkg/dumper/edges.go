@@ -0,0 +1,88 @@
+package dumper
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// EdgesFile is the name of the owner reference graph written by the DirDumper if enabled.
+const EdgesFile = "edges.json"
+
+// Edges is the graph of the owner references of the dumped objects.
+type Edges struct {
+	// Edges are sorted by owner UID, then child UID.
+	Edges []Edge `json:"edges"`
+}
+
+// Edge is an owner reference from a dumped object, the child, to its owner.
+// The owner is not necessarily part of the dump.
+type Edge struct {
+	OwnerUID  string `json:"ownerUID"`
+	OwnerKind string `json:"ownerKind"`
+	ChildUID  string `json:"childUID"`
+	ChildKind string `json:"childKind"`
+}
+
+// ownerEdges returns the edges of the owner references of the object.
+// Objects and owner references without a UID are ignored.
+func ownerEdges(o unstructured.Unstructured) []Edge {
+	if o.GetUID() == "" {
+		return nil
+	}
+	var edges []Edge
+	for _, ref := range o.GetOwnerReferences() {
+		if ref.UID == "" {
+			continue
+		}
+		edges = append(edges, Edge{
+			OwnerUID:  string(ref.UID),
+			OwnerKind: ref.Kind,
+			ChildUID:  string(o.GetUID()),
+			ChildKind: o.GetKind(),
+		})
+	}
+	return edges
+}
+
+// readEdges reads the edges file at the given path.
+func readEdges(path string) (Edges, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Edges{}, fmt.Errorf("failed to read edges: %w", err)
+	}
+	var e Edges
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return Edges{}, fmt.Errorf("failed to parse edges %q: %w", path, err)
+	}
+	return e, nil
+}
+
+// writeEdges writes the recorded edges as indented JSON.
+func (d *DirDumper) writeEdges() error {
+	e := Edges{Edges: d.edges.UnsortedList()}
+	slices.SortFunc(e.Edges, func(a, b Edge) int {
+		return cmp.Or(
+			strings.Compare(a.OwnerUID, b.OwnerUID),
+			strings.Compare(a.ChildUID, b.ChildUID),
+		)
+	})
+	path := filepath.Join(d.dir, EdgesFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create edges: %w", err)
+	}
+	enc := json.NewEncoder(d.metrics.Writer(f))
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(e); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write edges: %w", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,64 @@
+package dumper_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_DirDumper_Edges(t *testing.T) {
+	tdir := t.TempDir()
+
+	obj := func(kind, name, uid string, owners ...map[string]any) unstructured.Unstructured {
+		refs := make([]any, len(owners))
+		for i, o := range owners {
+			refs[i] = o
+		}
+		return unstructured.Unstructured{Object: map[string]any{
+			"kind":       kind,
+			"apiVersion": "v1",
+			"metadata": map[string]any{
+				"name":            name,
+				"namespace":       "test-ns",
+				"uid":             uid,
+				"ownerReferences": refs,
+			},
+		}}
+	}
+	owner := func(kind, uid string) map[string]any {
+		return map[string]any{"apiVersion": "apps/v1", "kind": kind, "name": "owner", "uid": uid}
+	}
+
+	for i, items := range [][]unstructured.Unstructured{
+		{
+			obj("Pod", "pod-a", "pod-a-uid", owner("ReplicaSet", "rs-uid")),
+			obj("Pod", "pod-b", "pod-b-uid", owner("ReplicaSet", "rs-uid"), owner("Job", "")),
+		},
+		{
+			obj("ConfigMap", "config", "config-uid"),
+			obj("ConfigMap", "no-uid", "", owner("ReplicaSet", "rs-uid")),
+			obj("Secret", "secret", "secret-uid", owner("Deployment", "deploy-uid")),
+		},
+	} {
+		subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Edges: true, Append: i > 0})
+		require.NoError(t, err)
+		require.NoError(t, subject.Dump(&unstructured.UnstructuredList{Items: items}))
+		require.NoError(t, subject.Close())
+	}
+
+	raw, err := os.ReadFile(filepath.Join(tdir, dumper.EdgesFile))
+	require.NoError(t, err)
+	var edges dumper.Edges
+	require.NoError(t, json.Unmarshal(raw, &edges))
+	require.Equal(t, []dumper.Edge{
+		{OwnerUID: "deploy-uid", OwnerKind: "Deployment", ChildUID: "secret-uid", ChildKind: "Secret"},
+		{OwnerUID: "rs-uid", OwnerKind: "ReplicaSet", ChildUID: "pod-a-uid", ChildKind: "Pod"},
+		{OwnerUID: "rs-uid", OwnerKind: "ReplicaSet", ChildUID: "pod-b-uid", ChildKind: "Pod"},
+	}, edges.Edges, "edges without UIDs should be skipped and the existing edges kept with Append")
+}
@@ -0,0 +1,63 @@
+package dumper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrorsFile is the name of the file the DirDumper writes the failures of the dump to if enabled.
+const ErrorsFile = "errors.json"
+
+// Errors are the failures of a dump, see DirDumperOptions.Errors.
+type Errors struct {
+	Errors []ErrorRecord `json:"errors"`
+}
+
+// ErrorRecord is a failure of a dump.
+type ErrorRecord struct {
+	// Resource is the failed resource, e.g. apps/v1, Resource=deployments.
+	// It is empty for failures not caused by a single resource.
+	Resource string `json:"resource,omitempty"`
+	// Error is the error message.
+	Error string `json:"error"`
+	// Time is when the failure was encountered.
+	Time time.Time `json:"time"`
+}
+
+// RecordErrors records failures of the dump to be written to the errors file on Close.
+// It does nothing if DirDumperOptions.Errors is not set.
+func (d *DirDumper) RecordErrors(records ...ErrorRecord) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.errors != nil {
+		d.errors = append(d.errors, records...)
+	}
+}
+
+// writeErrors writes the recorded failures as indented JSON.
+// Without failures an errors file of a previous dump is removed.
+func (d *DirDumper) writeErrors() error {
+	path := filepath.Join(d.dir, ErrorsFile)
+	if len(d.errors) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove errors file: %w", err)
+		}
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create errors file: %w", err)
+	}
+	enc := json.NewEncoder(d.metrics.Writer(f))
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(Errors{Errors: d.errors}); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write errors file: %w", err)
+	}
+	return f.Close()
+}
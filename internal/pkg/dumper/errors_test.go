@@ -0,0 +1,44 @@
+package dumper_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_DirDumper_Errors(t *testing.T) {
+	tdir := t.TempDir()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	records := []dumper.ErrorRecord{
+		{Error: "failed to discover group metrics.k8s.io/v1beta1", Time: now},
+		{Resource: "apps/v1, Resource=deployments", Error: "forbidden", Time: now.Add(time.Second)},
+	}
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Errors: true})
+	require.NoError(t, err)
+	subject.RecordErrors(records...)
+	require.NoError(t, subject.Close())
+
+	raw, err := os.ReadFile(filepath.Join(tdir, dumper.ErrorsFile))
+	require.NoError(t, err)
+	var errs dumper.Errors
+	require.NoError(t, json.Unmarshal(raw, &errs))
+	require.Equal(t, records, errs.Errors)
+
+	subject, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Errors: true})
+	require.NoError(t, err)
+	require.NoError(t, subject.Close())
+	require.NoFileExists(t, filepath.Join(tdir, dumper.ErrorsFile), "the errors of the previous dump should be removed")
+
+	subject, err = dumper.NewDirDumper(tdir, dumper.DirDumperOptions{})
+	require.NoError(t, err)
+	subject.RecordErrors(records...)
+	require.NoError(t, subject.Close())
+	require.NoFileExists(t, filepath.Join(tdir, dumper.ErrorsFile), "no errors file should be written if not enabled")
+}
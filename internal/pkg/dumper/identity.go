@@ -0,0 +1,100 @@
+package dumper
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// IdentityFormat is the serialization format of object identities.
+type IdentityFormat string
+
+const (
+	IdentityFormatNDJSON IdentityFormat = "ndjson"
+	IdentityFormatCSV    IdentityFormat = "csv"
+)
+
+// ParseIdentityFormat parses the given string into an IdentityFormat.
+// An error is returned if the format is unknown.
+func ParseIdentityFormat(s string) (IdentityFormat, error) {
+	switch f := IdentityFormat(s); f {
+	case IdentityFormatNDJSON, IdentityFormatCSV:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown identity format %q, must be one of %q, %q", s, IdentityFormatNDJSON, IdentityFormatCSV)
+}
+
+// Identity identifies a dumped object without its contents.
+type Identity struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// IdentityOf returns the identity of the object.
+func IdentityOf(o *unstructured.Unstructured) Identity {
+	return Identity{
+		APIVersion: o.GetAPIVersion(),
+		Kind:       o.GetKind(),
+		Namespace:  o.GetNamespace(),
+		Name:       o.GetName(),
+		UID:        string(o.GetUID()),
+	}
+}
+
+// identityCSVHeader is the header of DumpIdentitiesCSV.
+var identityCSVHeader = []string{"apiVersion", "kind", "namespace", "name", "uid"}
+
+// DumpIdentitiesToWriter writes the identity of every object in the list to the writer in the given format.
+// The object contents are dropped, which allows a cheap inventory of the cluster.
+// DumpIdentitiesNDJSON is used for unknown formats.
+func DumpIdentitiesToWriter(w io.Writer, f IdentityFormat) DumperFunc {
+	if f == IdentityFormatCSV {
+		return DumpIdentitiesCSV(w)
+	}
+	return DumpIdentitiesNDJSON(w)
+}
+
+// DumpIdentitiesNDJSON writes the identity of every object in the list to the writer as a JSON object per line.
+func DumpIdentitiesNDJSON(w io.Writer) DumperFunc {
+	return func(l *unstructured.UnstructuredList) error {
+		for i := range l.Items {
+			b, err := json.Marshal(IdentityOf(&l.Items[i]))
+			if err != nil {
+				return fmt.Errorf("failed to encode identity: %w", err)
+			}
+			if _, err := w.Write(append(b, '\n')); err != nil {
+				return fmt.Errorf("failed to write identity: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// DumpIdentitiesCSV writes the identity of every object in the list to the writer as a CSV record.
+// The header apiVersion,kind,namespace,name,uid is written before the first record.
+func DumpIdentitiesCSV(w io.Writer) DumperFunc {
+	cw := csv.NewWriter(w)
+	var header sync.Once
+	return func(l *unstructured.UnstructuredList) error {
+		header.Do(func() {
+			cw.Write(identityCSVHeader)
+		})
+		for i := range l.Items {
+			id := IdentityOf(&l.Items[i])
+			cw.Write([]string{id.APIVersion, id.Kind, id.Namespace, id.Name, id.UID})
+		}
+		// Write errors are sticky and returned by Error.
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("failed to write identities: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package dumper_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func identityTestList() *unstructured.UnstructuredList {
+	return &unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "ConfigMap",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-cm",
+						"namespace": "test-ns",
+						"uid":       "1234",
+					},
+					"data": map[string]interface{}{
+						"key": "value",
+					},
+				},
+			},
+			{
+				Object: map[string]interface{}{
+					"kind":       "ClusterRole",
+					"apiVersion": "rbac.authorization.k8s.io/v1",
+					"metadata": map[string]interface{}{
+						"name": "test-role",
+						"uid":  "5678",
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_DumpIdentitiesNDJSON(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpIdentitiesToWriter(&b, dumper.IdentityFormatNDJSON)
+	require.NoError(t, subject(identityTestList()))
+
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	require.Equal(t, []string{
+		`{"apiVersion":"v1","kind":"ConfigMap","namespace":"test-ns","name":"test-cm","uid":"1234"}`,
+		`{"apiVersion":"rbac.authorization.k8s.io/v1","kind":"ClusterRole","name":"test-role","uid":"5678"}`,
+	}, lines)
+
+	var id dumper.Identity
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &id))
+	require.Equal(t, dumper.Identity{APIVersion: "v1", Kind: "ConfigMap", Namespace: "test-ns", Name: "test-cm", UID: "1234"}, id)
+}
+
+func Test_DumpIdentitiesCSV(t *testing.T) {
+	var b bytes.Buffer
+
+	subject := dumper.DumpIdentitiesToWriter(&b, dumper.IdentityFormatCSV)
+	require.NoError(t, subject(identityTestList()))
+	require.NoError(t, subject(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{{Object: map[string]interface{}{
+			"kind":       "Pod",
+			"apiVersion": "v1",
+			"metadata":   map[string]interface{}{"name": "a,b", "namespace": "test-ns", "uid": "9"},
+		}}},
+	}))
+
+	records, err := csv.NewReader(&b).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"apiVersion", "kind", "namespace", "name", "uid"},
+		{"v1", "ConfigMap", "test-ns", "test-cm", "1234"},
+		{"rbac.authorization.k8s.io/v1", "ClusterRole", "", "test-role", "5678"},
+		{"v1", "Pod", "test-ns", "a,b", "9"},
+	}, records, "the header should only be written once")
+}
+
+func Test_ParseIdentityFormat(t *testing.T) {
+	f, err := dumper.ParseIdentityFormat("csv")
+	require.NoError(t, err)
+	require.Equal(t, dumper.IdentityFormatCSV, f)
+
+	_, err = dumper.ParseIdentityFormat("xml")
+	require.Error(t, err)
+}
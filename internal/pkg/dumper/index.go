@@ -0,0 +1,166 @@
+package dumper
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// IndexFile is the name of the HTML index written by the DirDumper if enabled.
+const IndexFile = "index.html"
+
+// clusterScopedIndexName is the heading of cluster-scoped objects in the HTML index.
+const clusterScopedIndexName = "Cluster-scoped"
+
+// htmlIndex records the files objects were written to, grouped by namespace and kind.
+type htmlIndex struct {
+	// objects is the number of objects per namespace and kind.
+	objects map[indexKey]int
+	// files is the number of objects per namespace and kind written to a file, by relative path.
+	files map[indexKey]map[string]int
+}
+
+type indexKey struct {
+	namespace string
+	kind      string
+}
+
+func newHTMLIndex() *htmlIndex {
+	return &htmlIndex{
+		objects: make(map[indexKey]int),
+		files:   make(map[indexKey]map[string]int),
+	}
+}
+
+// record records an object of the given namespace and kind written to the files at the relative paths.
+func (i *htmlIndex) record(namespace, kind string, rels []string) {
+	k := indexKey{namespace: namespace, kind: kind}
+	i.objects[k]++
+	files, ok := i.files[k]
+	if !ok {
+		files = make(map[string]int)
+		i.files[k] = files
+	}
+	for _, rel := range rels {
+		files[rel]++
+	}
+}
+
+type indexNamespace struct {
+	Name  string
+	Count int
+	Kinds []indexKind
+}
+
+type indexKind struct {
+	Name  string
+	Count int
+	Files []indexFile
+}
+
+type indexFile struct {
+	Path  string
+	Count int
+}
+
+// namespaces returns the recorded files sorted by namespace, kind, and path.
+// Cluster-scoped objects come first.
+func (i *htmlIndex) namespaces() []indexNamespace {
+	keys := make([]indexKey, 0, len(i.objects))
+	for k := range i.objects {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b indexKey) int {
+		if c := strings.Compare(a.namespace, b.namespace); c != 0 {
+			return c
+		}
+		return strings.Compare(a.kind, b.kind)
+	})
+
+	var nss []indexNamespace
+	for j, k := range keys {
+		if j == 0 || keys[j-1].namespace != k.namespace {
+			name := k.namespace
+			if name == "" {
+				name = clusterScopedIndexName
+			}
+			nss = append(nss, indexNamespace{Name: name})
+		}
+		ns := &nss[len(nss)-1]
+		kind := indexKind{Name: k.kind, Count: i.objects[k]}
+		for path, count := range i.files[k] {
+			kind.Files = append(kind.Files, indexFile{Path: path, Count: count})
+		}
+		slices.SortFunc(kind.Files, func(a, b indexFile) int { return strings.Compare(a.Path, b.Path) })
+		ns.Kinds = append(ns.Kinds, kind)
+		ns.Count += kind.Count
+	}
+	return nss
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Object dump</title>
+</head>
+<body>
+<h1>Object dump</h1>
+{{- range . }}
+<h2>{{ .Name }} ({{ .Count }})</h2>
+<ul>
+{{- range .Kinds }}
+<li>{{ .Name }} ({{ .Count }})
+<ul>
+{{- range .Files }}
+<li><a href="{{ .Path }}">{{ .Path }}</a> ({{ .Count }})</li>
+{{- end }}
+</ul>
+</li>
+{{- end }}
+</ul>
+{{- end }}
+</body>
+</html>
+`))
+
+// encode writes the index as HTML.
+func (i *htmlIndex) encode(w io.Writer) error {
+	return indexTemplate.Execute(w, i.namespaces())
+}
+
+// recordIndex records the object written to the given paths in the index, if enabled.
+func (d *DirDumper) recordIndex(o unstructured.Unstructured, paths ...string) error {
+	if d.index == nil || len(paths) == 0 {
+		return nil
+	}
+	rels := make([]string, 0, len(paths))
+	for _, path := range paths {
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path of %q: %w", path, err)
+		}
+		rels = append(rels, filepath.ToSlash(rel))
+	}
+	d.index.record(o.GetNamespace(), d.kindName(o), rels)
+	return nil
+}
+
+func (d *DirDumper) writeIndex() error {
+	path := filepath.Join(d.dir, IndexFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+	if err := d.index.encode(d.metrics.Writer(f)); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return f.Close()
+}
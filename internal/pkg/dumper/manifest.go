@@ -0,0 +1,97 @@
+package dumper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// ManifestFile is the name of the manifest written by the dumpers if enabled.
+const ManifestFile = "manifest.json"
+
+// Manifest lists the files of a dump with their checksums.
+// It is used to verify the integrity of a dump.
+type Manifest struct {
+	// Files are the written files sorted by path.
+	Files []ManifestEntry `json:"files"`
+	// UIDs are the sorted UIDs of the dumped objects.
+	// They allow a later dump to skip the objects of this dump, see DiscoveryOptions.ExcludeUIDs.
+	UIDs []string `json:"uids,omitempty"`
+	// ServerVersion is the version of the API server the objects were dumped from, e.g. v1.31.0.
+	// Empty if unknown.
+	ServerVersion string `json:"serverVersion,omitempty"`
+}
+
+// ReadManifest reads the manifest at the given path.
+func ReadManifest(path string) (Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest %q: %w", path, err)
+	}
+	return m, nil
+}
+
+// ManifestEntry is a file in the manifest.
+type ManifestEntry struct {
+	// Path is the slash separated path of the file relative to the dump directory or the path in the tar archive.
+	Path string `json:"path"`
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+	// SHA256 is the hex encoded SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// checksumWriter computes the checksum and size of the data written through it.
+type checksumWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: w, h: sha256.New()}
+}
+
+// Write implements io.Writer.
+// Only bytes successfully written to the underlying writer are added to the checksum.
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// entry returns the manifest entry for the data written so far.
+func (c *checksumWriter) entry(path string) ManifestEntry {
+	return ManifestEntry{
+		Path:   path,
+		Size:   c.n,
+		SHA256: hex.EncodeToString(c.h.Sum(nil)),
+	}
+}
+
+// encodeManifest writes the manifest of the given entries, UIDs, and server version as indented JSON.
+func encodeManifest(w io.Writer, entries map[string]ManifestEntry, uids sets.Set[string], serverVersion string) error {
+	m := Manifest{Files: make([]ManifestEntry, 0, len(entries)), UIDs: sets.List(uids), ServerVersion: serverVersion}
+	for _, e := range entries {
+		m.Files = append(m.Files, e)
+	}
+	slices.SortFunc(m.Files, func(a, b ManifestEntry) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
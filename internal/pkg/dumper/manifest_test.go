@@ -0,0 +1,108 @@
+package dumper_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+var manifestTestList = &unstructured.UnstructuredList{
+	Items: []unstructured.Unstructured{
+		{
+			Object: map[string]interface{}{
+				"kind":       "Pod",
+				"apiVersion": "v1",
+				"metadata": map[string]interface{}{
+					"name":      "test-pod",
+					"namespace": "test-ns",
+					"uid":       "6b1d9c1e-3c0a-4c1e-9a5e-2f6e1b0d7a11",
+				},
+			},
+		},
+	},
+}
+
+func Test_DirDumper_Manifest(t *testing.T) {
+	tdir, err := os.MkdirTemp(".", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+
+	for _, appendFiles := range []bool{false, true} {
+		subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Gzip: true, Manifest: true, Append: appendFiles})
+		require.NoError(t, err)
+		subject.SetServerVersion("v1.31.0")
+		require.NoError(t, subject.Dump(manifestTestList))
+		require.NoError(t, subject.Close())
+	}
+
+	b, err := os.ReadFile(filepath.Join(tdir, dumper.ManifestFile))
+	require.NoError(t, err)
+	var m dumper.Manifest
+	require.NoError(t, json.Unmarshal(b, &m))
+
+	var paths []string
+	for _, e := range m.Files {
+		paths = append(paths, e.Path)
+		content, err := os.ReadFile(filepath.Join(tdir, e.Path))
+		require.NoError(t, err)
+		sum := sha256.Sum256(content)
+		require.Equal(t, hex.EncodeToString(sum[:]), e.SHA256, "checksum of %s should include appended contents", e.Path)
+		require.Equal(t, int64(len(content)), e.Size)
+	}
+	require.Equal(t, []string{
+		"objects-Pod.json.gz",
+		"split/test-ns/Pod.json.gz",
+		"split/test-ns/__all__.json.gz",
+	}, paths)
+	require.Equal(t, []string{"6b1d9c1e-3c0a-4c1e-9a5e-2f6e1b0d7a11"}, m.UIDs)
+	require.Equal(t, "v1.31.0", m.ServerVersion)
+
+	read, err := dumper.ReadManifest(filepath.Join(tdir, dumper.ManifestFile))
+	require.NoError(t, err)
+	require.Equal(t, m, read)
+}
+
+func Test_TarDumper_Manifest(t *testing.T) {
+	var b bytes.Buffer
+	subject := dumper.NewTarDumper(&b, dumper.TarDumperOptions{Manifest: true})
+	subject.SetServerVersion("v1.31.0")
+	require.NoError(t, subject.Dump(manifestTestList))
+	require.NoError(t, subject.Close())
+
+	tr := tar.NewReader(&b)
+	checksums := map[string]string{}
+	var m dumper.Manifest
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		if h.Name == dumper.ManifestFile {
+			require.NoError(t, json.Unmarshal(content, &m))
+			continue
+		}
+		sum := sha256.Sum256(content)
+		checksums[h.Name] = hex.EncodeToString(sum[:])
+	}
+
+	require.Len(t, m.Files, 1)
+	require.Equal(t, "core/v1/Pod/test-ns/test-pod.json", m.Files[0].Path)
+	require.Equal(t, checksums[m.Files[0].Path], m.Files[0].SHA256)
+	require.Equal(t, []string{"6b1d9c1e-3c0a-4c1e-9a5e-2f6e1b0d7a11"}, m.UIDs)
+	require.Equal(t, "v1.31.0", m.ServerVersion)
+}
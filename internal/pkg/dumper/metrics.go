@@ -0,0 +1,52 @@
+package dumper
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the prometheus metrics updated by the dumpers.
+// Must be initialized with NewMetrics.
+// A nil *Metrics disables the metrics.
+type Metrics struct {
+	bytesWritten prometheus.Counter
+}
+
+// NewMetrics creates the dumper metrics and registers them with reg.
+// The metrics can be shared by multiple dumpers.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		bytesWritten: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "k8s_object_dumper_bytes_written_total",
+			Help: "Number of bytes written by the dumpers, after compression.",
+		}),
+	}
+	if err := reg.Register(m.bytesWritten); err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
+	}
+	return m, nil
+}
+
+// Writer returns a writer counting the bytes written to w.
+// w is returned as is if m is nil.
+func (m *Metrics) Writer(w io.Writer) io.Writer {
+	if m == nil {
+		return w
+	}
+	return &countingWriter{w: w, c: m.bytesWritten}
+}
+
+// countingWriter adds the number of written bytes to a counter.
+type countingWriter struct {
+	w io.Writer
+	c prometheus.Counter
+}
+
+// Write implements io.Writer.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.c.Add(float64(n))
+	return n, err
+}
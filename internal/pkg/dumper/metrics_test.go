@@ -0,0 +1,56 @@
+package dumper_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_Metrics_DirDumper(t *testing.T) {
+	tdir := t.TempDir()
+	reg := prometheus.NewRegistry()
+	m, err := dumper.NewMetrics(reg)
+	require.NoError(t, err)
+
+	subject, err := dumper.NewDirDumper(tdir, dumper.DirDumperOptions{Layout: dumper.LayoutPerKind, Gzip: true, Metrics: m})
+	require.NoError(t, err)
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	fi, err := os.Stat(filepath.Join(tdir, "core_v1_Pod.json.gz"))
+	require.NoError(t, err)
+	count, err := testutil.GatherAndCount(reg, "k8s_object_dumper_bytes_written_total")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	mfs, err := reg.Gather()
+	require.NoError(t, err)
+	require.Equal(t, float64(fi.Size()), mfs[0].GetMetric()[0].GetCounter().GetValue(), "the compressed bytes should be counted")
+}
+
+func Test_Metrics_Register_Twice(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	_, err := dumper.NewMetrics(reg)
+	require.NoError(t, err)
+	_, err = dumper.NewMetrics(reg)
+	require.Error(t, err)
+}
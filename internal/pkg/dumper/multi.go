@@ -0,0 +1,45 @@
+package dumper
+
+import (
+	"io"
+
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// MultiDumper passes every list to multiple dumpers, for example to write to a directory and to stdout at the same time.
+type MultiDumper struct {
+	dumpers []Dumper
+}
+
+// NewMultiDumper returns a MultiDumper passing every list to the dumpers in the given order.
+func NewMultiDumper(dumpers ...Dumper) *MultiDumper {
+	return &MultiDumper{dumpers: dumpers}
+}
+
+// Dump passes the list to all dumpers, also if a dumper fails, and returns their errors combined.
+// Dumpers that modify the list, for example by sorting, affect the dumpers after them.
+// Concurrent calls are only safe if all dumpers are safe for concurrent use.
+func (d *MultiDumper) Dump(l *unstructured.UnstructuredList) error {
+	var errs []error
+	for _, dd := range d.dumpers {
+		if err := dd.Dump(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// Close closes all dumpers implementing io.Closer and returns their errors combined.
+// The MultiDumper cannot be used after it is closed.
+func (d *MultiDumper) Close() error {
+	var errs []error
+	for _, dd := range d.dumpers {
+		if c, ok := dd.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return multierr.Combine(errs...)
+}
@@ -0,0 +1,40 @@
+package dumper_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_MultiDumper(t *testing.T) {
+	var slice dumper.SliceDumper
+	var buf bytes.Buffer
+	array := dumper.DumpToWriterJSONArray(&buf)
+	failing := dumper.DumperFunc(func(*unstructured.UnstructuredList) error { return errors.New("disk full") })
+	subject := dumper.NewMultiDumper(failing, &slice, array)
+
+	err := subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "test-pod",
+						"namespace": "test-ns",
+					},
+				},
+			},
+		},
+	})
+	require.ErrorContains(t, err, "disk full")
+	require.Len(t, slice.Items(), 1, "the dumpers after a failed dumper should still be called")
+
+	require.NoError(t, subject.Close())
+	require.JSONEq(t, `[{"kind":"Pod","apiVersion":"v1","metadata":{"name":"test-pod","namespace":"test-ns"}}]`, buf.String(), "closers should be closed")
+}
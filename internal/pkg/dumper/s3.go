@@ -0,0 +1,194 @@
+//go:build s3
+
+package dumper
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DefaultS3PartSize is the default size of the uploaded parts.
+const DefaultS3PartSize = 16 << 20
+
+// DefaultS3CompleteTimeout is the default maximum time Close waits for the upload to complete.
+const DefaultS3CompleteTimeout = 5 * time.Minute
+
+// S3DumperOptions configures a S3Dumper.
+type S3DumperOptions struct {
+	// Endpoint is the host and optional port of the S3-compatible service, e.g. s3.amazonaws.com.
+	Endpoint string
+	// Insecure uses plain HTTP instead of HTTPS.
+	Insecure bool
+	// Region is the region of the bucket. Looked up if empty.
+	Region string
+	// Bucket is the bucket to upload to.
+	Bucket string
+	// Prefix is prepended to the key of the uploaded object.
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the static credentials used to authenticate.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// Encoder is the encoder of the objects. Defaults to JSONEncoder.
+	Encoder Encoder
+	// Extension is the file extension of the encoded objects, without a leading dot.
+	// Defaults to json.
+	Extension string
+
+	// PartSize is the size of the gzip compressed parts of the multipart upload in bytes.
+	// Must be at least 5 MiB. Defaults to DefaultS3PartSize.
+	PartSize uint64
+
+	// GzipLevel is the gzip compression level, from gzip.BestSpeed to gzip.BestCompression.
+	// Defaults to gzip.DefaultCompression.
+	GzipLevel int
+
+	// CompleteTimeout is the maximum time Close waits for the final part and the completion of the upload.
+	// The upload is aborted if it takes longer. Defaults to DefaultS3CompleteTimeout.
+	CompleteTimeout time.Duration
+
+	// Metrics counts the compressed bytes uploaded. If nil, no metrics are recorded.
+	Metrics *Metrics
+}
+
+// GetPartSize returns the set part size or the default.
+func (opts S3DumperOptions) GetPartSize() uint64 {
+	if opts.PartSize == 0 {
+		return DefaultS3PartSize
+	}
+	return opts.PartSize
+}
+
+// GetGzipLevel returns the set gzip compression level or the default.
+func (opts S3DumperOptions) GetGzipLevel() int {
+	if opts.GzipLevel == 0 {
+		return gzip.DefaultCompression
+	}
+	return opts.GzipLevel
+}
+
+// GetCompleteTimeout returns the set complete timeout or the default.
+func (opts S3DumperOptions) GetCompleteTimeout() time.Duration {
+	if opts.CompleteTimeout == 0 {
+		return DefaultS3CompleteTimeout
+	}
+	return opts.CompleteTimeout
+}
+
+// S3Dumper streams objects to an object in an S3-compatible bucket.
+// All objects are written gzip compressed to a single object named <prefix>objects.<ext>.gz.
+// The object is uploaded in parts while dumping, the upload is completed on Close.
+// Must be initialized with NewS3Dumper.
+// Must be closed after use.
+type S3Dumper struct {
+	enc Encoder
+	pw  *io.PipeWriter
+	bw  *bufio.Writer
+	gz  *gzip.Writer
+	// done receives the result of the upload.
+	done chan error
+	// cancel aborts the upload.
+	cancel          context.CancelFunc
+	completeTimeout time.Duration
+}
+
+// NewS3Dumper creates a new S3Dumper and starts the upload.
+// The upload is aborted if ctx is cancelled, pass a context that is not cancelled with the dump to complete the upload of an interrupted dump on Close.
+func NewS3Dumper(ctx context.Context, opts S3DumperOptions) (*S3Dumper, error) {
+	if err := validateGzipLevel(opts.GzipLevel); err != nil {
+		return nil, err
+	}
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, ""),
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	enc := opts.Encoder
+	if enc == nil {
+		enc = JSONEncoder{}
+	}
+	ext := opts.Extension
+	if ext == "" {
+		ext = string(FormatJSON)
+	}
+	key := fmt.Sprintf("%sobjects.%s.gz", opts.Prefix, ext)
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	d := &S3Dumper{
+		enc:             enc,
+		pw:              pw,
+		done:            make(chan error, 1),
+		cancel:          cancel,
+		completeTimeout: opts.GetCompleteTimeout(),
+	}
+	// The buffer batches the small writes of the compressor into larger writes to the pipe.
+	d.bw = bufio.NewWriterSize(opts.Metrics.Writer(pw), 64<<10)
+	// The level is validated above.
+	d.gz, _ = gzip.NewWriterLevel(d.bw, opts.GetGzipLevel())
+
+	go func() {
+		_, err := client.PutObject(ctx, opts.Bucket, key, pr, -1, minio.PutObjectOptions{
+			ContentType: "application/gzip",
+			PartSize:    opts.GetPartSize(),
+		})
+		if err != nil {
+			err = fmt.Errorf("failed to upload %q to bucket %q: %w", key, opts.Bucket, err)
+		}
+		// Unblock and fail writes if the upload stopped early.
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+
+	return d, nil
+}
+
+// Dump writes the objects in the list to the upload.
+// If an object cannot be written, an error is returned.
+// This method is not safe for concurrent use.
+func (d *S3Dumper) Dump(l *unstructured.UnstructuredList) error {
+	for i := range l.Items {
+		if err := d.enc.Encode(&l.Items[i], d.gz); err != nil {
+			return fmt.Errorf("failed to write object to upload: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close flushes the final part and completes the upload.
+// The upload is aborted if it does not complete within S3DumperOptions.CompleteTimeout.
+// The S3Dumper cannot be used after it is closed.
+func (d *S3Dumper) Close() error {
+	defer d.cancel()
+	// Aborting the upload also fails writes blocked on the pipe.
+	timeout := time.AfterFunc(d.completeTimeout, d.cancel)
+	var errs []error
+	if err := d.gz.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close gzip writer: %w", err))
+	}
+	if err := d.bw.Flush(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to flush upload buffer: %w", err))
+	}
+	d.pw.Close()
+	if err := <-d.done; err != nil {
+		errs = append(errs, err)
+	}
+	if !timeout.Stop() {
+		errs = append(errs, fmt.Errorf("upload did not complete within %s", d.completeTimeout))
+	}
+	return multierr.Combine(errs...)
+}
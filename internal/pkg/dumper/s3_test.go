@@ -0,0 +1,168 @@
+//go:build s3
+
+package dumper_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+// fakeS3 implements the subset of the S3 API used for streaming uploads.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	parts   map[string]map[int][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = decodeAWSChunked(body)
+	}
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := strconv.Itoa(len(s.parts))
+		s.parts[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		s.parts[q.Get("uploadId")][n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		parts := s.parts[q.Get("uploadId")]
+		nums := make([]int, 0, len(parts))
+		for n := range parts {
+			nums = append(nums, n)
+		}
+		sort.Ints(nums)
+		var obj []byte
+		for _, n := range nums {
+			obj = append(obj, parts[n]...)
+		}
+		s.objects[key] = obj
+		bucket, name, _ := strings.Cut(key, "/")
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>\"x\"</ETag></CompleteMultipartUploadResult>", bucket, name)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+		w.Header().Set("ETag", `"x"`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+		xml.NewEncoder(w).Encode(struct {
+			XMLName xml.Name `xml:"Error"`
+			Code    string
+		}{Code: "NotImplemented"})
+	}
+}
+
+// decodeAWSChunked decodes a body with the chunked upload signature, ignoring the signatures.
+func decodeAWSChunked(b []byte) []byte {
+	var out []byte
+	for len(b) > 0 {
+		header, rest, ok := bytes.Cut(b, []byte("\r\n"))
+		if !ok {
+			break
+		}
+		sizeHex, _, _ := bytes.Cut(header, []byte(";"))
+		size, err := strconv.ParseInt(string(sizeHex), 16, 64)
+		if err != nil || size == 0 {
+			break
+		}
+		out = append(out, rest[:size]...)
+		b = bytes.TrimPrefix(rest[size:], []byte("\r\n"))
+	}
+	return out
+}
+
+func Test_S3Dumper(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	subject, err := dumper.NewS3Dumper(context.Background(), dumper.S3DumperOptions{
+		Endpoint:        u.Host,
+		Insecure:        true,
+		Region:          "us-east-1",
+		Bucket:          "backups",
+		Prefix:          "cluster-a/",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+
+	for i := range 3 {
+		require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+			Items: []unstructured.Unstructured{
+				{
+					Object: map[string]interface{}{
+						"kind":       "Pod",
+						"apiVersion": "v1",
+						"metadata": map[string]interface{}{
+							"name":      fmt.Sprintf("test-pod-%d", i),
+							"namespace": "test-ns",
+						},
+					},
+				},
+			},
+		}))
+	}
+	require.NoError(t, subject.Close())
+
+	obj, ok := fake.objects["backups/cluster-a/objects.json.gz"]
+	require.True(t, ok, "object should be uploaded, got %v", fake.objects)
+	zr, err := gzip.NewReader(bytes.NewReader(obj))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	require.Equal(t, 3, bytes.Count(content, []byte("\n")))
+	require.Contains(t, string(content), "test-pod-2")
+}
+
+func Test_S3Dumper_CompleteTimeout(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, parts: map[string]map[int][]byte{}}
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The upload never completes.
+		<-release
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	defer close(release)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	subject, err := dumper.NewS3Dumper(context.Background(), dumper.S3DumperOptions{
+		Endpoint:        u.Host,
+		Insecure:        true,
+		Region:          "us-east-1",
+		Bucket:          "backups",
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		CompleteTimeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.ErrorContains(t, subject.Close(), "upload did not complete within 50ms")
+}
@@ -0,0 +1,32 @@
+package dumper
+
+import (
+	"slices"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// SliceDumper collects objects in memory.
+// It is safe for concurrent use.
+// The zero value is ready to use.
+type SliceDumper struct {
+	mu    sync.Mutex
+	items []unstructured.Unstructured
+}
+
+// Dump appends the objects in the list to the collected objects.
+func (d *SliceDumper) Dump(l *unstructured.UnstructuredList) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.items = append(d.items, l.Items...)
+	return nil
+}
+
+// Items returns the collected objects in the order they were dumped.
+// The returned slice is a copy, the objects are not deep-copied.
+func (d *SliceDumper) Items() []unstructured.Unstructured {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.items)
+}
@@ -0,0 +1,47 @@
+package dumper_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_SliceDumper(t *testing.T) {
+	var subject dumper.SliceDumper
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+				Items: []unstructured.Unstructured{
+					{
+						Object: map[string]interface{}{
+							"kind":       "Pod",
+							"apiVersion": "v1",
+							"metadata": map[string]interface{}{
+								"name":      fmt.Sprintf("test-pod-%d", i),
+								"namespace": "test-ns",
+							},
+						},
+					},
+				},
+			}))
+		}()
+	}
+	wg.Wait()
+
+	var names []string
+	for _, o := range subject.Items() {
+		names = append(names, o.GetName())
+	}
+	require.Len(t, names, 10)
+	require.Contains(t, names, "test-pod-0")
+	require.Contains(t, names, "test-pod-9")
+}
@@ -0,0 +1,185 @@
+package dumper
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/clock"
+)
+
+// TarDumper writes objects to a tar archive.
+// Every object is written as a separate file named <group>/<version>/<kind>/[<namespace>/]<name>.json.
+// The core group is written as "core".
+// Must be initialized with NewTarDumper.
+// Must be closed after use.
+type TarDumper struct {
+	tw *tar.Writer
+	// bw buffers the writes to the underlying writer if buffering is enabled.
+	bw        *bufio.Writer
+	sharedBuf *bytes.Buffer
+
+	// manifest are the checksums of the written files. Nil if no manifest is written.
+	manifest map[string]ManifestEntry
+	// uids are the UIDs of the written objects for the manifest.
+	uids sets.Set[string]
+	// serverVersion is recorded in the manifest.
+	serverVersion string
+	clock         clock.PassiveClock
+}
+
+// TarDumperOptions configures a TarDumper.
+type TarDumperOptions struct {
+	// Manifest writes a manifest.json file listing every written file with its size and SHA-256 checksum on Close.
+	// The UIDs of the written objects are listed too.
+	Manifest bool
+
+	// BufferSize is the size of the write buffer in front of the underlying writer.
+	// The tar header, the object, and the padding of every file are otherwise written separately.
+	// Defaults to DefaultBufferSize. A negative size disables buffering.
+	BufferSize int
+
+	// Clock is the time source of the modification time of the manifest and of objects without a creation timestamp.
+	// Defaults to the real clock.
+	Clock clock.PassiveClock
+}
+
+// GetBufferSize returns the set buffer size, the default, or zero if buffering is disabled.
+func (opts TarDumperOptions) GetBufferSize() int {
+	return bufferSize(opts.BufferSize)
+}
+
+// GetClock returns the set clock or the real clock.
+func (opts TarDumperOptions) GetClock() clock.PassiveClock {
+	if opts.Clock == nil {
+		return clock.RealClock{}
+	}
+	return opts.Clock
+}
+
+// NewTarDumper creates a new TarDumper that writes a tar archive to the given writer.
+func NewTarDumper(w io.Writer, opts TarDumperOptions) *TarDumper {
+	d := &TarDumper{sharedBuf: new(bytes.Buffer), clock: opts.GetClock()}
+	if size := opts.GetBufferSize(); size > 0 {
+		d.bw = bufio.NewWriterSize(w, size)
+		w = d.bw
+	}
+	d.tw = tar.NewWriter(w)
+	if opts.Manifest {
+		d.manifest = make(map[string]ManifestEntry)
+		d.uids = sets.New[string]()
+	}
+	return d
+}
+
+// Close writes the manifest, if enabled, and the tar footer, and flushes the buffer.
+// The TarDumper cannot be used after it is closed.
+// The underlying writer is not closed.
+func (d *TarDumper) Close() error {
+	if d.manifest != nil {
+		buf := d.sharedBuf
+		buf.Reset()
+		if err := encodeManifest(buf, d.manifest, d.uids, d.serverVersion); err != nil {
+			return fmt.Errorf("failed to encode manifest: %w", err)
+		}
+		if err := d.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     ManifestFile,
+			Size:     int64(buf.Len()),
+			Mode:     0644,
+			ModTime:  d.clock.Now(),
+		}); err != nil {
+			return fmt.Errorf("failed to write tar header for %q: %w", ManifestFile, err)
+		}
+		if _, err := d.tw.Write(buf.Bytes()); err != nil {
+			return fmt.Errorf("failed to write %q to tar: %w", ManifestFile, err)
+		}
+	}
+	if err := d.tw.Close(); err != nil {
+		return err
+	}
+	if d.bw != nil {
+		if err := d.bw.Flush(); err != nil {
+			return fmt.Errorf("failed to flush tar archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetServerVersion sets the version of the API server recorded in the manifest, see DiscoveryOptions.ServerVersion.
+// It must not be called concurrently with Dump or Close.
+func (d *TarDumper) SetServerVersion(v string) {
+	d.serverVersion = v
+}
+
+// Dump writes the objects in the list to the tar archive.
+// The modification time of the files is the creation timestamp of the object or the current time if not set.
+// If an object cannot be written, an error is returned.
+// This method is not safe for concurrent use.
+func (d *TarDumper) Dump(l *unstructured.UnstructuredList) error {
+	buf := d.sharedBuf
+	enc := JSONEncoder{}
+	var errs []error
+	for _, o := range l.Items {
+		buf.Reset()
+		if err := enc.Encode(&o, buf); err != nil {
+			errs = append(errs, fmt.Errorf("failed to encode object: %w", err))
+			continue
+		}
+
+		modTime := o.GetCreationTimestamp().Time
+		if modTime.IsZero() {
+			modTime = d.clock.Now()
+		}
+		name := tarPath(o)
+		if err := d.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(buf.Len()),
+			Mode:     0644,
+			ModTime:  modTime,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write tar header for %q: %w", name, err))
+			continue
+		}
+		var w io.Writer = d.tw
+		var cw *checksumWriter
+		if d.manifest != nil {
+			cw = newChecksumWriter(d.tw)
+			w = cw
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("failed to write %q to tar: %w", name, err))
+			continue
+		}
+		if cw != nil {
+			d.manifest[name] = cw.entry(name)
+			if uid := o.GetUID(); uid != "" {
+				d.uids.Insert(string(uid))
+			}
+		}
+	}
+	// The objects of a list are passed to the underlying writer when Dump returns.
+	if d.bw != nil {
+		if err := d.bw.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush tar archive: %w", err))
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// tarPath returns the path of the object in the tar archive.
+func tarPath(o unstructured.Unstructured) string {
+	gvk := o.GroupVersionKind()
+	group := gvk.Group
+	if group == "" {
+		group = "core"
+	}
+	return path.Join(group, gvk.Version, gvk.Kind, o.GetNamespace(), o.GetName()+".json")
+}
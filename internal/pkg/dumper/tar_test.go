@@ -0,0 +1,71 @@
+package dumper_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	testingclock "k8s.io/utils/clock/testing"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func Test_TarDumper(t *testing.T) {
+	var b bytes.Buffer
+	now := time.Date(2025, 6, 7, 8, 9, 10, 0, time.UTC)
+	subject := dumper.NewTarDumper(&b, dumper.TarDumperOptions{Clock: testingclock.NewFakePassiveClock(now)})
+
+	require.NoError(t, subject.Dump(&unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":              "test-pod",
+						"namespace":         "test-ns",
+						"creationTimestamp": "2024-01-02T03:04:05Z",
+					},
+				},
+			},
+			{
+				Object: map[string]interface{}{
+					"kind":       "ClusterRole",
+					"apiVersion": "rbac.authorization.k8s.io/v1",
+					"metadata": map[string]interface{}{
+						"name": "cluster-scoped",
+					},
+				},
+			},
+		},
+	}))
+	require.NoError(t, subject.Close())
+
+	tr := tar.NewReader(&b)
+	names := map[string]string{}
+	modTimes := map[string]time.Time{}
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		var obj unstructured.Unstructured
+		require.NoError(t, json.NewDecoder(tr).Decode(&obj.Object))
+		names[h.Name] = obj.GetName()
+		modTimes[h.Name] = h.ModTime
+	}
+
+	require.Equal(t, map[string]string{
+		"core/v1/Pod/test-ns/test-pod.json":                            "test-pod",
+		"rbac.authorization.k8s.io/v1/ClusterRole/cluster-scoped.json": "cluster-scoped",
+	}, names)
+	require.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), modTimes["core/v1/Pod/test-ns/test-pod.json"].UTC())
+	require.Equal(t, now, modTimes["rbac.authorization.k8s.io/v1/ClusterRole/cluster-scoped.json"].UTC(), "objects without a creation timestamp should use the current time")
+}
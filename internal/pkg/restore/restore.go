@@ -0,0 +1,174 @@
+// Restore applies objects dumped by the dumper package to a cluster.
+package restore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// DefaultFieldManager is the field manager used if RestoreOptions.FieldManager is empty.
+const DefaultFieldManager = "k8s-object-dumper"
+
+type RestoreOptions struct {
+	// FieldManager is the field manager of the server-side apply.
+	// Defaults to DefaultFieldManager.
+	FieldManager string
+	// Force takes ownership of fields managed by other field managers instead of failing with a conflict.
+	Force bool
+	// DryRun applies the objects with a server-side dry run, nothing is persisted.
+	DryRun bool
+}
+
+// GetFieldManager returns the field manager or DefaultFieldManager if not set.
+func (opts RestoreOptions) GetFieldManager() string {
+	if opts.FieldManager == "" {
+		return DefaultFieldManager
+	}
+	return opts.FieldManager
+}
+
+// Restore reads the objects of a dump from the reader and applies them to the cluster with server-side apply.
+// The reader may contain NDJSON, YAML documents, a JSON array, or the JSON lists written by DumpToWriter.
+// Completion markers are skipped.
+// The objects are applied in the order of the stream, so namespaces and CustomResourceDefinitions must precede their objects,
+// see DiscoveryOptions.RestoreOrder.
+// The resourceVersion, uid, and managedFields of the objects are removed before applying, the status of resources with a status subresource is not restored.
+// Objects failing to apply are skipped and their errors are returned combined after the whole stream is read.
+func Restore(ctx context.Context, conf *rest.Config, r io.Reader, opts RestoreOptions) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(conf)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	dynClient, err := dynamic.NewForConfig(conf)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return restoreObjects(ctx, restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc)), dynClient, r, opts)
+}
+
+// restoreObjects implements Restore with the given clients.
+// The mapper is expected to refresh itself if a kind is not found, so CustomResourceDefinitions applied earlier in the stream are picked up.
+func restoreObjects(ctx context.Context, mapper meta.RESTMapper, dynClient dynamic.Interface, r io.Reader, opts RestoreOptions) error {
+	dec := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	var errs []error
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			// The position in the stream is lost, the remaining objects can't be read.
+			return multierr.Append(multierr.Combine(errs...), fmt.Errorf("failed to decode object: %w", err))
+		}
+		objs, err := decodeObjects(raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, o := range objs {
+			if err := apply(ctx, mapper, dynClient, o, opts); err != nil {
+				errs = append(errs, fmt.Errorf("failed to restore %s %s: %w", o.GroupVersionKind(), objectName(o), err))
+			}
+		}
+	}
+	return multierr.Combine(errs...)
+}
+
+// decodeObjects decodes a single document of the stream into objects.
+// Lists and arrays are flattened, empty documents and completion markers return no objects.
+func decodeObjects(raw json.RawMessage) ([]*unstructured.Unstructured, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if raw[0] == '[' {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return nil, fmt.Errorf("failed to decode array: %w", err)
+		}
+		var objs []*unstructured.Unstructured
+		for _, e := range elems {
+			o, err := decodeObjects(e)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, o...)
+		}
+		return objs, nil
+	}
+
+	var marker struct {
+		Dump string `json:"_dump"`
+	}
+	if err := json.Unmarshal(raw, &marker); err == nil && marker.Dump != "" {
+		return nil, nil
+	}
+	// UnmarshalJSON keeps integers as int64 instead of float64.
+	o := &unstructured.Unstructured{}
+	if err := o.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("failed to decode object: %w", err)
+	}
+	if !o.IsList() {
+		return []*unstructured.Unstructured{o}, nil
+	}
+	var objs []*unstructured.Unstructured
+	err := o.EachListItem(func(item runtime.Object) error {
+		objs = append(objs, item.(*unstructured.Unstructured))
+		return nil
+	})
+	return objs, err
+}
+
+// apply applies the object with server-side apply.
+func apply(ctx context.Context, mapper meta.RESTMapper, dynClient dynamic.Interface, o *unstructured.Unstructured, opts RestoreOptions) error {
+	gvk := o.GroupVersionKind()
+	m, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return fmt.Errorf("failed to map kind to resource: %w", err)
+	}
+
+	// The resourceVersion and uid would be treated as preconditions, managedFields are rejected by server-side apply.
+	unstructured.RemoveNestedField(o.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(o.Object, "metadata", "uid")
+	unstructured.RemoveNestedField(o.Object, "metadata", "managedFields")
+
+	var ri dynamic.ResourceInterface = dynClient.Resource(m.Resource)
+	if m.Scope.Name() == meta.RESTScopeNameNamespace {
+		ri = dynClient.Resource(m.Resource).Namespace(o.GetNamespace())
+	}
+	_, err = ri.Apply(ctx, o.GetName(), o, opts.applyOptions())
+	return err
+}
+
+// applyOptions returns the options of the server-side apply.
+func (opts RestoreOptions) applyOptions() metav1.ApplyOptions {
+	applyOpts := metav1.ApplyOptions{FieldManager: opts.GetFieldManager(), Force: opts.Force}
+	if opts.DryRun {
+		applyOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	return applyOpts
+}
+
+// objectName returns the name of the object, prefixed with the namespace if namespaced.
+func objectName(o *unstructured.Unstructured) string {
+	if o.GetNamespace() == "" {
+		return o.GetName()
+	}
+	return o.GetNamespace() + "/" + o.GetName()
+}
@@ -0,0 +1,122 @@
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsGVR = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+)
+
+// applied is an object applied to the fake dynamic client.
+type applied struct {
+	resource, namespace, name string
+	obj                       map[string]any
+}
+
+// newFakeClients returns a RESTMapper knowing ConfigMaps and Namespaces and a dynamic client recording the applied objects.
+func newFakeClients(t *testing.T) (meta.RESTMapper, *dynamicfake.FakeDynamicClient, *[]applied) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		configMapsGVR: "ConfigMapList",
+		namespacesGVR: "NamespaceList",
+	})
+	var got []applied
+	client.PrependReactor("patch", "*", func(a k8stesting.Action) (bool, runtime.Object, error) {
+		pa := a.(k8stesting.PatchAction)
+		require.Equal(t, types.ApplyPatchType, pa.GetPatchType())
+		o := map[string]any{}
+		require.NoError(t, json.Unmarshal(pa.GetPatch(), &o))
+		got = append(got, applied{resource: pa.GetResource().Resource, namespace: pa.GetNamespace(), name: pa.GetName(), obj: o})
+		return true, &unstructured.Unstructured{Object: o}, nil
+	})
+	return mapper, client, &got
+}
+
+func Test_restoreObjects(t *testing.T) {
+	streams := map[string]string{
+		"ndjson": `{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"a"}}
+{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm","namespace":"a","resourceVersion":"10","uid":"1234","managedFields":[{"manager":"kubectl"}]},"data":{"k":"v"}}
+{"_dump":"complete","count":2}
+`,
+		"yaml": `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: a
+  resourceVersion: "10"
+  uid: "1234"
+data:
+  k: v
+---
+_dump: complete
+count: 2
+`,
+		"list": `{"apiVersion":"v1","items":[{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"a"}}],"kind":"NamespaceList","metadata":{}}
+{"apiVersion":"v1","items":[{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm","namespace":"a","resourceVersion":"10"},"data":{"k":"v"}}],"kind":"ConfigMapList","metadata":{}}
+`,
+		"array": `[
+{"apiVersion":"v1","kind":"Namespace","metadata":{"name":"a"}},
+{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm","namespace":"a","uid":"1234"},"data":{"k":"v"}}
+]
+`,
+	}
+	for name, stream := range streams {
+		t.Run(name, func(t *testing.T) {
+			mapper, client, got := newFakeClients(t)
+
+			require.NoError(t, restoreObjects(context.Background(), mapper, client, strings.NewReader(stream), RestoreOptions{}))
+			require.Len(t, *got, 2)
+			require.Equal(t, "namespaces", (*got)[0].resource)
+			require.Equal(t, "a", (*got)[0].name)
+			cm := (*got)[1]
+			require.Equal(t, []string{"configmaps", "a", "cm"}, []string{cm.resource, cm.namespace, cm.name})
+			require.Equal(t, map[string]any{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]any{"name": "cm", "namespace": "a"},
+				"data":       map[string]any{"k": "v"},
+			}, cm.obj)
+		})
+	}
+}
+
+func Test_restoreObjects_Errors(t *testing.T) {
+	mapper, client, got := newFakeClients(t)
+	stream := `{"apiVersion":"example.com/v1","kind":"Unknown","metadata":{"name":"u"}}
+{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"cm","namespace":"a"}}
+`
+
+	err := restoreObjects(context.Background(), mapper, client, strings.NewReader(stream), RestoreOptions{})
+	require.ErrorContains(t, err, "failed to restore example.com/v1, Kind=Unknown u")
+	require.Len(t, *got, 1, "objects after a failing object should still be applied")
+}
+
+func Test_RestoreOptions_applyOptions(t *testing.T) {
+	require.Equal(t, metav1.ApplyOptions{FieldManager: DefaultFieldManager}, RestoreOptions{}.applyOptions())
+	require.Equal(t, metav1.ApplyOptions{FieldManager: "restore", Force: true, DryRun: []string{metav1.DryRunAll}},
+		RestoreOptions{FieldManager: "restore", Force: true, DryRun: true}.applyOptions())
+}
@@ -1,62 +1,929 @@
 package main
 
 import (
+	"bufio"
+	"compress/gzip"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/multierr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
 	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
 )
 
+// Exit codes of the dumper, see README.md.
+const (
+	exitOK = 0
+	// exitFailure is returned for invalid flags and if nothing could be dumped.
+	exitFailure = 1
+	// exitPartial is returned if some resources were dumped but others failed or were skipped as forbidden.
+	exitPartial     = 2
+	exitInterrupted = 130
+)
+
 func main() {
+	os.Exit(run())
+}
+
+// run runs the dumper and returns the exit code.
+// It does not call os.Exit so deferred functions always run.
+func run() int {
 	var dir string
+	var format string
+	var gzip bool
+	var gzipLevel int
+	var bufferSize int
+	var tarFile string
+	var layout string
+	var nameTemplate string
+	var manifest bool
+	var htmlIndex bool
+	var edges bool
+	var errorsFile bool
+	var tee bool
+	var completionMarker bool
+	var listOnly bool
+	var listFormat string
+	var checkpointFile string
+	var watermarkFile string
+	var sinceFile string
+	var printStats bool
+	var quiet bool
+	var noProgress bool
+	var noStdoutBuffer bool
+	var skipUnchanged bool
+	var configFile string
+	var resource string
+	var namespace string
+	var logFormat string
 	var batchSize int64
+	var labelSelector string
+	var fieldSelector string
+	var annotationSelector string
+	var excludeFile string
+	var excludeNameRegex string
+	var maxObjectBytes int64
+	var maxObjects int64
+	var concurrency int
+	var namespaceConcurrency int
+	var maxRetries int
+	var retryBackoff time.Duration
+	var dumpRetries int
+	var qps float64
+	var asUser string
+	var asUID string
+	var userAgent string
+	var burst int
+	var discoveryCacheDir string
+	var discoveryCacheTTL time.Duration
+	var refreshDiscovery bool
+	var includeEvents bool
+	var stripManagedFields bool
+	var stripStatus bool
+	var stableOutput bool
+	var sortItems bool
+	var restoreOrder bool
+	var includeSecretData bool
+	var skipForbidden bool
+	var dryRun bool
+	var allVersions bool
+	var timeout time.Duration
+	var watchDuration time.Duration
+	var createdAfter timeFlag
+	var createdBefore timeFlag
+	var scope string
+	var printVersionAndExit bool
+	var metricsAddr string
 	mustExistResources := new(repeatableStringFlag)
 	ignoreResources := new(repeatableRegexpFlag)
+	includeNamespaces := new(repeatableStringFlag)
+	excludeNamespaces := new(repeatableStringFlag)
+	var excludeSystemNamespaces bool
+	var excludeKubeNamespaces bool
+	includeKinds := new(repeatableKindFlag)
+	excludeKinds := new(repeatableKindFlag)
+	redactPaths := new(repeatableStringFlag)
+	excludeOwnedBy := new(repeatableStringFlag)
+	includeGroups := new(repeatableStringFlag)
+	excludeGroups := new(repeatableStringFlag)
+	groupVersions := new(repeatableGroupVersionFlag)
+	excludeManifests := new(repeatableStringFlag)
+	contexts := new(repeatableStringFlag)
+	asGroups := new(repeatableStringFlag)
+	overrideBatchSizes := make(batchSizeFlag)
+	formatOverrides := make(formatOverrideFlag)
+	headers := make(headerFlag)
 
 	flag.StringVar(&dir, "dir", "", "Directory to dump objects into")
-	flag.Int64Var(&batchSize, "batch-size", 500, "Batch size for listing objects")
+	flag.StringVar(&format, "format", string(dumper.FormatJSON), "Output format. One of json, yaml.")
+	flag.Var(formatOverrides, "format-override", "Format of a kind in -dir as Kind.group=format, e.g. Secret=yaml. Takes precedence over -format. Can be used multiple times.")
+	flag.BoolVar(&gzip, "gzip", false, "Compress the files written to -dir, or the objects written to stdout, with gzip. Not supported with -tar.")
+	flag.IntVar(&gzipLevel, "gzip-level", -1, "Compression level of -gzip and the S3 upload, from 1 (best speed) to 9 (best compression). -1 uses the default level.")
+	flag.IntVar(&bufferSize, "buffer-size", dumper.DefaultBufferSize, "Size of the write buffer of stdout and the files of -dir and -tar in bytes. A negative size disables buffering.")
+	flag.BoolVar(&noStdoutBuffer, "no-stdout-buffer", false, "Write every object to stdout as soon as it is encoded instead of once per batch, for live tailing. Reduces the throughput. JSON lists are still written per batch since a list is a single line.")
+	flag.StringVar(&layout, "layout", string(dumper.LayoutFlat), "Directory layout of -dir. One of flat, namespaced, per-kind.")
+	flag.StringVar(&nameTemplate, "name-template", "", "Go template for the file names in -dir, e.g. {{.Namespace}}__{{.Kind}}__{{.Name}}.json. Available fields: .Group, .Version, .Kind, .Namespace, .Name, .UID.")
+	flag.BoolVar(&manifest, "manifest", false, "Write a manifest.json with the size and SHA-256 checksum of every file written to -dir or -tar")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", false, "Do not rewrite files in -dir that already have the same content. Only applies to -layout=namespaced and -name-template.")
+	flag.BoolVar(&htmlIndex, "html-index", false, "Write an index.html linking to every file written to -dir, grouped by namespace and kind, with object counts")
+	flag.BoolVar(&tee, "tee", false, "Also write the objects to stdout if they are dumped to -dir, -tar, or S3, e.g. to monitor the dump")
+	flag.BoolVar(&edges, "edges", false, "Write an edges.json to -dir with an edge from the owner to the object, by UID and kind, for every owner reference of the dumped objects")
+	flag.BoolVar(&errorsFile, "errors-file", false, "Write an errors.json to -dir with the resource, message, and time of every failure of the dump. No file is written if the dump succeeds")
+	flag.BoolVar(&completionMarker, "completion-marker", false, `Write {"_dump":"complete","count":N} as the last line to stdout after a successful dump, so consumers can detect truncated streams`)
+	flag.BoolVar(&listOnly, "list-only", false, "Only write the identity (apiVersion, kind, namespace, name, uid) of every object to stdout instead of the full object")
+	flag.StringVar(&listFormat, "list-format", string(dumper.IdentityFormatNDJSON), "Output format of -list-only. One of ndjson, csv.")
+	flag.StringVar(&checkpointFile, "checkpoint-file", "", "File to persist the progress of the dump to. If the file exists, the dump is resumed and -dir files are appended to.")
+	flag.StringVar(&watermarkFile, "watermark-file", "", "File with the highest resourceVersion of every resource dumped before. Only objects changed since are dumped and the file is updated after the dump. Deleted objects are not captured.")
+	flag.StringVar(&sinceFile, "since-file", "", "File with the newest creation timestamp of the objects dumped before. Only objects created since are dumped and the file is updated after a successful dump. Modified and deleted objects are not captured.")
+	flag.BoolVar(&quiet, "quiet", false, "Do not print the discovered resources to stderr. Skipped resources, warnings, and errors are still printed.")
+	flag.BoolVar(&noProgress, "no-progress", false, "Do not show the progress line. It is only shown if stderr is a terminal, plain logs are used, and the objects are not dumped to a terminal.")
+	flag.StringVar(&logFormat, "log-format", "plain", "Format of the log messages on stderr. One of plain, json. json adds structured attributes like the resource, namespace, and count.")
+	flag.BoolVar(&printStats, "print-stats", false, "Print a table with statistics for every resource to stderr after the dump")
+	flag.StringVar(&tarFile, "tar", "", "Tar archive to dump objects into. Every object is written as a separate file.")
+	flag.Int64Var(&batchSize, "batch-size", discovery.DefaultBatchSize, "Batch size for listing objects. 0 uses the default, -1 lists every resource with a single request without a limit.")
+	flag.Var(overrideBatchSizes, "override-batch-size", "Batch size of a resource as resource.group=size, e.g. configmaps=100 or deployments.apps=50. Overrides -batch-size. Can be used multiple times.")
+	flag.IntVar(&concurrency, "concurrency", 1, "Number of resources to list in parallel")
+	flag.IntVar(&namespaceConcurrency, "namespace-concurrency", 1, "Number of namespaces to list a resource from in parallel if -include-namespace is set")
+	flag.IntVar(&maxRetries, "max-retries", 0, "Number of times to retry listing a resource on transient errors")
+	flag.DurationVar(&retryBackoff, "retry-backoff", time.Second, "Initial wait time between retries. Doubles with each retry.")
+	flag.IntVar(&dumpRetries, "dump-retries", 0, "Number of times to retry writing a batch of objects if it fails, e.g. on flaky network storage. Retried batches can be partially duplicated unless -layout=namespaced is used.")
+	flag.Float64Var(&qps, "qps", float64(rest.DefaultQPS), "Maximum queries per second to the Kubernetes API server")
+	flag.IntVar(&burst, "burst", rest.DefaultBurst, "Maximum burst of queries to the Kubernetes API server")
+	flag.StringVar(&asUser, "as", "", "User to impersonate for all requests, e.g. system:serviceaccount:<namespace>:<name>")
+	flag.Var(asGroups, "as-group", "Group to impersonate for all requests. Requires -as. Can be used multiple times.")
+	flag.StringVar(&asUID, "as-uid", "", "UID to impersonate for all requests. Requires -as.")
+	buildVersion, _, _ := buildInfo()
+	flag.StringVar(&userAgent, "user-agent", "k8s-object-dumper/"+buildVersion, "User-Agent header of all requests")
+	flag.Var(headers, "header", "Extra HTTP header set on all requests as 'Name: value', e.g. 'X-Team: platform'. Can be used multiple times.")
+	flag.StringVar(&discoveryCacheDir, "discovery-cache-dir", "", "Directory to cache the discovered resources in, e.g. ~/.kube/cache/k8s-object-dumper. Dumps within -discovery-cache-ttl skip the discovery. Disabled if empty.")
+	flag.DurationVar(&discoveryCacheTTL, "discovery-cache-ttl", discovery.DefaultDiscoveryCacheTTL, "Time the cached discovery of -discovery-cache-dir is used for")
+	flag.BoolVar(&refreshDiscovery, "refresh-discovery", false, "Ignore and replace the cached discovery of -discovery-cache-dir, e.g. after installing a CRD")
 	flag.Var(mustExistResources, "must-exist", "Resource that must exist in the cluster. Can be used multiple times.")
+	flag.BoolVar(&includeEvents, "include-events", false, "Dump events. Events are skipped by default.")
+	flag.BoolVar(&stripManagedFields, "strip-managed-fields", false, "Remove metadata.managedFields from dumped objects")
+	flag.BoolVar(&sortItems, "sort-items", false, "Sort the objects of every listed batch by namespace and name. Objects are not sorted across batches, see -batch-size.")
+	flag.BoolVar(&restoreOrder, "restore-order", false, "Dump Namespaces and CustomResourceDefinitions before all other resources, so the output can be applied in order.")
+	flag.Var(redactPaths, "redact-path", "JSONPath expression, e.g. {.spec.password}, selecting values to replace with REDACTED in every object. Can be used multiple times.")
+	flag.BoolVar(&stableOutput, "stable-output", false, "Remove metadata changing on every write (resourceVersion, uid, generation, creationTimestamp, managedFields) to diff consecutive dumps. The output cannot be restored.")
+	flag.BoolVar(&stripStatus, "strip-status", false, "Remove the status field from dumped objects")
+	flag.BoolVar(&includeSecretData, "include-secret-data", false, "Dump the values of Secrets. By default the values are redacted.")
+	flag.StringVar(&scope, "scope", string(discovery.ScopeAll), "Scope of the resources to dump. One of all, namespaced, cluster.")
+	flag.DurationVar(&timeout, "timeout", 0, "Maximum duration of the whole dump. Objects dumped before the timeout are kept. Zero means no timeout.")
+	flag.DurationVar(&watchDuration, "watch-duration", 0, "Watch every resource for the duration instead of listing it and only dump objects added or modified meanwhile. Zero lists the resources.")
+	flag.BoolVar(&allVersions, "all-versions", false, "Dump every served version of every resource instead of only the preferred version. The version is added to the file names in -dir.")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only print the estimated number of objects per resource to stderr, without dumping them")
+	flag.BoolVar(&skipForbidden, "skip-forbidden", false, "Skip resources the user is not allowed to list instead of failing")
 	flag.Var(ignoreResources, "ignore", "Resource to ignore during discovery. Regexp, anchored by default. Can be used multiple times.")
+	flag.StringVar(&labelSelector, "label-selector", "", "Only dump objects matching the label selector")
+	flag.StringVar(&annotationSelector, "annotation-selector", "", "Only dump objects with the annotation. Either a key to match any value or key=value. Filtered after listing since the API server cannot select annotations.")
+	flag.StringVar(&fieldSelector, "field-selector", "", "Only dump objects matching the field selector. Resources not supporting the selected fields are skipped.")
+	flag.StringVar(&resource, "resource", "", "Only dump the resource, e.g. deployments.apps or pods, without discovering all resources. Resource filters are ignored.")
+	flag.StringVar(&namespace, "namespace", "", "Only dump namespaced objects from the namespace. Same as -include-namespace.")
+	flag.Var(includeNamespaces, "include-namespace", "Namespace to dump namespaced resources from. Can be used multiple times. Defaults to all namespaces.")
+	flag.Var(excludeNamespaces, "exclude-namespace", "Namespace to skip. Applied on top of -include-namespace. Can be used multiple times.")
+	flag.BoolVar(&excludeSystemNamespaces, "exclude-system-namespaces", false, "Skip the namespaces kube-system, kube-public, and kube-node-lease. Namespaces set with -include-namespace are still dumped.")
+	flag.BoolVar(&excludeKubeNamespaces, "exclude-kube-namespaces", false, "Skip all namespaces starting with kube-. Namespaces set with -include-namespace are still dumped.")
+	flag.Var(includeKinds, "include-kind", "Kind to dump. Case-insensitive. Supports glob patterns like '*Policy'. Can be used multiple times. Defaults to all kinds.")
+	flag.Var(excludeKinds, "exclude-kind", "Kind to skip. Case-insensitive. Supports glob patterns like '*Policy'. Applied on top of -include-kind. Can be used multiple times.")
+	flag.StringVar(&excludeFile, "exclude-file", "", "YAML or JSON file with a list of group kinds to skip, e.g. Deployment.apps. Kinds without a group select the core group.")
+	flag.Var(excludeManifests, "exclude-manifest", "manifest.json of a previous dump written with -manifest. Objects with a UID listed in the manifest are skipped to write a delta dump. Can be used multiple times.")
+	flag.Var(excludeOwnedBy, "exclude-owned-by", "Skip objects owned by an object of the kind, e.g. ReplicaSet to skip Pods created by ReplicaSets. Case-insensitive. Can be used multiple times.")
+	flag.StringVar(&excludeNameRegex, "exclude-name-regex", "", "Skip objects whose name matches the regexp, e.g. ^tmp-. Not anchored.")
+	flag.Int64Var(&maxObjectBytes, "max-object-bytes", 0, "Skip and log objects whose JSON serialization is larger than the given number of bytes. 0 disables the check.")
+	flag.Int64Var(&maxObjects, "max-objects", 0, "Stop the dump after the given number of objects, for example to sample a large cluster. The dump then exits as partially successful. 0 disables the limit.")
+	flag.Var(&createdAfter, "created-after", "Only dump objects created at or after the RFC3339 timestamp. Objects without a valid creation timestamp are always dumped.")
+	flag.Var(&createdBefore, "created-before", "Only dump objects created before the RFC3339 timestamp. Objects without a valid creation timestamp are always dumped.")
+	flag.Var(includeGroups, "include-group", "API group to dump. An empty value selects the core group. Can be used multiple times. Defaults to all groups.")
+	flag.Var(excludeGroups, "exclude-group", "API group to skip. An empty value selects the core group. Applied on top of -include-group. Can be used multiple times.")
+	flag.Var(groupVersions, "group-version", "Group version to dump, e.g. apps/v1 or v1 for the core group. Selects exact versions, even if not preferred. Can be used multiple times. Defaults to all group versions.")
+	flag.Var(contexts, "context", "Kubeconfig context to dump. Can be used multiple times to dump multiple clusters, the objects of every context are then written to <dir>/<context>. Defaults to the current context.")
+
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve prometheus metrics on under /metrics during the dump, e.g. :9090. Disabled if empty.")
+	flag.BoolVar(&printVersionAndExit, "version", false, "Print the version, git commit, and build date and exit")
+	flag.StringVar(&configFile, configFlag, "", "YAML or JSON file setting flags by name, e.g. batch-size: 100. Lists set repeatable flags. Flags on the command line take precedence.")
 
 	flag.Parse()
 
-	df := dumper.DumpToWriter(os.Stdout)
-	if dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to create directory %s: %v\n", dir, err)
-			os.Exit(1)
+	if configFile != "" {
+		if err := applyConfigFile(flag.CommandLine, configFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
 		}
-		d, err := dumper.NewDirDumper(dir)
+	}
+
+	if printVersionAndExit {
+		printVersion(os.Stdout)
+		return exitOK
+	}
+
+	if namespace != "" {
+		includeNamespaces.Set(namespace)
+	}
+
+	f, err := dumper.ParseFormat(format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -format: %v\n", err)
+		return exitFailure
+	}
+	l, err := dumper.ParseLayout(layout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -layout: %v\n", err)
+		return exitFailure
+	}
+	lf, err := dumper.ParseIdentityFormat(listFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -list-format: %v\n", err)
+		return exitFailure
+	}
+	// The progress is shown on stderr if it is a terminal and would not be interleaved with objects written to the same terminal.
+	var progress *progressBar
+	var stderr io.Writer = os.Stderr
+	if !noProgress && logFormat == "plain" && isTerminal(os.Stderr) && ((!tee && (dir != "" || tarFile != "")) || !isTerminal(os.Stdout)) {
+		progress = newProgressBar(os.Stderr)
+		stderr = progress
+		defer progress.finish()
+	}
+	logger, err := newLogger(logFormat, stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -log-format: %v\n", err)
+		return exitFailure
+	}
+
+	sc, err := discovery.ParseScope(scope)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -scope: %v\n", err)
+		return exitFailure
+	}
+
+	var excludeGroupKinds []schema.GroupKind
+	if excludeFile != "" {
+		excludeGroupKinds, err = discovery.LoadGroupKindsFile(excludeFile)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to create directory dumper: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "invalid -exclude-file: %v\n", err)
+			return exitFailure
 		}
-		defer d.Close()
-		df = d.Dump
 	}
 
-	conf, err := ctrl.GetConfig()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get Kubernetes config: %v", err)
+	var excludeUIDs []types.UID
+	for _, path := range *excludeManifests {
+		m, err := dumper.ReadManifest(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -exclude-manifest: %v\n", err)
+			return exitFailure
+		}
+		for _, uid := range m.UIDs {
+			excludeUIDs = append(excludeUIDs, types.UID(uid))
+		}
 	}
 
-	if err := discovery.DiscoverObjects(context.Background(), conf, df, discovery.DiscoveryOptions{
-		BatchSize:          batchSize,
-		LogWriter:          os.Stderr,
-		MustExistResources: *mustExistResources,
-		IgnoreResources:    *ignoreResources,
-	}); err != nil {
+	if completionMarker && (dir != "" || tarFile != "") {
+		fmt.Fprintln(os.Stderr, "-completion-marker is only supported when dumping to stdout")
+		return exitFailure
+	}
+	if listOnly && (dir != "" || tarFile != "") {
+		fmt.Fprintln(os.Stderr, "-list-only is only supported when dumping to stdout")
+		return exitFailure
+	}
+	if listOnly && completionMarker && lf == dumper.IdentityFormatCSV {
+		fmt.Fprintln(os.Stderr, "-completion-marker is not supported with -list-format=csv")
+		return exitFailure
+	}
+	if asUser == "" && (len(*asGroups) > 0 || asUID != "") {
+		fmt.Fprintln(os.Stderr, "-as-group and -as-uid require -as")
+		return exitFailure
+	}
+	if batchSize < discovery.NoBatchLimit {
+		fmt.Fprintf(os.Stderr, "invalid -batch-size %d, must be positive, 0 for the default, or -1 for no limit\n", batchSize)
+		return exitFailure
+	}
+	if len(formatOverrides) > 0 && dir == "" {
+		fmt.Fprintln(os.Stderr, "-format-override requires -dir")
+		return exitFailure
+	}
+	if htmlIndex && dir == "" {
+		fmt.Fprintln(os.Stderr, "-html-index requires -dir")
+		return exitFailure
+	}
+	if edges && dir == "" {
+		fmt.Fprintln(os.Stderr, "-edges requires -dir")
+		return exitFailure
+	}
+	if errorsFile && dir == "" {
+		fmt.Fprintln(os.Stderr, "-errors-file requires -dir")
+		return exitFailure
+	}
+	if tarFile != "" {
+		if dir != "" {
+			fmt.Fprintln(os.Stderr, "-dir and -tar are mutually exclusive")
+			return exitFailure
+		}
+		if checkpointFile != "" {
+			fmt.Fprintln(os.Stderr, "-checkpoint-file is not supported with -tar")
+			return exitFailure
+		}
+		if gzip {
+			fmt.Fprintln(os.Stderr, "-gzip is not supported with -tar")
+			return exitFailure
+		}
+	}
+	// multiContext writes the objects of every context into a separate directory.
+	multiContext := len(*contexts) > 1
+	if multiContext {
+		if dir == "" {
+			fmt.Fprintln(os.Stderr, "-dir is required with multiple -context")
+			return exitFailure
+		}
+		if checkpointFile != "" {
+			fmt.Fprintln(os.Stderr, "-checkpoint-file is not supported with multiple -context")
+			return exitFailure
+		}
+		if watermarkFile != "" {
+			fmt.Fprintln(os.Stderr, "-watermark-file is not supported with multiple -context")
+			return exitFailure
+		}
+		if sinceFile != "" {
+			fmt.Fprintln(os.Stderr, "-since-file is not supported with multiple -context")
+			return exitFailure
+		}
+	}
+
+	resume := false
+	if checkpointFile != "" {
+		if _, err := os.Stat(checkpointFile); err == nil {
+			resume = true
+		}
+	}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-sigCtx.Done()
+		// Restore the default handling, so a second signal quits immediately while the written objects are flushed.
+		stop()
+	}()
+	ctx := sigCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	opts := discovery.DiscoveryOptions{
+		BatchSize:               batchSize,
+		OverrideBatchSize:       overrideBatchSizes,
+		LogWriter:               stderr,
+		Logger:                  logger,
+		MustExistResources:      *mustExistResources,
+		Resource:                resource,
+		IgnoreResources:         *ignoreResources,
+		LabelSelector:           labelSelector,
+		FieldSelector:           fieldSelector,
+		AnnotationSelector:      annotationSelector,
+		IncludeNamespaces:       *includeNamespaces,
+		ExcludeNamespaces:       *excludeNamespaces,
+		ExcludeSystemNamespaces: excludeSystemNamespaces,
+		ExcludeKubeNamespaces:   excludeKubeNamespaces,
+		IncludeKinds:            *includeKinds,
+		ExcludeKinds:            *excludeKinds,
+		ExcludeGroupKinds:       excludeGroupKinds,
+		ExcludeOwnedBy:          *excludeOwnedBy,
+		ExcludeNameRegex:        excludeNameRegex,
+		MaxObjectBytes:          maxObjectBytes,
+		MaxObjects:              maxObjects,
+		CreatedAfter:            time.Time(createdAfter),
+		CreatedBefore:           time.Time(createdBefore),
+		IncludeGroups:           *includeGroups,
+		ExcludeGroups:           *excludeGroups,
+		IncludeGroupVersions:    *groupVersions,
+		Concurrency:             concurrency,
+		NamespaceConcurrency:    namespaceConcurrency,
+		CheckpointFile:          checkpointFile,
+		WatermarkFile:           watermarkFile,
+		SinceFile:               sinceFile,
+		ExcludeUIDs:             excludeUIDs,
+		MaxRetries:              maxRetries,
+		RetryBackoff:            retryBackoff,
+		DumpRetries:             dumpRetries,
+		QPS:                     float32(qps),
+		Burst:                   burst,
+		Impersonate:             rest.ImpersonationConfig{UserName: asUser, Groups: *asGroups, UID: asUID},
+		UserAgent:               userAgent,
+		Headers:                 http.Header(headers),
+		DiscoveryCacheDir:       discoveryCacheDir,
+		DiscoveryCacheTTL:       discoveryCacheTTL,
+		RefreshDiscovery:        refreshDiscovery,
+		IncludeEvents:           includeEvents,
+		StripManagedFields:      stripManagedFields,
+		StripStatus:             stripStatus,
+		StableOutput:            stableOutput,
+		SortItems:               sortItems,
+		RestoreOrder:            restoreOrder,
+		RedactPaths:             *redactPaths,
+		IncludeSecretData:       includeSecretData,
+		SkipForbidden:           skipForbidden,
+		Scope:                   sc,
+		DryRun:                  dryRun,
+		WatchDuration:           watchDuration,
+		Quiet:                   quiet,
+		AllVersions:             allVersions,
+	}
+	out := output{
+		dir:              dir,
+		tarFile:          tarFile,
+		format:           f,
+		formatOverrides:  formatOverrides,
+		gzip:             gzip,
+		gzipLevel:        gzipLevel,
+		bufferSize:       bufferSize,
+		noStdoutBuffer:   noStdoutBuffer,
+		layout:           l,
+		nameTemplate:     nameTemplate,
+		manifest:         manifest,
+		htmlIndex:        htmlIndex,
+		edges:            edges,
+		errorsFile:       errorsFile,
+		tee:              tee,
+		completionMarker: completionMarker,
+		listOnly:         listOnly,
+		listFormat:       lf,
+		allVersions:      allVersions,
+		append:           resume,
+		skipUnchanged:    skipUnchanged,
+		concurrency:      opts.GetConcurrency() * opts.GetNamespaceConcurrency(),
+	}
+	if watchDuration > 0 {
+		// Every resource is watched in its own goroutine.
+		out.concurrency = math.MaxInt
+	}
+
+	if progress != nil {
+		opts.Progress = progress.update
+	}
+
+	if metricsAddr != "" {
+		reg := prometheus.NewRegistry()
+		if opts.Metrics, err = discovery.NewMetrics(reg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		if out.metrics, err = dumper.NewMetrics(reg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		stopMetrics, err := serveMetrics(metricsAddr, reg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start metrics server: %v\n", err)
+			return exitFailure
+		}
+		defer stopMetrics()
+	}
+
+	kubeContexts := *contexts
+	if len(kubeContexts) == 0 {
+		// The empty context selects the current context.
+		kubeContexts = []string{""}
+	}
+	var errs []error
+	var codes []int
+	for _, kubeContext := range kubeContexts {
+		if ctx.Err() != nil {
+			break
+		}
+		conf, err := configForContext(kubeContext)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get Kubernetes config for context %q: %w", kubeContext, err))
+			codes = append(codes, exitFailure)
+			continue
+		}
+		o := out
+		if multiContext {
+			fmt.Fprintf(stderr, "Dumping context %s\n", kubeContext)
+			o.dir = filepath.Join(dir, contextDirReplacer.Replace(kubeContext))
+		}
+		stats, err := dump(ctx, conf, o, opts)
+		if printStats {
+			if multiContext {
+				fmt.Fprintf(stderr, "Context %s:\n", kubeContext)
+			}
+			printStatsTable(stderr, stats)
+		}
+		codes = append(codes, exitCode(stats, err))
+		if err != nil {
+			if multiContext {
+				err = fmt.Errorf("context %q: %w", kubeContext, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	progress.finish()
+	interrupted := sigCtx.Err() != nil
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "interrupted, flushing written objects")
+	} else if err := multierr.Combine(errs...); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to dump some or all objects: %+v\n", err)
-		os.Exit(1)
+	}
+	return combineExitCodes(codes, interrupted)
+}
+
+// exitCode returns the exit code for the result of a dump.
+// The dump is partially successful if at least one resource was dumped despite the error or if resources were skipped as forbidden.
+func exitCode(stats []discovery.ResourceStat, err error) int {
+	dumped := false
+	forbidden := false
+	for _, s := range stats {
+		if s.Skipped && s.SkipReason == discovery.SkipReasonForbidden {
+			forbidden = true
+		}
+		if !s.Skipped && !s.Failed {
+			dumped = true
+		}
+	}
+	switch {
+	case err != nil && !dumped:
+		return exitFailure
+	case err != nil || forbidden:
+		return exitPartial
+	}
+	return exitOK
+}
+
+// combineExitCodes returns the exit code of the dump of multiple contexts.
+// It returns exitInterrupted if the dump was interrupted by a signal,
+// exitOK or exitFailure if all contexts have that code, and exitPartial otherwise.
+func combineExitCodes(codes []int, interrupted bool) int {
+	if interrupted {
+		return exitInterrupted
+	}
+	if len(codes) == 0 {
+		return exitOK
+	}
+	for _, c := range codes[1:] {
+		if c != codes[0] {
+			return exitPartial
+		}
+	}
+	return codes[0]
+}
+
+// contextDirReplacer makes context names safe to use as directory names.
+// Context names of managed clusters often contain slashes, e.g. arn:aws:eks:<region>:<account>:cluster/<name>.
+var contextDirReplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// configForContext loads the Kubernetes config for the given kubeconfig context.
+// The config is loaded with clientcmd from the -kubeconfig flag, registered by controller-runtime, or the default loading rules.
+// If neither the context nor the -kubeconfig flag is set, the config is discovered by controller-runtime, which also supports in-cluster configs.
+func configForContext(kubeContext string) (*rest.Config, error) {
+	var kubeconfig string
+	if kf := flag.Lookup("kubeconfig"); kf != nil {
+		kubeconfig = kf.Value.String()
+	}
+	if kubeContext == "" && kubeconfig == "" {
+		return ctrl.GetConfig()
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: kubeContext}).ClientConfig()
+}
+
+// output configures where the dumped objects are written to.
+type output struct {
+	// dir is the directory to dump into. Objects are written to stdout if both dir and tarFile are empty.
+	dir string
+	// tarFile is the tar archive to dump into.
+	tarFile string
+	format  dumper.Format
+	gzip    bool
+	// formatOverrides are the formats of specific kinds in dir.
+	formatOverrides map[schema.GroupKind]dumper.Format
+	// gzipLevel is the gzip compression level.
+	gzipLevel int
+	// bufferSize is the size of the write buffers of stdout, dir, and tarFile.
+	bufferSize int
+	layout     dumper.Layout
+	// noStdoutBuffer writes the objects to stdout unbuffered.
+	noStdoutBuffer bool
+	// nameTemplate is the template for file names in dir.
+	nameTemplate string
+	// manifest writes a manifest with checksums of the written files.
+	manifest bool
+	// htmlIndex writes an HTML index of the files written to dir.
+	htmlIndex bool
+	// edges writes the owner reference graph of the dumped objects to dir.
+	edges bool
+	// errorsFile writes the failures of the dump to dir.
+	errorsFile bool
+	// tee additionally writes the objects to stdout if they are dumped to dir, a tar file, or S3.
+	tee bool
+	// completionMarker writes a completion marker to stdout after a successful dump.
+	completionMarker bool
+	// listOnly writes the identities of the objects to stdout instead of the objects.
+	listOnly bool
+	// listFormat is the format of the identities written by listOnly.
+	listFormat dumper.IdentityFormat
+	// allVersions adds the version to file names in dir.
+	allVersions bool
+	// append appends to existing files in dir.
+	append bool
+	// skipUnchanged does not rewrite files in dir with unchanged content.
+	skipUnchanged bool
+	// concurrency is the maximum number of goroutines dumping objects at the same time.
+	concurrency int
+	// metrics counts the written bytes. Nil if disabled.
+	metrics *dumper.Metrics
+	// stdout receives the objects written to stdout. Defaults to os.Stdout.
+	stdout io.Writer
+}
+
+// closingDumper is a dumper that must be closed after use.
+type closingDumper interface {
+	Dump(*unstructured.UnstructuredList) error
+	io.Closer
+}
+
+// newS3Dumper creates a dumper uploading to S3 if configured by flags.
+// It returns nil if no upload is configured.
+// Only set if built with the s3 build tag, see main_s3.go.
+var newS3Dumper func(ctx context.Context, out output) (closingDumper, error)
+
+// dump discovers all objects of the cluster and writes them to the output.
+// The dumpers are closed before returning so every written object is flushed, even if the discovery fails.
+func dump(ctx context.Context, conf *rest.Config, out output, opts discovery.DiscoveryOptions) (stats []discovery.ResourceStat, err error) {
+	if opts.DryRun {
+		// Nothing is dumped, do not create any files.
+		return discovery.DiscoverObjectsWithStats(ctx, conf, func(*unstructured.UnstructuredList) error { return nil }, opts)
+	}
+	rawStdout := out.stdout
+	if rawStdout == nil {
+		rawStdout = os.Stdout
+	}
+	stdout, stdoutBuf, stdoutGzip, err := newStdoutWriter(out.metrics.Writer(rawStdout), out)
+	if err != nil {
+		return nil, err
+	}
+	df := dumper.DumpToWriter(stdout)
+	// concurrencySafe is true if df can be called from multiple goroutines.
+	concurrencySafe := false
+	if out.format == dumper.FormatYAML {
+		df = dumper.DumpToWriterYAML(stdout)
+	}
+	if out.listOnly {
+		df = dumper.DumpIdentitiesToWriter(stdout, out.listFormat)
+	}
+	stdoutDf := df
+	// toStdout is true if the objects are written to stdout.
+	toStdout := true
+	var dirDumper *dumper.DirDumper
+	if out.dir != "" {
+		// The errors of New and Create must not shadow err, the deferred Close errors are appended to it.
+		d, dirErr := dumper.NewDirDumper(out.dir, dumper.DirDumperOptions{
+			Format:          out.format,
+			FormatOverrides: out.formatOverrides,
+			Gzip:            out.gzip,
+			GzipLevel:       out.gzipLevel,
+			BufferSize:      out.bufferSize,
+			Layout:          out.layout,
+			Append:          out.append,
+			NameTemplate:    out.nameTemplate,
+			Manifest:        out.manifest,
+			HTMLIndex:       out.htmlIndex,
+			Edges:           out.edges,
+			Errors:          out.errorsFile,
+			IncludeVersion:  out.allVersions,
+			SkipUnchanged:   out.skipUnchanged,
+			Metrics:         out.metrics,
+		})
+		if dirErr != nil {
+			return nil, fmt.Errorf("failed to create directory dumper: %w", dirErr)
+		}
+		defer closeWithError(&err, "directory dumper", d)
+		df = d.Dump
+		dirDumper = d
+		if out.manifest {
+			opts.ServerVersion = func(v *k8sversion.Info) { d.SetServerVersion(v.GitVersion) }
+		}
+		concurrencySafe = true
+		toStdout = false
+	}
+	if out.tarFile != "" {
+		tf, tarErr := os.Create(out.tarFile)
+		if tarErr != nil {
+			return nil, fmt.Errorf("failed to create tar file %s: %w", out.tarFile, tarErr)
+		}
+		defer closeWithError(&err, "tar file", tf)
+		d := dumper.NewTarDumper(out.metrics.Writer(tf), dumper.TarDumperOptions{Manifest: out.manifest, BufferSize: out.bufferSize})
+		defer closeWithError(&err, "tar dumper", d)
+		df = d.Dump
+		if out.manifest {
+			opts.ServerVersion = func(v *k8sversion.Info) { d.SetServerVersion(v.GitVersion) }
+		}
+		concurrencySafe = false
+		toStdout = false
+	}
+	if newS3Dumper != nil {
+		// The upload is completed on Close, even if the dump is interrupted or times out.
+		d, s3Err := newS3Dumper(context.WithoutCancel(ctx), out)
+		if s3Err != nil {
+			return nil, s3Err
+		}
+		if d != nil {
+			defer closeWithError(&err, "S3 dumper", d)
+			df = d.Dump
+			concurrencySafe = false
+			toStdout = false
+		}
+	}
+	if out.tee && !toStdout {
+		df = dumper.NewMultiDumper(df, stdoutDf).Dump
+		concurrencySafe = false
+		toStdout = true
+	}
+	// flushers are flushed after every batch, in the order data flows to stdout.
+	var flushers []flusher
+	if toStdout && stdoutBuf != nil {
+		flushers = append(flushers, stdoutBuf)
+	}
+	if toStdout && stdoutGzip != nil {
+		flushers = append(flushers, stdoutGzip)
+		// Closing writes the gzip trailer, so an interrupted or failed dump still produces a valid, if short, stream.
+		defer closeWithError(&err, "gzip stream", gzipCloser{buf: stdoutBuf, gz: stdoutGzip})
+	}
+	if len(flushers) > 0 {
+		df = flushAfter(df, flushers...)
+	}
+	if out.concurrency > 1 && !concurrencySafe {
+		df = synchronized(df)
+	}
+
+	stats, err = discovery.DiscoverObjectsWithStats(ctx, conf, df, opts)
+	if dirDumper != nil && err != nil {
+		dirDumper.RecordErrors(errorRecords(err, opts.GetClock().Now())...)
+	}
+	if dirDumper != nil && out.skipUnchanged {
+		ds := dirDumper.Stats()
+		opts.GetLogger().Info(fmt.Sprintf("%d files written, %d unchanged", ds.Written, ds.Unchanged), "written", ds.Written, "unchanged", ds.Unchanged)
+	}
+	if err == nil && out.completionMarker && toStdout {
+		n := 0
+		for _, s := range stats {
+			n += s.Count
+		}
+		f := out.format
+		if out.listOnly {
+			// Identities are always written as JSON lines.
+			f = dumper.FormatJSON
+		}
+		err = dumper.WriteCompletionMarker(stdout, f, n)
+		if err == nil && stdoutBuf != nil {
+			err = stdoutBuf.Flush()
+		}
+	}
+	return stats, err
+}
+
+// errorRecords converts the error of a dump into records of the errors file, one for every failure of a *discovery.DumpError.
+// Other errors, for example invalid options, are recorded as a single failure at the given time.
+func errorRecords(err error, now time.Time) []dumper.ErrorRecord {
+	var dumpErr *discovery.DumpError
+	if !errors.As(err, &dumpErr) {
+		return []dumper.ErrorRecord{{Error: err.Error(), Time: now}}
+	}
+	records := make([]dumper.ErrorRecord, len(dumpErr.Errors))
+	for i, re := range dumpErr.Errors {
+		records[i] = dumper.ErrorRecord{Error: re.Err.Error(), Time: re.Time}
+		if !re.Resource.Empty() {
+			records[i].Resource = re.Resource.String()
+		}
+	}
+	return records
+}
+
+// closeWithError closes c and appends a failure to err.
+func closeWithError(err *error, name string, c io.Closer) {
+	if cerr := c.Close(); cerr != nil {
+		*err = multierr.Append(*err, fmt.Errorf("failed to close %s: %w", name, cerr))
+	}
+}
+
+// newLogger returns the logger for the given log format writing to w.
+// It returns nil for plain messages, which are written by the discovery to its LogWriter.
+func newLogger(format string, w io.Writer) (*slog.Logger, error) {
+	switch format {
+	case "plain":
+		return nil, nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, must be one of %q, %q", format, "plain", "json")
+}
+
+// printStatsTable prints the resource statistics as a table.
+func printStatsTable(w io.Writer, stats []discovery.ResourceStat) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tVERSION\tOBJECTS\tBATCHES\tDURATION\tSTATUS")
+	for _, s := range stats {
+		status := "ok"
+		switch {
+		case s.Failed:
+			status = "failed"
+		case s.Skipped:
+			status = "skipped: " + s.SkipReason
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", s.Resource.GroupResource(), s.Resource.Version, s.Count, s.Batches, s.Duration.Round(time.Millisecond), status)
+	}
+	tw.Flush()
+}
+
+// synchronized wraps the dumper function so that it is never called concurrently.
+func synchronized(df dumper.DumperFunc) dumper.DumperFunc {
+	var mu sync.Mutex
+	return func(l *unstructured.UnstructuredList) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return df(l)
 	}
 }
 
+// flusher is a buffered writer like *bufio.Writer or *gzip.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// flushAfter flushes the writers in order after every call of df, also if df fails.
+func flushAfter(df dumper.DumperFunc, ws ...flusher) dumper.DumperFunc {
+	return func(l *unstructured.UnstructuredList) error {
+		err := df(l)
+		for _, w := range ws {
+			if ferr := w.Flush(); ferr != nil {
+				return multierr.Append(err, fmt.Errorf("failed to flush stdout: %w", ferr))
+			}
+		}
+		return err
+	}
+}
+
+// newStdoutWriter wraps w with the compression and buffering of the output.
+// The returned buffer and gzip writer are nil if disabled.
+// The buffer is flushed after every batch, so consumers of the stream see the objects of a batch once it is dumped.
+// The gzip writer writes nothing until the first write or Close.
+func newStdoutWriter(w io.Writer, out output) (io.Writer, *bufio.Writer, *gzip.Writer, error) {
+	var gz *gzip.Writer
+	if out.gzip {
+		var err error
+		gz, err = gzip.NewWriterLevel(w, out.gzipLevel)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		w = gz
+	}
+	var buf *bufio.Writer
+	if !out.noStdoutBuffer && out.bufferSize > 0 {
+		buf = bufio.NewWriterSize(w, out.bufferSize)
+		w = buf
+	}
+	return w, buf, gz, nil
+}
+
+// gzipCloser flushes the buffer writing to the gzip writer, if any, and closes the gzip writer.
+type gzipCloser struct {
+	buf *bufio.Writer
+	gz  *gzip.Writer
+}
+
+// Close implements io.Closer.
+func (c gzipCloser) Close() error {
+	if c.buf != nil {
+		if err := c.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	return c.gz.Close()
+}
+
 type repeatableStringFlag []string
 
 func (i *repeatableStringFlag) String() string {
@@ -68,6 +935,37 @@ func (i *repeatableStringFlag) Set(value string) error {
 	return nil
 }
 
+// repeatableKindFlag is a repeatableStringFlag rejecting malformed kind patterns.
+type repeatableKindFlag []string
+
+func (i *repeatableKindFlag) String() string {
+	return fmt.Sprintf("%v", *i)
+}
+
+func (i *repeatableKindFlag) Set(value string) error {
+	if err := discovery.ValidateKindPattern(value); err != nil {
+		return err
+	}
+	*i = append(*i, value)
+	return nil
+}
+
+// repeatableGroupVersionFlag is a flag for group versions, see discovery.ParseGroupVersion.
+type repeatableGroupVersionFlag []schema.GroupVersion
+
+func (i *repeatableGroupVersionFlag) String() string {
+	return fmt.Sprintf("%v", *i)
+}
+
+func (i *repeatableGroupVersionFlag) Set(value string) error {
+	gv, err := discovery.ParseGroupVersion(value)
+	if err != nil {
+		return err
+	}
+	*i = append(*i, gv)
+	return nil
+}
+
 type repeatableRegexpFlag []*regexp.Regexp
 
 func (i *repeatableRegexpFlag) String() string {
@@ -83,3 +981,80 @@ func (i *repeatableRegexpFlag) Set(value string) error {
 	*i = append(*i, r)
 	return nil
 }
+
+// timeFlag is a flag for RFC3339 timestamps.
+type timeFlag time.Time
+
+func (i *timeFlag) String() string {
+	if time.Time(*i).IsZero() {
+		return ""
+	}
+	return time.Time(*i).Format(time.RFC3339)
+}
+
+func (i *timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("failed to parse RFC3339 timestamp %q: %w", value, err)
+	}
+	*i = timeFlag(t)
+	return nil
+}
+
+// headerFlag is a flag for HTTP headers in the format 'Name: value'.
+// Values of headers given multiple times are collected.
+type headerFlag http.Header
+
+func (i headerFlag) String() string {
+	return fmt.Sprintf("%v", http.Header(i))
+}
+
+func (i headerFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, must be 'Name: value'", value)
+	}
+	http.Header(i).Add(name, strings.TrimSpace(v))
+	return nil
+}
+
+// batchSizeFlag is a flag for batch sizes of resources in the format resource.group=size.
+type batchSizeFlag map[schema.GroupResource]int64
+
+func (i batchSizeFlag) String() string {
+	return fmt.Sprintf("%v", map[schema.GroupResource]int64(i))
+}
+
+func (i batchSizeFlag) Set(value string) error {
+	res, size, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid batch size %q, must be resource.group=size", value)
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 1 {
+		return fmt.Errorf("invalid batch size %q, must be a positive integer", size)
+	}
+	i[schema.ParseGroupResource(res)] = n
+	return nil
+}
+
+// formatOverrideFlag is a repeatable flag of Kind.group=format pairs.
+type formatOverrideFlag map[schema.GroupKind]dumper.Format
+
+func (i formatOverrideFlag) String() string {
+	return fmt.Sprintf("%v", map[schema.GroupKind]dumper.Format(i))
+}
+
+func (i formatOverrideFlag) Set(value string) error {
+	kind, format, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("invalid format override %q, must be Kind.group=format", value)
+	}
+	f, err := dumper.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	i[schema.ParseGroupKind(kind)] = f
+	return nil
+}
@@ -0,0 +1,56 @@
+//go:build s3
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+func init() {
+	var opts dumper.S3DumperOptions
+	var partSize uint64
+	var completeTimeout time.Duration
+
+	flag.StringVar(&opts.Endpoint, "s3-endpoint", "s3.amazonaws.com", "Host and optional port of the S3-compatible service for -s3-bucket")
+	flag.BoolVar(&opts.Insecure, "s3-insecure", false, "Use plain HTTP for -s3-endpoint")
+	flag.StringVar(&opts.Region, "s3-region", "", "Region of -s3-bucket. Looked up if empty.")
+	flag.StringVar(&opts.Bucket, "s3-bucket", "", "S3 bucket to stream the gzip compressed objects to. The credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.")
+	flag.StringVar(&opts.Prefix, "s3-prefix", "", "Prefix of the uploaded object in -s3-bucket, e.g. backups/2024-01-01/")
+	flag.Uint64Var(&partSize, "s3-part-size", dumper.DefaultS3PartSize, "Size of the uploaded parts in bytes. Must be at least 5 MiB.")
+	flag.DurationVar(&completeTimeout, "s3-complete-timeout", dumper.DefaultS3CompleteTimeout, "Maximum time to wait for the upload to complete after the dump, also if it was interrupted or timed out")
+
+	newS3Dumper = func(ctx context.Context, out output) (closingDumper, error) {
+		if opts.Bucket == "" {
+			return nil, nil
+		}
+		if out.dir != "" || out.tarFile != "" {
+			return nil, fmt.Errorf("-s3-bucket cannot be combined with -dir or -tar")
+		}
+		if out.completionMarker {
+			return nil, fmt.Errorf("-s3-bucket cannot be combined with -completion-marker")
+		}
+		if out.listOnly {
+			return nil, fmt.Errorf("-s3-bucket cannot be combined with -list-only")
+		}
+		o := opts
+		o.PartSize = partSize
+		o.CompleteTimeout = completeTimeout
+		o.GzipLevel = out.gzipLevel
+		o.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		o.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		o.Encoder = dumper.EncoderForFormat(out.format)
+		o.Extension = string(out.format)
+		o.Metrics = out.metrics
+		d, err := dumper.NewS3Dumper(ctx, o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 dumper: %w", err)
+		}
+		return d, nil
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/dumper"
+)
+
+// newEmptyAPIServer returns the config of a fake API server serving the core group without resources.
+func newEmptyAPIServer(t *testing.T) *rest.Config {
+	t.Helper()
+	responses := map[string]string{
+		"/api":    `{"kind":"APIVersions","versions":["v1"]}`,
+		"/api/v1": `{"kind":"APIResourceList","groupVersion":"v1","resources":[]}`,
+		"/apis":   `{"kind":"APIGroupList","apiVersion":"v1","groups":[]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return &rest.Config{Host: srv.URL}
+}
+
+// newConfigMapAPIServer returns the config of a fake API server serving n ConfigMaps, listed one per request.
+// onList is called with the index of the listed ConfigMap before it is served. An error fails the request.
+func newConfigMapAPIServer(t *testing.T, n int, onList func(i int) error) *rest.Config {
+	t.Helper()
+	responses := map[string]string{
+		"/api":    `{"kind":"APIVersions","versions":["v1"]}`,
+		"/api/v1": `{"kind":"APIResourceList","groupVersion":"v1","resources":[{"name":"configmaps","singularName":"configmap","namespaced":true,"kind":"ConfigMap","verbs":["list"]}]}`,
+		"/apis":   `{"kind":"APIGroupList","apiVersion":"v1","groups":[]}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/api/v1/configmaps" {
+			i, _ := strconv.Atoi(r.URL.Query().Get("continue"))
+			if err := onList(i); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","message":%q,"reason":"InternalError","code":500}`, err.Error())
+				return
+			}
+			cont := ""
+			if i+1 < n {
+				cont = strconv.Itoa(i + 1)
+			}
+			fmt.Fprintf(w, `{"kind":"ConfigMapList","apiVersion":"v1","metadata":{"continue":%q},"items":[{"kind":"ConfigMap","apiVersion":"v1","metadata":{"name":"cm-%d","namespace":"default"}}]}`, cont, i)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return &rest.Config{Host: srv.URL}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+func Test_dump(t *testing.T) {
+	conf := newEmptyAPIServer(t)
+	opts := discovery.DiscoveryOptions{LogWriter: io.Discard}
+
+	tdir := t.TempDir()
+	_, err := dump(context.Background(), conf, output{dir: tdir, format: dumper.FormatJSON, manifest: true}, opts)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(tdir, dumper.ManifestFile))
+}
+
+func Test_dump_CloseError(t *testing.T) {
+	conf := newEmptyAPIServer(t)
+	opts := discovery.DiscoveryOptions{LogWriter: io.Discard}
+
+	t.Run("dir", func(t *testing.T) {
+		tdir := t.TempDir()
+		// The manifest can't be created in place of a directory, not even by root.
+		require.NoError(t, os.Mkdir(filepath.Join(tdir, dumper.ManifestFile), 0o755))
+		_, err := dump(context.Background(), conf, output{dir: tdir, format: dumper.FormatJSON, manifest: true}, opts)
+		require.ErrorContains(t, err, "failed to close directory dumper")
+	})
+
+	t.Run("tar", func(t *testing.T) {
+		if _, err := os.Stat("/dev/full"); err != nil {
+			t.Skip("/dev/full is not available")
+		}
+		// Writes to /dev/full fail, the buffered tar footer is written on Close.
+		_, err := dump(context.Background(), conf, output{tarFile: "/dev/full", format: dumper.FormatJSON, bufferSize: dumper.DefaultBufferSize}, opts)
+		require.ErrorContains(t, err, "failed to close tar dumper")
+	})
+}
+
+func Test_exitCode(t *testing.T) {
+	cms := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secrets := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	failed := errors.New("failed")
+
+	for name, tc := range map[string]struct {
+		stats    []discovery.ResourceStat
+		err      error
+		expected int
+	}{
+		"success": {
+			stats:    []discovery.ResourceStat{{Resource: cms, Count: 2}, {Resource: secrets}},
+			expected: exitOK,
+		},
+		"success with skipped resources": {
+			stats:    []discovery.ResourceStat{{Resource: cms, Count: 2}, {Resource: secrets, Skipped: true, SkipReason: "no list verb"}},
+			expected: exitOK,
+		},
+		"partial failure": {
+			stats:    []discovery.ResourceStat{{Resource: cms, Count: 2}, {Resource: secrets, Failed: true}},
+			err:      failed,
+			expected: exitPartial,
+		},
+		"forbidden resources skipped": {
+			stats:    []discovery.ResourceStat{{Resource: cms, Count: 2}, {Resource: secrets, Skipped: true, SkipReason: discovery.SkipReasonForbidden}},
+			expected: exitPartial,
+		},
+		"total failure": {
+			stats:    []discovery.ResourceStat{{Resource: cms, Failed: true}, {Resource: secrets, Failed: true}},
+			err:      failed,
+			expected: exitFailure,
+		},
+		"failed discovery": {
+			err:      failed,
+			expected: exitFailure,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, exitCode(tc.stats, tc.err))
+		})
+	}
+}
+
+func Test_combineExitCodes(t *testing.T) {
+	for name, tc := range map[string]struct {
+		codes       []int
+		interrupted bool
+		expected    int
+	}{
+		"no contexts":               {expected: exitOK},
+		"single context":            {codes: []int{exitPartial}, expected: exitPartial},
+		"all successful":            {codes: []int{exitOK, exitOK}, expected: exitOK},
+		"all failed":                {codes: []int{exitFailure, exitFailure}, expected: exitFailure},
+		"successful and failed":     {codes: []int{exitOK, exitFailure}, expected: exitPartial},
+		"successful and partial":    {codes: []int{exitOK, exitPartial}, expected: exitPartial},
+		"interrupted":               {codes: []int{exitOK}, interrupted: true, expected: exitInterrupted},
+		"interrupted after failure": {codes: []int{exitFailure, exitPartial}, interrupted: true, expected: exitInterrupted},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, combineExitCodes(tc.codes, tc.interrupted))
+		})
+	}
+}
+
+func Test_configForContext(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: one
+  cluster:
+    server: https://one.example.com
+- name: two
+  cluster:
+    server: https://two.example.com
+users:
+- name: user
+  user:
+    token: token
+contexts:
+- name: one
+  context:
+    cluster: one
+    user: user
+- name: two
+  context:
+    cluster: two
+    user: user
+current-context: one
+`), 0o600))
+
+	kf := flag.Lookup("kubeconfig")
+	require.NotNil(t, kf, "kubeconfig flag is registered by controller-runtime")
+	old := kf.Value.String()
+	require.NoError(t, kf.Value.Set(kubeconfig))
+	t.Cleanup(func() { kf.Value.Set(old) })
+
+	conf, err := configForContext("")
+	require.NoError(t, err)
+	require.Equal(t, "https://one.example.com", conf.Host)
+
+	conf, err = configForContext("two")
+	require.NoError(t, err)
+	require.Equal(t, "https://two.example.com", conf.Host)
+
+	_, err = configForContext("three")
+	require.ErrorContains(t, err, `context "three" does not exist`)
+}
+
+func Test_dump_StdoutFlushedAfterBatch(t *testing.T) {
+	for name, out := range map[string]output{
+		"buffered":      {format: dumper.FormatJSON, bufferSize: dumper.DefaultBufferSize},
+		"buffered yaml": {format: dumper.FormatYAML, bufferSize: dumper.DefaultBufferSize},
+		"unbuffered":    {format: dumper.FormatJSON, bufferSize: dumper.DefaultBufferSize, noStdoutBuffer: true},
+		"gzip":          {format: dumper.FormatJSON, bufferSize: dumper.DefaultBufferSize, gzip: true, gzipLevel: gzip.DefaultCompression},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var stdout syncBuffer
+			// visible returns the number of ConfigMaps written to stdout so far.
+			visible := func() int {
+				raw := stdout.Bytes()
+				if out.gzip {
+					// The stream is flushed but not closed, reading stops at the missing trailer.
+					gr, err := gzip.NewReader(bytes.NewReader(raw))
+					if err != nil {
+						return 0
+					}
+					raw, _ = io.ReadAll(gr)
+				}
+				return bytes.Count(raw, []byte("cm-"))
+			}
+			var seen []int
+			conf := newConfigMapAPIServer(t, 3, func(int) error {
+				seen = append(seen, visible())
+				return nil
+			})
+
+			out.stdout = &stdout
+			_, err := dump(context.Background(), conf, out, discovery.DiscoveryOptions{LogWriter: io.Discard, BatchSize: 1})
+			require.NoError(t, err)
+			require.Equal(t, []int{0, 1, 2}, seen, "the objects of a batch are written to stdout before the next batch is listed")
+			require.Equal(t, 3, visible())
+		})
+	}
+}
+
+func Test_dump_GzipStdoutClosedOnFailure(t *testing.T) {
+	for name, tc := range map[string]struct {
+		// fail is called when listing the second ConfigMap. The context is cancelled with cancel.
+		fail func(cancel context.CancelFunc) error
+	}{
+		"failed list": {
+			fail: func(context.CancelFunc) error { return errors.New("list failed") },
+		},
+		"cancelled": {
+			fail: func(cancel context.CancelFunc) error {
+				cancel()
+				return errors.New("cancelled")
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			conf := newConfigMapAPIServer(t, 3, func(i int) error {
+				if i == 1 {
+					return tc.fail(cancel)
+				}
+				return nil
+			})
+
+			var stdout syncBuffer
+			out := output{format: dumper.FormatJSON, gzip: true, gzipLevel: gzip.DefaultCompression, bufferSize: dumper.DefaultBufferSize, stdout: &stdout}
+			_, err := dump(ctx, conf, out, discovery.DiscoveryOptions{LogWriter: io.Discard, BatchSize: 1})
+			require.Error(t, err)
+
+			// The gzip trailer is written, the truncated stream decodes without error.
+			gr, err := gzip.NewReader(bytes.NewReader(stdout.Bytes()))
+			require.NoError(t, err)
+			raw, err := io.ReadAll(gr)
+			require.NoError(t, err)
+			require.Equal(t, 1, bytes.Count(raw, []byte(`"name":"cm-`)))
+		})
+	}
+}
+
+func Test_newStdoutWriter(t *testing.T) {
+	var w bytes.Buffer
+
+	stdout, buf, gz, err := newStdoutWriter(&w, output{bufferSize: dumper.DefaultBufferSize, noStdoutBuffer: true})
+	require.NoError(t, err)
+	require.Nil(t, buf)
+	require.Nil(t, gz)
+	require.Same(t, &w, stdout, "-no-stdout-buffer writes directly to stdout")
+
+	stdout, buf, gz, err = newStdoutWriter(&w, output{bufferSize: dumper.DefaultBufferSize})
+	require.NoError(t, err)
+	require.NotNil(t, buf)
+	require.Nil(t, gz)
+	require.Same(t, buf, stdout)
+
+	stdout, buf, gz, err = newStdoutWriter(&w, output{bufferSize: dumper.DefaultBufferSize, noStdoutBuffer: true, gzip: true, gzipLevel: gzip.DefaultCompression})
+	require.NoError(t, err)
+	require.Nil(t, buf)
+	require.Same(t, gz, stdout)
+
+	_, _, _, err = newStdoutWriter(&w, output{gzip: true, gzipLevel: 42})
+	require.ErrorContains(t, err, "failed to create gzip writer")
+}
+
+func Test_flushAfter(t *testing.T) {
+	var w bytes.Buffer
+	buf := bufio.NewWriterSize(&w, 1<<20)
+	failed := errors.New("failed")
+	var calls int
+	df := flushAfter(func(*unstructured.UnstructuredList) error {
+		calls++
+		fmt.Fprintf(buf, "batch %d\n", calls)
+		if calls == 2 {
+			return failed
+		}
+		return nil
+	}, buf)
+
+	require.NoError(t, df(&unstructured.UnstructuredList{}))
+	require.Equal(t, "batch 1\n", w.String())
+	require.ErrorIs(t, df(&unstructured.UnstructuredList{}), failed)
+	require.Equal(t, "batch 1\nbatch 2\n", w.String(), "the buffer is also flushed if the dump fails")
+}
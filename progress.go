@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+)
+
+// progressRedrawInterval is the minimum time between two redraws of the progress line.
+const progressRedrawInterval = 100 * time.Millisecond
+
+// progressBar renders the progress of the dump on a single terminal line that is rewritten in place.
+// Writes to the progressBar, for example log lines, clear the progress line, are written to the terminal, and redraw the line.
+// It is safe for concurrent use.
+type progressBar struct {
+	mu sync.Mutex
+	f  *os.File
+
+	// resource is the resource of the last progress event.
+	resource string
+	// objects is the number of listed objects of all resources.
+	objects int
+	// resources is the number of completely listed resources.
+	resources int
+	// drawn is true if the progress line is currently shown.
+	drawn    bool
+	lastDraw time.Time
+}
+
+// newProgressBar returns a progressBar rendering to f.
+func newProgressBar(f *os.File) *progressBar {
+	return &progressBar{f: f}
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// update records the progress event and redraws the progress line.
+// It is meant to be used as DiscoveryOptions.Progress.
+func (p *progressBar) update(e discovery.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.resource = e.Resource.GroupResource().String()
+	if e.Complete {
+		p.resources++
+	} else {
+		p.objects += e.BatchCount
+	}
+	if time.Since(p.lastDraw) < progressRedrawInterval {
+		return
+	}
+	p.draw()
+}
+
+// Write clears the progress line, writes b, and redraws the progress line.
+// The line is only redrawn if b ends with a newline, so it is not appended to partially written lines.
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.f.Write(b)
+	if p.resource != "" && bytes.HasSuffix(b, []byte("\n")) {
+		p.draw()
+	}
+	return n, err
+}
+
+// finish clears the progress line. Progress events and writes after finish draw the line again.
+// It is safe to call on a nil progressBar.
+func (p *progressBar) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+}
+
+// draw writes the progress line, truncated to the width of the terminal.
+func (p *progressBar) draw() {
+	line := fmt.Sprintf("%s: %d objects dumped, %d resources done", p.resource, p.objects, p.resources)
+	if w, _, err := term.GetSize(int(p.f.Fd())); err == nil && w > 0 && len(line) >= w {
+		line = line[:w-1]
+	}
+	fmt.Fprintf(p.f, "\r\x1b[K%s", line)
+	p.drawn = true
+	p.lastDraw = time.Now()
+}
+
+// clear removes the progress line if it is shown.
+func (p *progressBar) clear() {
+	if !p.drawn {
+		return
+	}
+	io.WriteString(p.f, "\r\x1b[K")
+	p.drawn = false
+}
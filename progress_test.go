@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/bastjan/k8s-object-dumper/internal/pkg/discovery"
+)
+
+// newTestProgressBar returns a progressBar rendering to a temporary file and a function returning the output written since its last call.
+// The file is not a terminal, so the progress line is never truncated.
+func newTestProgressBar(t *testing.T) (*progressBar, func() string) {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "progress")
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	var offset int64
+	return newProgressBar(f), func() string {
+		t.Helper()
+		b, err := io.ReadAll(io.NewSectionReader(f, offset, 1<<20))
+		require.NoError(t, err)
+		offset += int64(len(b))
+		return string(b)
+	}
+}
+
+func Test_progressBar(t *testing.T) {
+	cms := schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	roles := schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}
+	p, output := newTestProgressBar(t)
+
+	p.update(discovery.ProgressEvent{Resource: cms, BatchCount: 2, Count: 2})
+	require.Equal(t, "\r\x1b[Kconfigmaps: 2 objects dumped, 0 resources done", output())
+
+	// Redraws are throttled, the progress is still recorded.
+	p.update(discovery.ProgressEvent{Resource: cms, Count: 2, Complete: true})
+	require.Empty(t, output())
+
+	p.lastDraw = time.Now().Add(-progressRedrawInterval)
+	p.update(discovery.ProgressEvent{Resource: roles, BatchCount: 3, Count: 3})
+	require.Equal(t, "\r\x1b[Kroles.rbac.authorization.k8s.io: 5 objects dumped, 1 resources done", output())
+
+	// Complete lines clear the progress line, are written, and the progress line is redrawn.
+	n, err := p.Write([]byte("log line\n"))
+	require.NoError(t, err)
+	require.Equal(t, len("log line\n"), n)
+	require.Equal(t, "\r\x1b[Klog line\n\r\x1b[Kroles.rbac.authorization.k8s.io: 5 objects dumped, 1 resources done", output())
+
+	// The progress line is not appended to partial lines.
+	_, err = p.Write([]byte("partial "))
+	require.NoError(t, err)
+	require.Equal(t, "\r\x1b[Kpartial ", output())
+	_, err = p.Write([]byte("line\n"))
+	require.NoError(t, err)
+	require.Equal(t, "line\n\r\x1b[Kroles.rbac.authorization.k8s.io: 5 objects dumped, 1 resources done", output())
+
+	p.finish()
+	require.Equal(t, "\r\x1b[K", output())
+	// The line is only cleared if it is shown.
+	p.finish()
+	require.Empty(t, output())
+}
+
+func Test_progressBar_WriteBeforeProgress(t *testing.T) {
+	p, output := newTestProgressBar(t)
+
+	_, err := p.Write([]byte("log line\n"))
+	require.NoError(t, err)
+	require.Equal(t, "log line\n", output(), "no progress line is drawn before the first progress event")
+
+	p.finish()
+	require.Empty(t, output())
+}
+
+func Test_progressBar_finishNil(t *testing.T) {
+	var p *progressBar
+	require.NotPanics(t, p.finish)
+}
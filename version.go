@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// Set by the linker, see the Makefile and .goreleaser.yml.
+// Empty values are filled from the build info embedded by the Go toolchain.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// readBuildInfo returns the build info embedded by the Go toolchain. Replaced in tests.
+var readBuildInfo = debug.ReadBuildInfo
+
+// buildInfo returns the version, git commit, and build date of the binary.
+// Unknown values are returned as "unknown".
+func buildInfo() (v, c, d string) {
+	v, c, d = version, commit, date
+	if bi, ok := readBuildInfo(); ok {
+		if v == "" && bi.Main.Version != "" {
+			v = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && c == "":
+				c = s.Value
+			case s.Key == "vcs.time" && d == "":
+				d = s.Value
+			}
+		}
+	}
+	for _, s := range []*string{&v, &c, &d} {
+		if *s == "" {
+			*s = "unknown"
+		}
+	}
+	return v, c, d
+}
+
+// printVersion writes the version, git commit, and build date of the binary.
+func printVersion(w io.Writer) {
+	v, c, d := buildInfo()
+	fmt.Fprintf(w, "k8s-object-dumper %s (commit %s, built %s)\n", v, c, d)
+}
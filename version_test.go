@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// setBuildInfo sets the linker variables and the embedded build info for the test.
+func setBuildInfo(t *testing.T, v, c, d string, bi *debug.BuildInfo) {
+	t.Helper()
+	oldVersion, oldCommit, oldDate, oldRead := version, commit, date, readBuildInfo
+	t.Cleanup(func() { version, commit, date, readBuildInfo = oldVersion, oldCommit, oldDate, oldRead })
+	version, commit, date = v, c, d
+	readBuildInfo = func() (*debug.BuildInfo, bool) { return bi, bi != nil }
+}
+
+func Test_printVersion(t *testing.T) {
+	embedded := &debug.BuildInfo{
+		Main: debug.Module{Version: "v1.2.3"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2024-01-01T00:00:00Z"},
+		},
+	}
+
+	for name, tc := range map[string]struct {
+		version, commit, date string
+		buildInfo             *debug.BuildInfo
+		expected              string
+	}{
+		"ldflags": {
+			version:   "v2.0.0",
+			commit:    "def456",
+			date:      "2024-02-01",
+			buildInfo: embedded,
+			expected:  "k8s-object-dumper v2.0.0 (commit def456, built 2024-02-01)\n",
+		},
+		"build info": {
+			buildInfo: embedded,
+			expected:  "k8s-object-dumper v1.2.3 (commit abc123, built 2024-01-01T00:00:00Z)\n",
+		},
+		"partial ldflags": {
+			version:   "v2.0.0",
+			buildInfo: embedded,
+			expected:  "k8s-object-dumper v2.0.0 (commit abc123, built 2024-01-01T00:00:00Z)\n",
+		},
+		"build info without vcs settings": {
+			buildInfo: &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}},
+			expected:  "k8s-object-dumper (devel) (commit unknown, built unknown)\n",
+		},
+		"unknown": {
+			expected: "k8s-object-dumper unknown (commit unknown, built unknown)\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			setBuildInfo(t, tc.version, tc.commit, tc.date, tc.buildInfo)
+			var out bytes.Buffer
+			printVersion(&out)
+			require.Equal(t, tc.expected, out.String())
+		})
+	}
+}